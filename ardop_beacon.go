@@ -0,0 +1,60 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport/ardop"
+)
+
+// The minimum interval between the ID frames sent by an ARDOP listener.
+const minArdopBeaconInterval = time.Minute
+
+func checkArdopBeaconInterval(secs int) error {
+	if d := time.Duration(secs) * time.Second; d != 0 && d < minArdopBeaconInterval {
+		return fmt.Errorf("beacon interval %s is less than the minimum %s", d, minArdopBeaconInterval)
+	}
+	return nil
+}
+
+var (
+	ardopBeaconsMu sync.Mutex
+	ardopBeacons   = map[string]func(){} // Stop funcs of the active beacons by listener name.
+)
+
+// startArdopBeacon makes the TNC send an ID frame (followed by CW ID if enabled) every interval while the
+// listener is active, until stopped by stopArdopBeacon.
+func startArdopBeacon(name string, tnc *ardop.TNC, every time.Duration) error {
+	stopArdopBeacon(name)
+
+	ardopBeaconsMu.Lock()
+	defer ardopBeaconsMu.Unlock()
+
+	debugf("%s: BEACON %s", name, every)
+	if err := tnc.BeaconEvery(every); err != nil {
+		return err
+	}
+	ardopBeacons[name] = func() {
+		debugf("%s: BEACON 0", name)
+		if err := tnc.BeaconEvery(0); err != nil {
+			log.Printf("Unable to stop %s beacon: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// stopArdopBeacon stops the beacon of the given listener, if active.
+func stopArdopBeacon(name string) {
+	ardopBeaconsMu.Lock()
+	defer ardopBeaconsMu.Unlock()
+	if stop, ok := ardopBeacons[name]; ok {
+		stop()
+		delete(ardopBeacons, name)
+	}
+}
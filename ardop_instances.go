@@ -0,0 +1,337 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/la5nta/wl2k-go/transport"
+	"github.com/la5nta/wl2k-go/transport/ardop"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// ardopTNCs holds the initialized ARDOP TNCs keyed by address. Guarded by tncMu.
+//
+// adTNC points to the TNC of the default instance (config.Ardop).
+var ardopTNCs = make(map[string]*ardop.TNC)
+
+// ardopConfig returns the config of the named ARDOP instance. The empty name is the default instance (config.Ardop).
+func ardopConfig(name string) (cfg.ArdopConfig, error) {
+	if name == "" {
+		return config.Ardop, nil
+	}
+	conf, ok := config.ArdopInstances[name]
+	if !ok {
+		return conf, fmt.Errorf("ARDOP TNC '%s' not defined in config", name)
+	}
+	return conf, nil
+}
+
+// ardopConfigForURL returns the config of the ARDOP instance selected by the given URL.
+//
+// The URL parameter ?tnc= selects a named instance from config.ArdopInstances. Otherwise, a
+// non-empty host selects the TNC at that address using the default instance's settings.
+func ardopConfigForURL(url *transport.URL) (cfg.ArdopConfig, error) {
+	if name := url.Params.Get("tnc"); name != "" {
+		return ardopConfig(name)
+	}
+	conf := config.Ardop
+	if url.Host != "" {
+		conf.Addr = url.Host
+	}
+	return conf, nil
+}
+
+// ardopConfigOrDefault is like ardopConfigForURL, but falls back to the default instance's config on error.
+func ardopConfigOrDefault(url *transport.URL) cfg.ArdopConfig {
+	conf, err := ardopConfigForURL(url)
+	if err != nil {
+		return config.Ardop
+	}
+	return conf
+}
+
+func ardopKey(conf cfg.ArdopConfig) string { return conf.Addr }
+
+// ardopTNCForURL returns the initialized ARDOP TNC selected by the given URL, if any.
+func ardopTNCForURL(url *transport.URL) (*ardop.TNC, bool) {
+	conf, err := ardopConfigForURL(url)
+	if err != nil {
+		return nil, false
+	}
+	return ardopTNC(conf)
+}
+
+// ardopTNC returns the initialized ARDOP TNC for the given config, if any.
+func ardopTNC(conf cfg.ArdopConfig) (*ardop.TNC, bool) {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	tnc, ok := ardopTNCs[ardopKey(conf)]
+	return tnc, ok
+}
+
+// allArdopTNCs returns all initialized ARDOP TNCs.
+func allArdopTNCs() []*ardop.TNC {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+
+	keys := make([]string, 0, len(ardopTNCs))
+	for key := range ardopTNCs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tncs := make([]*ardop.TNC, len(keys))
+	for i, key := range keys {
+		tncs[i] = ardopTNCs[key]
+	}
+	return tncs
+}
+
+func initArdopTNC() error {
+	_, err := openArdopTNC(config.Ardop)
+	return err
+}
+
+// openArdopTNC returns the ARDOP TNC for the given config, (re)initializing it if needed.
+func openArdopTNC(conf cfg.ArdopConfig) (*ardop.TNC, error) {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+
+	key := ardopKey(conf)
+	isDefault := key == ardopKey(config.Ardop)
+
+	tnc := ardopTNCs[key]
+	if tnc != nil && tnc.Ping() == nil {
+		return tnc, nil
+	}
+
+	// The TNC might have crashed or been restarted. Tear down the stale TNC, including any active
+	// listener, and re-open it. The listener is re-established by the listener hub.
+	if tnc != nil {
+		log.Printf("ARDOP TNC (%s) connection lost, reconnecting...", key)
+		tnc.Close()
+		delete(ardopTNCs, key)
+		if isDefault {
+			adTNC = nil
+		}
+	}
+
+	// Fail before grabbing the sound device if the PTT rig is missing
+	ptt, err := pttRig(MethodArdop, conf.PTTControl, conf.Rig)
+	if err != nil {
+		return nil, err
+	}
+
+	tnc, err = ardop.OpenTCP(conf.Addr, fOptions.MyCall, config.Locator)
+	if err != nil {
+		return nil, tncUnavailableError{fmt.Errorf("ARDOP TNC initialization failed (tcp %s): %s", conf.Addr, err)}
+	}
+
+	ardopTNCs[key] = tnc
+	if isDefault {
+		adTNC = tnc
+	}
+
+	if err := setupArdopTNC(tnc, conf, ptt); err != nil {
+		return nil, err
+	}
+
+	// Non-default instances are dialed directly (see dialerForURL)
+	if isDefault {
+		transport.RegisterDialer("ardop", tnc)
+	}
+
+	watchTNCEvents(MethodArdop+":"+key, tnc)
+	return tnc, nil
+}
+
+// ardopSetupTNC is the part of the ARDOP TNC configured by setupArdopTNC.
+type ardopSetupTNC interface {
+	SetARQBandwidth(bw ardop.Bandwidth) error
+	SetCWID(enabled bool) error
+	Version() (string, error)
+	SetPTT(ptt transport.PTTController)
+}
+
+// setupArdopTNC applies the given config to a newly opened ARDOP TNC, and hands it the PTT controller (if any).
+func setupArdopTNC(tnc ardopSetupTNC, conf cfg.ArdopConfig, ptt transport.PTTController) error {
+	key := ardopKey(conf)
+	if bw := arqBandwidth(conf); !bw.IsZero() {
+		debugf("ARDOP TNC (%s): ARQ bandwidth %s", key, bw)
+		if err := tnc.SetARQBandwidth(bw); err != nil {
+			return fmt.Errorf("Unable to set ARQ bandwidth for ardop TNC: %s", err)
+		}
+	}
+
+	debugf("ARDOP TNC (%s): CWID %t", key, conf.CWID)
+	if err := tnc.SetCWID(conf.CWID); err != nil {
+		return fmt.Errorf("Unable to configure CWID for ardop TNC: %s", err)
+	}
+
+	if v, err := tnc.Version(); err != nil {
+		return fmt.Errorf("ARDOP TNC initialization failed: %s", err)
+	} else {
+		log.Printf("ARDOP TNC (%s) initialized on %s", v, key)
+	}
+
+	if ptt != nil {
+		tnc.SetPTT(ptt)
+	}
+	return nil
+}
+
+// setARQBandwidth applies the ARQ bandwidth given by the URL parameter ?bw= (e.g. 500MAX) to the ARDOP
+// TNC selected by the URL. If the instance has a listen bandwidth, the configured ARQ bandwidth is applied
+// when the parameter is not given, as the TNC might be set to the listen bandwidth.
+//
+// The returned func restores the instance's configured bandwidth (or the TNC's previous bandwidth if not
+// configured or if the instance has a listen bandwidth).
+func setARQBandwidth(url *transport.URL) (revert func(), err error) {
+	noop := func() {}
+	if url.Scheme != MethodArdop {
+		return noop, nil
+	}
+
+	conf, err := ardopConfigForURL(url)
+	if err != nil {
+		return noop, err
+	}
+	bw, err := connectARQBandwidth(conf, url.Params.Get("bw"))
+	if err != nil || bw.IsZero() {
+		return noop, err
+	}
+
+	tnc, ok := ardopTNC(conf)
+	if !ok {
+		return noop, fmt.Errorf("ARDOP TNC not initialized")
+	}
+	return applyARQBandwidth(tnc, conf, bw)
+}
+
+// connectARQBandwidth returns the ARQ bandwidth to apply for an outbound connect: the bandwidth given by
+// param (the URL parameter ?bw=), or the configured ARQ bandwidth if the instance has a listen bandwidth.
+//
+// A zero bandwidth means that the TNC's bandwidth should be left as is.
+func connectARQBandwidth(conf cfg.ArdopConfig, param string) (ardop.Bandwidth, error) {
+	switch {
+	case param != "":
+		bw, err := ardop.BandwidthFromString(param)
+		if err != nil {
+			return bw, fmt.Errorf("Invalid ARQ bandwidth '%s': %s", param, err)
+		}
+		return bw, nil
+	case !conf.ListenBandwidth.IsZero():
+		return arqBandwidth(conf), nil
+	default:
+		return ardop.Bandwidth{}, nil
+	}
+}
+
+// arqBandwidthTNC is the part of the ARDOP TNC used to change the ARQ bandwidth.
+type arqBandwidthTNC interface {
+	ARQBandwidth() (ardop.Bandwidth, error)
+	SetARQBandwidth(bw ardop.Bandwidth) error
+}
+
+// applyARQBandwidth sets the TNC's ARQ bandwidth to bw, and returns a func restoring the instance's
+// configured bandwidth (or the TNC's previous bandwidth, see setARQBandwidth).
+func applyARQBandwidth(tnc arqBandwidthTNC, conf cfg.ArdopConfig, bw ardop.Bandwidth) (revert func(), err error) {
+	noop := func() {}
+	prev := arqBandwidth(conf)
+	if prev.IsZero() || !conf.ListenBandwidth.IsZero() {
+		if prev, err = tnc.ARQBandwidth(); err != nil {
+			return noop, fmt.Errorf("Unable to get ARQ bandwidth: %s", err)
+		}
+	}
+	if prev == bw {
+		return noop, nil
+	}
+
+	if err := tnc.SetARQBandwidth(bw); err != nil {
+		return noop, fmt.Errorf("Unable to set ARQ bandwidth: %s", err)
+	}
+	log.Printf("ARQ bandwidth set to %s", bw)
+
+	return func() {
+		if err := tnc.SetARQBandwidth(prev); err != nil {
+			log.Printf("Unable to restore ARQ bandwidth to %s: %s", prev, err)
+		}
+	}, nil
+}
+
+// arqBandwidth returns the configured ARQ bandwidth of the ARDOP instance, forced if ForceARQBandwidth
+// is set.
+func arqBandwidth(conf cfg.ArdopConfig) ardop.Bandwidth {
+	bw := conf.ARQBandwidth
+	if conf.ForceARQBandwidth && !bw.IsZero() {
+		bw.Forced = true
+	}
+	return bw
+}
+
+// robustMode returns true if the connect should use robust modes only, as given by the URL parameter
+// ?robust= (ardop only) or the ARDOP instance's config.
+func robustMode(url *transport.URL) (bool, error) {
+	if url.Scheme != MethodArdop {
+		return false, nil
+	}
+	if v := url.Params.Get("robust"); v != "" {
+		robust, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("Invalid robust parameter: %s", err)
+		}
+		return robust, nil
+	}
+	return ardopConfigOrDefault(url).Robust, nil
+}
+
+// validateArdopConfig returns an error if the given ARDOP instance config has invalid values.
+func validateArdopConfig(conf cfg.ArdopConfig) error {
+	return checkArdopBeaconInterval(conf.BeaconInterval)
+}
+
+// setCWID applies the CWID setting given by the URL parameter ?cwid= (ardop only) to the ARDOP TNC
+// selected by the URL.
+//
+// The returned func restores the instance's configured CWID setting.
+func setCWID(url *transport.URL) (revert func(), err error) {
+	noop := func() {}
+	str := url.Params.Get("cwid")
+	if url.Scheme != MethodArdop || str == "" {
+		return noop, nil
+	}
+
+	cwid, err := strconv.ParseBool(str)
+	if err != nil {
+		return noop, fmt.Errorf("Invalid cwid parameter: %s", err)
+	}
+
+	conf, err := ardopConfigForURL(url)
+	if err != nil {
+		return noop, err
+	}
+	if cwid == conf.CWID {
+		return noop, nil
+	}
+	tnc, ok := ardopTNC(conf)
+	if !ok {
+		return noop, fmt.Errorf("ARDOP TNC not initialized")
+	}
+	if err := tnc.SetCWID(cwid); err != nil {
+		return noop, fmt.Errorf("Unable to configure CWID: %s", err)
+	}
+	log.Printf("ARDOP CWID set to %t", cwid)
+
+	return func() {
+		if err := tnc.SetCWID(conf.CWID); err != nil {
+			log.Printf("Unable to restore CWID setting: %s", err)
+		}
+	}, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/la5nta/wl2k-go/rigcontrol/hamlib"
+	"github.com/la5nta/wl2k-go/transport"
+	"github.com/la5nta/wl2k-go/transport/ardop"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestConnectARQBandwidth(t *testing.T) {
+	listening := cfg.ArdopConfig{ARQBandwidth: ardop.Bandwidth2000Max, ListenBandwidth: ardop.Bandwidth500Max}
+	forced := listening
+	forced.ForceARQBandwidth = true
+
+	tests := []struct {
+		conf    cfg.ArdopConfig
+		param   string
+		want    ardop.Bandwidth
+		wantErr bool
+	}{
+		{cfg.ArdopConfig{}, "", ardop.Bandwidth{}, false},
+		{cfg.ArdopConfig{}, "500MAX", ardop.Bandwidth500Max, false},
+		{cfg.ArdopConfig{}, "1000forced", ardop.Bandwidth1000Forced, false},
+		{cfg.ArdopConfig{}, "700MAX", ardop.Bandwidth{}, true},
+		{cfg.ArdopConfig{ARQBandwidth: ardop.Bandwidth2000Max}, "", ardop.Bandwidth{}, false}, // Already set at init
+		{listening, "", ardop.Bandwidth2000Max, false},
+		{listening, "500MAX", ardop.Bandwidth500Max, false},
+		{forced, "", ardop.Bandwidth2000Forced, false},
+	}
+	for _, tt := range tests {
+		got, err := connectARQBandwidth(tt.conf, tt.param)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("connectARQBandwidth(%+v, %q): unexpected error: %v", tt.conf, tt.param, err)
+		} else if !tt.wantErr && got != tt.want {
+			t.Errorf("connectARQBandwidth(%+v, %q) = %s, want %s", tt.conf, tt.param, got, tt.want)
+		}
+	}
+}
+
+type fakeArdopTNC struct {
+	bw   ardop.Bandwidth
+	cwid bool
+	ptt  transport.PTTController
+}
+
+func (t *fakeArdopTNC) ARQBandwidth() (ardop.Bandwidth, error)   { return t.bw, nil }
+func (t *fakeArdopTNC) SetARQBandwidth(bw ardop.Bandwidth) error { t.bw = bw; return nil }
+func (t *fakeArdopTNC) SetCWID(enabled bool) error               { t.cwid = enabled; return nil }
+func (t *fakeArdopTNC) Version() (string, error)                 { return "ardopc 1.0.4", nil }
+func (t *fakeArdopTNC) SetPTT(ptt transport.PTTController)       { t.ptt = ptt }
+
+func TestApplyARQBandwidth(t *testing.T) {
+	tests := []struct {
+		conf        cfg.ArdopConfig
+		tncBW       ardop.Bandwidth // The TNC's bandwidth before the connect.
+		bw          ardop.Bandwidth
+		wantRestore ardop.Bandwidth // The TNC's bandwidth after the connect.
+	}{
+		{ // Listen bandwidth restored after the connect
+			cfg.ArdopConfig{ARQBandwidth: ardop.Bandwidth2000Max, ListenBandwidth: ardop.Bandwidth500Max},
+			ardop.Bandwidth500Max, ardop.Bandwidth2000Max, ardop.Bandwidth500Max,
+		},
+		{ // Not listening
+			cfg.ArdopConfig{ARQBandwidth: ardop.Bandwidth2000Max, ListenBandwidth: ardop.Bandwidth500Max},
+			ardop.Bandwidth2000Max, ardop.Bandwidth2000Max, ardop.Bandwidth2000Max,
+		},
+		{ // Configured bandwidth restored
+			cfg.ArdopConfig{ARQBandwidth: ardop.Bandwidth2000Max},
+			ardop.Bandwidth1000Max, ardop.Bandwidth500Max, ardop.Bandwidth2000Max,
+		},
+		{ // Previous bandwidth restored if not configured
+			cfg.ArdopConfig{},
+			ardop.Bandwidth1000Max, ardop.Bandwidth500Max, ardop.Bandwidth1000Max,
+		},
+	}
+	for i, tt := range tests {
+		tnc := &fakeArdopTNC{bw: tt.tncBW}
+		// Alternate between connects and listening a couple of times
+		for n := 0; n < 2; n++ {
+			revert, err := applyARQBandwidth(tnc, tt.conf, tt.bw)
+			if err != nil {
+				t.Fatalf("%d: unexpected error: %s", i, err)
+			}
+			if tnc.bw != tt.bw {
+				t.Errorf("%d: got bandwidth %s during connect, want %s", i, tnc.bw, tt.bw)
+			}
+			revert()
+			if tnc.bw != tt.wantRestore {
+				t.Errorf("%d: got bandwidth %s after connect, want %s", i, tnc.bw, tt.wantRestore)
+			}
+		}
+	}
+}
+
+// Regression test: the ARDOP TNC, not the WINMOR TNC, gets the rig for PTT control.
+func TestSetupArdopTNCPTT(t *testing.T) {
+	defer func(r map[string]hamlib.VFO) { rigs = r }(rigs)
+	ft991 := &fakeVFO{}
+	rigs = map[string]hamlib.VFO{"ft991": ft991}
+	if wmTNC != nil {
+		t.Fatal("WINMOR TNC initialized")
+	}
+
+	conf := cfg.ArdopConfig{Addr: "localhost:8515", ARQBandwidth: ardop.Bandwidth500Max, CWID: true, PTTControl: true, Rig: "ft991"}
+	ptt, err := pttRig(MethodArdop, conf.PTTControl, conf.Rig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tnc := &fakeArdopTNC{}
+	if err := setupArdopTNC(tnc, conf, ptt); err != nil {
+		t.Fatalf("setupArdopTNC: unexpected error: %s", err)
+	}
+	if tnc.bw != ardop.Bandwidth500Max || !tnc.cwid {
+		t.Errorf("Got bandwidth %s and CWID %t", tnc.bw, tnc.cwid)
+	}
+	if tnc.ptt == nil {
+		t.Fatal("ARDOP TNC did not get the PTT rig")
+	}
+	if err := tnc.ptt.SetPTT(true); err != nil || !ft991.ptt {
+		t.Errorf("SetPTT(true): got PTT %t, error %v", ft991.ptt, err)
+	}
+
+	// No PTT control
+	conf.PTTControl = false
+	ptt, _ = pttRig(MethodArdop, conf.PTTControl, conf.Rig)
+	tnc = &fakeArdopTNC{}
+	if err := setupArdopTNC(tnc, conf, ptt); err != nil || tnc.ptt != nil {
+		t.Errorf("setupArdopTNC without PTT control: got PTT %v, error %v", tnc.ptt, err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// The default duration after a heard frame during which a packet channel is considered busy.
+const defaultPacketBusyWindow = 3 * time.Second
+
+// packetBusyChecker is a transport.BusyChannelChecker for packet channels without carrier detect.
+//
+// The channel is reported busy while frames are heard, i.e. within the busy window of the last heard frame.
+type packetBusyChecker struct {
+	window time.Duration
+	stop   func() error
+
+	mu        sync.Mutex
+	lastHeard time.Time
+}
+
+func (c *packetBusyChecker) Busy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastHeard) < c.window
+}
+
+// heard marks the channel as busy for the duration of the busy window.
+func (c *packetBusyChecker) heard() {
+	c.mu.Lock()
+	c.lastHeard = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *packetBusyChecker) Close() error { return c.stop() }
+
+var (
+	ax25BusyMu      sync.Mutex
+	ax25BusyChecker *packetBusyChecker // The busy checker of the axport used by ax25 connects, if started.
+
+	dcdUnsupported sync.Map // Transports for which lack of carrier detect has been logged.
+)
+
+// packetBusyWindow returns the busy window given by the config value (unit is seconds). Zero means disabled.
+func packetBusyWindow(secs int) time.Duration {
+	switch {
+	case secs < 0:
+		return 0
+	case secs == 0:
+		return defaultPacketBusyWindow
+	default:
+		return time.Duration(secs) * time.Second
+	}
+}
+
+// initAX25BusyChecker starts monitoring the configured axport for heard frames (see packetBusyChecker).
+//
+// A nil TNC is returned if busy detection is disabled or not supported on this interface, in which
+// case the channel is never reported busy.
+func initAX25BusyChecker() (TNC, error) {
+	window := packetBusyWindow(config.AX25.BusyWindow)
+	if window == 0 {
+		return nil, nil
+	}
+
+	ax25BusyMu.Lock()
+	defer ax25BusyMu.Unlock()
+	if ax25BusyChecker != nil {
+		return ax25BusyChecker, nil
+	}
+
+	c := &packetBusyChecker{window: window}
+	stop, err := monitorAX25(config.AX25.Port, c.heard)
+	if err != nil {
+		logDCDUnsupported(MethodAX25, err.Error())
+		return nil, nil
+	}
+	c.stop = stop
+	ax25BusyChecker = c
+	return c, nil
+}
+
+// closeAX25BusyChecker stops monitoring the axport, if started.
+func closeAX25BusyChecker() {
+	ax25BusyMu.Lock()
+	defer ax25BusyMu.Unlock()
+	if ax25BusyChecker != nil {
+		ax25BusyChecker.Close()
+		ax25BusyChecker = nil
+	}
+}
+
+// logDCDUnsupported logs (once per transport) that busy channel detection is not available for the given transport.
+func logDCDUnsupported(method, reason string) {
+	if _, logged := dcdUnsupported.LoadOrStore(method, true); logged {
+		return
+	}
+	log.Printf("Carrier detect is unsupported on this %s interface (%s), not checking for busy channel.", method, reason)
+}
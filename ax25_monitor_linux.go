@@ -0,0 +1,144 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	ethPAX25       = 0x0002 // ETH_P_AX25
+	packetOutgoing = 4      // PACKET_OUTGOING
+)
+
+// The locations of the axports file, in order of preference.
+var axportsPaths = []string{"/etc/ax25/axports", "/usr/local/etc/ax25/axports"}
+
+// monitorAX25 calls heard for each frame received on the network interface of the given axport,
+// until stop is called.
+//
+// This requires permission to open a raw packet socket (CAP_NET_RAW).
+func monitorAX25(axport string, heard func()) (stop func() error, err error) {
+	ifi, err := axportInterface(axport)
+	if err != nil {
+		return nil, err
+	}
+
+	proto := htons(ethPAX25)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open packet socket: %s", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("unable to bind packet socket to %s: %s", ifi.Name, err)
+	}
+
+	// Wake up the read loop regularly, so that it can be stopped
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	var stopped int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1024)
+		for atomic.LoadInt32(&stopped) == 0 {
+			n, from, err := syscall.Recvfrom(fd, buf, 0)
+			switch {
+			case err == syscall.EAGAIN || err == syscall.EINTR:
+				continue
+			case err != nil:
+				return
+			}
+			if sa, ok := from.(*syscall.SockaddrLinklayer); ok && sa.Pkttype == packetOutgoing {
+				continue // Our own transmissions
+			}
+			if n > 0 {
+				heard()
+			}
+		}
+	}()
+
+	return func() error {
+		atomic.StoreInt32(&stopped, 1)
+		<-done
+		return syscall.Close(fd)
+	}, nil
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+// axportInterface returns the network interface of the given axport, by matching the axport's
+// callsign (as defined in the axports file) with the interface's hardware address.
+func axportInterface(axport string) (*net.Interface, error) {
+	callsign, err := axportCallsign(axport)
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i, ifi := range ifaces {
+		if len(ifi.HardwareAddr) == 7 && strings.EqualFold(decodeAX25Addr(ifi.HardwareAddr), callsign) {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no network interface for axport %s (%s)", axport, callsign)
+}
+
+// axportCallsign returns the callsign of the given axport, as defined in the axports file.
+func axportCallsign(axport string) (string, error) {
+	for _, path := range axportsPaths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if fields[0] == axport {
+				return strings.TrimSuffix(strings.ToUpper(fields[1]), "-0"), nil
+			}
+		}
+		return "", fmt.Errorf("axport %s not found in %s", axport, path)
+	}
+	return "", fmt.Errorf("axports file not found")
+}
+
+// decodeAX25Addr decodes the shifted AX.25 address (callsign and SSID) of an AX.25 network interface.
+func decodeAX25Addr(addr net.HardwareAddr) string {
+	var call []byte
+	for _, b := range addr[:6] {
+		if c := b >> 1; c != ' ' {
+			call = append(call, c)
+		}
+	}
+	if ssid := (addr[6] >> 1) & 0x0f; ssid > 0 {
+		return fmt.Sprintf("%s-%d", call, ssid)
+	}
+	return string(call)
+}
@@ -0,0 +1,13 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+import "fmt"
+
+func monitorAX25(axport string, heard func()) (stop func() error, err error) {
+	return nil, fmt.Errorf("not supported on this platform")
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// The prefix of freq parameters referring to a named channel (e.g. freq=@40m-winlink).
+const channelPrefix = "@"
+
+// lookupChannel returns the channel named by the given freq parameter.
+//
+// ok is false if freq is not a channel reference.
+func lookupChannel(freq string) (name string, ch cfg.Channel, ok bool, err error) {
+	if !strings.HasPrefix(freq, channelPrefix) {
+		return "", cfg.Channel{}, false, nil
+	}
+	name = freq[len(channelPrefix):]
+	ch, found := config.Channels[name]
+	if !found {
+		return name, ch, true, fmt.Errorf("Unknown channel '%s'", name)
+	}
+	return name, ch, true, nil
+}
+
+// withChannelScheme prepends the scheme of the channel referred to by the connect string's freq
+// parameter (e.g. LA1B?freq=@40m-winlink), if the connect string has no scheme.
+func withChannelScheme(connectStr string) (string, error) {
+	str, params := splitParams(connectStr)
+	if strings.Contains(str, "://") || params == "" {
+		return connectStr, nil
+	}
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return connectStr, nil // Let transport.ParseURL report it
+	}
+
+	name, ch, ok, err := lookupChannel(values.Get("freq"))
+	switch {
+	case !ok:
+		return connectStr, nil
+	case err != nil:
+		return "", err
+	case ch.Scheme == "":
+		return "", fmt.Errorf("Channel '%s' has no scheme, unable to connect to '%s'", name, str)
+	}
+	return ch.Scheme + ":///" + strings.TrimLeft(connectStr, "/"), nil
+}
+
+// applyChannel replaces a channel reference in the URL's freq parameter with the channel's dial
+// frequency, and sets the channel's bandwidth and mode unless ?bw= or ?mode= is given.
+func applyChannel(url *transport.URL) error {
+	name, ch, ok, err := lookupChannel(url.Params.Get("freq"))
+	if !ok || err != nil {
+		return err
+	}
+
+	scheme := strings.ToLower(ch.Scheme)
+	if scheme == "vara" {
+		scheme = MethodVaraHF
+	}
+	if scheme != "" && scheme != url.Scheme {
+		return fmt.Errorf("Channel '%s' is a %s channel, not %s", name, scheme, url.Scheme)
+	}
+	if ch.Freq <= 0 {
+		return fmt.Errorf("Channel '%s' is missing freq", name)
+	}
+
+	url.Params.Set("freq", strconv.FormatFloat(ch.Freq, 'f', -1, 64))
+	if ch.Bandwidth != "" && url.Params.Get("bw") == "" {
+		url.Params.Set("bw", ch.Bandwidth)
+	}
+	if ch.Mode != "" && url.Params.Get("mode") == "" {
+		url.Params.Set("mode", ch.Mode)
+	}
+	return nil
+}
@@ -41,6 +41,15 @@ func LoadConfig(path string, fallback cfg.Config) (config cfg.Config, err error)
 		config.Pactor = cfg.DefaultConfig.Pactor
 	}
 
+	if err := validateArdopConfig(config.Ardop); err != nil {
+		return config, fmt.Errorf("Invalid ardop config: %s", err)
+	}
+	for name, conf := range config.ArdopInstances {
+		if err := validateArdopConfig(conf); err != nil {
+			return config, fmt.Errorf("Invalid config for ardop instance '%s': %s", name, err)
+		}
+	}
+
 	//TODO: Remove after some release cycles (2019-09-29)
 	if config.GPSdAddrLegacy != "" {
 		config.GPSd.Addr = config.GPSdAddrLegacy
@@ -5,236 +5,1736 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/harenber/ptc-go/ptc"
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/rigcontrol/hamlib"
 	"github.com/la5nta/wl2k-go/transport"
 	"github.com/la5nta/wl2k-go/transport/ardop"
 	"github.com/la5nta/wl2k-go/transport/winmor"
 
+	"github.com/la5nta/pat/cfg"
+	"github.com/la5nta/pat/internal/agwpe"
+	"github.com/la5nta/pat/internal/vara"
+
 	// Register other dialers
+	_ "github.com/la5nta/pat/internal/kiss"
+	_ "github.com/la5nta/pat/internal/telnets"
 	_ "github.com/la5nta/wl2k-go/transport/ax25"
 	_ "github.com/la5nta/wl2k-go/transport/telnet"
 )
 
 var (
-	wmTNC  *winmor.TNC // Pointer to the WINMOR TNC used by Listen and Connect
-	adTNC  *ardop.TNC  // Pointer to the ARDOP TNC used by Listen and Connect
-	pModem *pactor.Modem
+	wmTNC     *winmor.TNC // Pointer to the WINMOR TNC used by Listen and Connect
+	adTNC     *ardop.TNC  // Pointer to the ARDOP TNC used by Listen and Connect
+	pModem    *pactor.Modem
+	varaHFTNC *vara.Modem // Pointer to the VARA HF modem used by Listen and Connect
+	varaFMTNC *vara.Modem // Pointer to the VARA FM modem used by Listen and Connect
+
+	connectOrder *ConnectOrder // The order used by connectAny
+
+	showConnectURL bool // Log the resolved connect URL before dialing (see connect --show-url)
+
+	tncMu sync.Mutex // Ensures the TNCs and modems are initialized by one goroutine at a time
 )
 
+// The default delay between connect retries, used if not given in config or URL.
+const defaultRetryBackoff = 10 * time.Second
+
 func hasSSID(str string) bool { return strings.Contains(str, "-") }
 
-func connectAny(connectStr ...string) bool {
-	for _, str := range connectStr {
-		if Connect(str) {
+// connectAny tries the given connect strings, stopping at the first successful session.
+//
+// The connect strings are tried in the order decided by orderConnectStrs. If parallel is true,
+// all connect strings are dialed concurrently and the first connection to be established
+// is used for the exchange. The losing attempts are aborted and closed. A single connect string naming a
+// fallback chain (see config.ConnectFallbacks) is expanded to the chain's links (see connectChain).
+//
+// The returned string is the connect string that won. If all connect strings failed, the error of
+// the last attempt is returned (see connectExitCode).
+func connectAny(parallel bool, connectStr ...string) (winner string, err error) {
+	if len(connectStr) == 1 {
+		if links, ok := config.ConnectFallbacks[connectStr[0]]; ok {
+			return connectChain(connectStr[0], links)
+		}
+		if scheme, ok := isAutoConnectStr(connectStr[0]); ok {
+			strs, err := autoConnectStrs(scheme)
+			if err != nil {
+				return "", err
+			}
+			log.Printf("Auto connect: trying %s", strings.Join(strs, ", "))
+			return connectAny(parallel, strs...)
+		}
+	}
+
+	ordered := orderConnectStrs(connectStr)
+	defer func() {
+		if err == nil {
+			connectOrder.Success(connectStr, winner)
+		}
+	}()
+
+	if len(ordered) == 0 {
+		return "", fmt.Errorf("Nothing to connect to")
+	}
+	if parallel {
+		return connectParallel(ordered...)
+	}
+	for i := 0; i < len(ordered); i++ {
+		str := ordered[i]
+		if i > 0 {
+			connectCooldown.wait(context.Background(), time.Duration(config.ConnectAttemptDelay)*time.Second)
+		}
+
+		res := ConnectWithResult(context.Background(), str)
+		if res.Success {
+			if len(ordered) > 1 {
+				log.Printf("Connect succeeded using %s (%d of %d)", str, i+1, len(ordered))
+			}
+			return str, nil
+		}
+		err = res.Err
+
+		// The exchange failed after messages were transferred. No need to try the others if all
+		// outbound messages went through.
+		if _, ok := res.Err.(exchangeError); ok && res.Summary.MessagesSent+res.Summary.MessagesReceived > 0 {
+			if msgs, mboxErr := mbox.Outbox(); mboxErr == nil && len(msgs) == 0 {
+				log.Printf("Exchange with %s failed after transferring messages, but all outbound messages were sent. Not trying the remaining.", str)
+				return "", err
+			}
+		}
+		if isTNCUnavailable(res.Err) && config.AutoTelnetFallback && !containsStr(ordered, MethodTelnet) {
+			log.Printf("TNC unavailable, falling back to %s...", MethodTelnet)
+			eventLog.Log("telnet_fallback", map[string]interface{}{
+				"operation": "connect " + str,
+				"error":     res.Err.Error(),
+			})
+			ordered = append(ordered, MethodTelnet)
+		}
+	}
+	return "", err
+}
+
+func containsStr(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
 			return true
 		}
 	}
 	return false
 }
 
+// connectParallel dials the connect strings concurrently, and runs the exchange over the first connection to
+// be established. The losing attempts are aborted and closed.
+//
+// Connect strings sharing a TNC or rig (see parallelGroups) are dialed in turn, as they would otherwise QSY
+// the same rig.
+func connectParallel(connectStr ...string) (string, error) {
+	type result struct {
+		connectStr string
+		conn       *dialedConn
+		err        error
+	}
+
+	// Used to abort the losing attempts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	groups := parallelGroups(connectStr)
+	results := make(chan result, len(groups))
+	for _, group := range groups {
+		go func(group []string) {
+			res := result{group[0], nil, context.Canceled}
+			for _, str := range group {
+				if ctx.Err() != nil {
+					break
+				}
+				conn, err := dial(ctx, str)
+				if res = (result{str, conn, err}); err == nil {
+					break
+				}
+				log.Printf("%s: %s", str, err)
+			}
+			results <- res
+		}(group)
+	}
+
+	var winner *result
+	var lastErr error
+	for i := range groups {
+		res := <-results
+		if res.err != nil {
+			lastErr = res.err
+			continue
+		}
+
+		winner = &res
+		cancel()
+		go func(remaining int) {
+			// Tear down the losing attempts
+			for ; remaining > 0; remaining-- {
+				res := <-results
+				if res.err != nil {
+					continue
+				}
+				log.Printf("Closing redundant connection to %s (%s)", res.conn.url.Target, res.conn.url.Scheme)
+				res.conn.Close()
+				res.conn.revertFreq()
+			}
+		}(len(groups) - i - 1)
+		break
+	}
+
+	if winner == nil {
+		return "", lastErr
+	}
+
+	res := connectUsing(context.Background(), winner.connectStr, func() (*dialedConn, error) { return winner.conn, nil })
+	logConnectResult(res)
+	return winner.connectStr, res.Err
+}
+
+// parallelGroups groups the connect strings by the TNC and rig they use (see sessionKeys), keeping their
+// order. Connect strings that can not be resolved are put in groups of their own.
+func parallelGroups(connectStrs []string) [][]string {
+	// Union the connect strings sharing a key
+	parent := make([]int, len(connectStrs))
+	find := func(i int) int {
+		for parent[i] != i {
+			i = parent[i]
+		}
+		return i
+	}
+	first := make(map[string]int) // The index of the first connect string using each key.
+	for i, str := range connectStrs {
+		parent[i] = i
+		url, err := ResolveConnectURL(str)
+		if err != nil {
+			continue
+		}
+		for _, key := range sessionKeys(url) {
+			if j, ok := first[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				first[key] = i
+			}
+		}
+	}
+
+	var groups [][]string
+	groupIdx := make(map[int]int) // By root.
+	for i, str := range connectStrs {
+		root := find(i)
+		idx, ok := groupIdx[root]
+		if !ok {
+			idx = len(groups)
+			groupIdx[root] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], str)
+	}
+	return groups
+}
+
 func Connect(connectStr string) (success bool) {
-	if connectStr == "" {
+	return ConnectContext(context.Background(), connectStr)
+}
+
+// ConnectContext connects to the remote station and runs the exchange.
+//
+// If ctx is done while dialing, the dial is aborted. If ctx is done during the exchange, the
+// connection is closed and the TNC is returned to an idle state.
+func ConnectContext(ctx context.Context, connectStr string) (success bool) {
+	return ConnectWithResult(ctx, connectStr).Success
+}
+
+// ConnectResult describes the outcome of a connect.
+type ConnectResult struct {
+	Success   bool
+	URL       *transport.URL // The resolved connect URL (see ResolveConnectURL), if resolved.
+	Target    string
+	Scheme    string
+	Frequency Frequency      // The rig's frequency, if known.
+	Duration  time.Duration  // Time from the connection was established until disconnect.
+	BytesIn   int64          // Bytes received (message bytes, unless the connection implements ByteCounter).
+	BytesOut  int64          // Bytes sent (message bytes, unless the connection implements ByteCounter).
+	Summary   SessionSummary // Summary of the exchange, if connected.
+	Faults    []string       // Faults reported by the TNC during the connect (see tncEvents).
+	Err       error          // The error causing the connect to fail.
+}
+
+// ByteCounter is implemented by connections that keeps track of the number of bytes transferred.
+type ByteCounter interface {
+	BytesReceived() int64
+	BytesSent() int64
+}
+
+// ConnectWithResult is like ConnectContext, but returns a ConnectResult describing the outcome.
+func ConnectWithResult(ctx context.Context, connectStr string) ConnectResult {
+	res := connect(ctx, connectStr)
+	logConnectResult(res)
+	return res
+}
+
+// logConnectResult logs the outcome of a connect, and the session summary if connected.
+func logConnectResult(res ConnectResult) {
+	if res.Err != nil {
+		log.Println(res.Err)
+	} else {
+		log.Println("Disconnected.")
+	}
+	if res.Duration > 0 {
+		log.Printf("Session summary: %s", res.Summary)
+	}
+}
+
+// connect is like ConnectWithResult, but does not log the result (other than to the event log).
+//
+// A failed ardop connect is retried at each of the ARDOP instance's fallback bandwidths in turn
+// (see ArdopConfig.BandwidthFallback), until it succeeds.
+func connect(ctx context.Context, connectStr string) ConnectResult {
+	res := connectOnce(ctx, connectStr)
+	if res.Success || res.URL == nil || res.URL.Scheme != MethodArdop {
+		return res
+	}
+
+	for _, bw := range ardopConfigOrDefault(res.URL).BandwidthFallback {
+		if ctx.Err() != nil || isPermanentConnectErr(res.Err) || isBusyTimeout(res.Err) {
+			break
+		}
+		str, err := mergeParams(connectStr, url.Values{"bw": {bw.String()}}.Encode())
+		if err != nil {
+			break
+		}
+
+		log.Println(res.Err)
+		log.Printf("Retrying with ARQ bandwidth %s...", bw)
+		eventLog.Log("bandwidth_fallback", map[string]interface{}{
+			"operation": "connect " + connectStr,
+			"bandwidth": bw.String(),
+			"error":     res.Err.Error(),
+		})
+		if res = connectOnce(ctx, str); res.Success {
+			break
+		}
+	}
+	return res
+}
+
+// connectOnce dials and runs the exchange over the connect string, without retries.
+func connectOnce(ctx context.Context, connectStr string) ConnectResult {
+	return connectUsing(ctx, connectStr, func() (*dialedConn, error) { return dial(ctx, connectStr) })
+}
+
+// connectUsing is like connectOnce, but establishes the connection with the given dial func (e.g. returning
+// a connection already established by connectParallel).
+func connectUsing(ctx context.Context, connectStr string, dial func() (*dialedConn, error)) (res ConnectResult) {
+	begin := time.Now()
+	defer func() {
+		if res.Scheme == "" {
+			// Dial failed, resolve the connect string for the record
+			if url, err := ResolveConnectURL(connectStr); err == nil {
+				res.URL, res.Target, res.Scheme = url, url.Target, url.Scheme
+			}
+		}
+		if res.URL != nil {
+			res.Faults = tncEvents.faultsSince(exclusiveTNCKey(res.URL), begin)
+		}
+		eventLog.LogConnect(connectStr, res)
+		runPostConnectHook(connectStr, res)
+		connectCooldown.done()
+	}()
+
+	conn, err := dial()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer conn.revertFreq()
+
+	res.URL, res.Target, res.Scheme, res.Frequency = conn.url, conn.url.Target, conn.url.Scheme, conn.freq
+
+	// Make the exchange abortable (see abortConnect)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer pendingDials.add(cancel)()
+
+	// Close the connection if ctx is done before the exchange completes
+	exchangeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Println("Connect canceled, disconnecting...")
+			conn.Close()
+		case <-exchangeDone:
+		}
+	}()
+
+	start := time.Now()
+	stats, err := exchangeWithStats(conn.Conn, conn.url.Target, false, conn.opts)
+	close(exchangeDone)
+	res.Duration = time.Since(start)
+
+	res.Summary = stats
+	res.BytesIn, res.BytesOut = stats.BytesReceived, stats.BytesSent
+	if c, ok := conn.Conn.(ByteCounter); ok {
+		res.BytesIn, res.BytesOut = c.BytesReceived(), c.BytesSent()
+	}
+
+	if ctx.Err() != nil {
+		abortTNC(conn.url)
+	}
+
+	if err != nil {
+		res.Err = exchangeError{err}
+	} else {
+		res.Success = true
+	}
+	return res
+}
+
+// The upper limit of the delay between attempts in connectWithRetry.
+const maxRetryBackoff = 15 * time.Minute
+
+// connectWithRetry calls connect up to attempts times until it succeeds.
+//
+// The delay between attempts starts at backoff and is doubled for each failed attempt (capped at maxRetryBackoff).
+// Errors that are not likely to go away by retrying (e.g. unknown transport or alias) are not retried.
+// The error of the last attempt is returned.
+func connectWithRetry(ctx context.Context, connectStr string, attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		res := connect(ctx, connectStr)
+		err := res.Err
+
+		event := map[string]interface{}{
+			"operation": "connect " + connectStr,
+			"attempt":   attempt,
+			"attempts":  attempts,
+			"success":   err == nil,
+		}
+		if err != nil {
+			event["error"] = err.Error()
+		}
+		eventLog.Log("connect_attempt", event)
+
+		logConnectResult(res)
+		if err == nil {
+			return nil
+		}
+
+		switch {
+		case attempt >= attempts:
+			return err
+		case isPermanentConnectErr(err):
+			log.Println("Not retrying.")
+			return err
+		}
+
+		log.Printf("Attempt %d of %d failed, retrying in %s...", attempt, attempts, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// connectError is returned by dial when the connection could not be established.
+type connectError struct{ err error }
+
+func (e connectError) Error() string {
+	return "Unable to establish connection to remote: " + e.err.Error()
+}
+
+// exchangeError is returned by connect when the exchange failed.
+type exchangeError struct{ err error }
+
+func (e exchangeError) Error() string { return "Exchange failed: " + e.err.Error() }
+
+// Exit codes of the connect command.
+const (
+	exitOK            = 0 // The exchange completed.
+	exitError         = 1 // Other errors (e.g. config, alias resolution, TNC initialization or QSY).
+	exitDialFailed    = 2 // The connection could not be established.
+	exitBusyTimeout   = 3 // Gave up waiting for a clear channel.
+	exitExchangeError = 4 // The connection was established, but the exchange failed.
+)
+
+// connectExitCode returns the exit code of the connect command for the given connect error.
+func connectExitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return exitOK
+	case connectError:
+		return exitDialFailed
+	case busyTimeoutError:
+		return exitBusyTimeout
+	case exchangeError:
+		return exitExchangeError
+	default:
+		return exitError
+	}
+}
+
+// isPermanentConnectErr returns true if the error returned by connect is not likely to go away if the connect is retried.
+func isPermanentConnectErr(err error) bool {
+	switch err := err.(type) {
+	case connectError:
+		return err.err == context.Canceled
+	case busyTimeoutError:
 		return false
-	} else if aliased, ok := config.ConnectAliases[connectStr]; ok {
-		return Connect(aliased)
+	case exchangeError:
+		return fbb.IsLoginFailure(err.err)
+	default: // Errors from parsing, alias resolution, TNC initialization, QSY etc.
+		return true
+	}
+}
+
+// The maximum number of alias expansions allowed when resolving a connect string.
+const maxAliasDepth = 16
+
+// resolveAlias expands the given connect string using aliases until it no longer refers to an alias.
+//
+// The connect string may carry URL parameters (e.g. "alias?freq=7064"). These are merged onto the expanded
+// alias, and takes precedence over any parameters with the same name defined by the alias.
+//
+// An error is returned if the aliases form a cycle, or if the expansion is deeper than maxAliasDepth.
+func resolveAlias(aliases map[string]string, connectStr string) (string, error) {
+	name, params := splitParams(connectStr)
+	chain := []string{name}
+	for {
+		// An alias matching the full connect string (parameters included) is used as is
+		aliased, ok := aliases[connectStr]
+		merge := ""
+		if !ok {
+			aliased, ok = aliases[name]
+			merge = params
+		}
+		if !ok {
+			return connectStr, nil
+		}
+
+		var err error
+		connectStr, err = mergeParams(aliased, merge)
+		if err != nil {
+			return "", fmt.Errorf("Unable to merge parameters onto alias '%s': %s", name, err)
+		}
+
+		name, params = splitParams(connectStr)
+		for _, seen := range chain {
+			if seen == name {
+				return "", fmt.Errorf("alias cycle detected: %s", strings.Join(append(chain, name), " -> "))
+			}
+		}
+		chain = append(chain, name)
+		if len(chain) > maxAliasDepth {
+			return "", fmt.Errorf("alias expansion too deep (more than %d levels): %s", maxAliasDepth, strings.Join(chain, " -> "))
+		}
+	}
+}
+
+// splitParams splits the connect string into the part before and after '?'.
+func splitParams(connectStr string) (str, params string) {
+	if i := strings.Index(connectStr, "?"); i >= 0 {
+		return connectStr[:i], connectStr[i+1:]
+	}
+	return connectStr, ""
+}
+
+// mergeParams sets the given URL encoded parameters on the connect string, replacing any existing values.
+func mergeParams(connectStr string, params string) (string, error) {
+	if params == "" {
+		return connectStr, nil
+	}
+
+	override, err := url.ParseQuery(params)
+	if err != nil {
+		return "", err
+	}
+
+	str, existing := splitParams(connectStr)
+	merged, err := url.ParseQuery(existing)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+
+	return str + "?" + merged.Encode(), nil
+}
+
+// dialedConn is a connection established by dial.
+type dialedConn struct {
+	net.Conn
+	url        *transport.URL
+	freq       Frequency      // The rig's frequency, if known.
+	opts       sessionOptions // Per-connect exchange options (robust and P2P mode, forced disconnect).
+	revertFreq func()         // Reverts any QSY and bandwidth change done by dial, releases the TNC (see tncIdle) and runs the post connect hook.
+}
+
+// dial resolves the given connect string (or alias), prepares the TNC and rig, and establishes
+// a connection with the remote station.
+//
+// On success, the caller is responsible for calling revertFreq when the connection is no longer in use.
+func dial(ctx context.Context, connectStr string) (*dialedConn, error) {
+	if str, err := resolveAlias(config.ConnectAliases, connectStr); err == nil && isRMSConnectStr(str) {
+		return dialRMS(ctx, str)
+	}
+
+	url, err := ResolveConnectURL(connectStr)
+	if err != nil {
+		return nil, err
+	}
+	if showConnectURL {
+		log.Printf("Connect URL: %s", url)
+	}
+
+	if err := checkRigParam(url); err != nil {
+		return nil, err
+	}
+
+	// One session at a time per TNC and rig, waiting for inbound sessions to end (see InboundWaitTimeout)
+	inboundWait := time.Duration(config.InboundWaitTimeout) * time.Second
+	releaseGuard, err := tncGuard.acquire(ctx, url, sessionOutbound, inboundWait)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the TNC from being closed due to inactivity while in use, and pause listeners sharing the
+	// TNC for the duration of the connection
+	releaseTNCIdle := tncIdle.acquire(url)
+	resumeListeners := listenHub.PauseTNC(url)
+	releaseIdle := func() { resumeListeners(); releaseTNCIdle(); releaseGuard() }
+
+	tnc, err := tncs.Ensure(url)
+	if err != nil {
+		releaseIdle()
+		return nil, err
+	}
+
+	// Per-connection PTT rig (?rig=)
+	revertPTT, err := setSessionPTT(url, tnc)
+	if err != nil {
+		releaseIdle()
+		return nil, err
+	}
+	releaseTNC := func() { revertPTT(); releaseIdle() }
+
+	// Per-connection ARQ bandwidth and modes (ardop only)
+	robust, err := robustMode(url)
+	if err != nil {
+		releaseTNC()
+		return nil, err
+	}
+	p2p, err := p2pMode(url)
+	if err != nil {
+		releaseTNC()
+		return nil, err
+	}
+	revertBW, err := setARQBandwidth(url)
+	if err != nil {
+		releaseTNC()
+		return nil, err
+	}
+	revertCWID, err := setCWID(url)
+	if err != nil {
+		revertBW()
+		releaseTNC()
+		return nil, err
+	}
+	release := func() { revertCWID(); revertBW(); releaseTNC() }
+
+	// Pre connect hook (the post connect hook is run by connectOnce, see runPostConnectHook)
+	hooks := connectHooks(connectStr)
+	if err := runHook(ctx, "pre_connect", hooks.PreConnectCmd, connectStr, url, nil); err != nil {
+		release()
+		return nil, err
+	}
+	revertFreq := release
+
+	// QSY
+	var transmitted bool // Set to true once we've tried to dial (and possibly transmitted)
+	if freq := url.Params.Get("freq"); freq != "" {
+		revert, err := qsy(url, freq, url.Params.Get("settle"))
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("Unable to QSY: %s", err)
+		}
+		revertFreq = func() { revert(transmitted); release() }
+	}
+	var currFreq Frequency
+	if vfo, ok := vfoForURL(url); ok {
+		f, _ := vfo.GetFreq()
+		currFreq = Frequency(f)
+	}
+
+	retries, backoff, err := connectRetries(url)
+	if err != nil {
+		revertFreq()
+		return nil, err
+	}
+	busyTimeout, err := busyTimeout(url)
+	if err != nil {
+		revertFreq()
+		return nil, err
+	}
+	clearWindow, err := busyClearWindow(url)
+	if err != nil {
+		revertFreq()
+		return nil, err
+	}
+	timeout, err := connectTimeout(url)
+	if err != nil {
+		revertFreq()
+		return nil, err
+	}
+
+	// Ignore busy channel?
+	ignoreBusy := fOptions.IgnoreBusy
+	if v := url.Params.Get("ignore_busy"); v != "" {
+		ignoreBusy, _ = strconv.ParseBool(v)
+		if ignoreBusy {
+			log.Printf("Busy channel check skipped for %s (ignore_busy)", url.Target)
+		}
+	}
+
+	// Make the connect abortable (see abortConnect)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer pendingDials.add(cancel)()
+
+	var conn net.Conn
+	for attempt := 0; ; attempt++ {
+		err = nil
+
+		// Wait for a clear channel
+		if b, ok := tnc.(transport.BusyChannelChecker); ok {
+			stop := cancelOnInterrupt(cancel)
+			err = waitBusy(ctx, url, b, ignoreBusy, busyTimeout, clearWindow)
+			stop()
+		}
+		if err != nil {
+			eventLog.LogConn("connect "+connectStr, currFreq, nil, err)
+			if attempt >= retries || !isTransientDialErr(err) {
+				break
+			}
+			log.Printf("Retrying in %s (attempt %d of %d)...", backoff, attempt+2, retries+1)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		// Pre/post transmit hooks (e.g. amplifier and tuner sequencing), run around the first key-up
+		if !transmitted {
+			if err := runHook(ctx, "pre_transmit", hooks.PreTransmitCmd, connectStr, url, nil); err != nil {
+				revertFreq()
+				return nil, err
+			}
+			if hooks.PostTransmitCmd != "" {
+				revertNoHook := revertFreq
+				revertFreq = func() {
+					if err := runHook(context.Background(), "post_transmit", hooks.PostTransmitCmd, connectStr, url, nil); err != nil {
+						log.Println(err)
+					}
+					revertNoHook()
+				}
+			}
+		}
+
+		// Catch interrupts (signals) while dialing, so users can abort ardop/winmor connects.
+		doneHandleInterrupt := handleInterrupt()
+
+		log.Printf("Connecting to %s (%s)...", url.Target, url.Scheme)
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		transmitted = true
+		conn, err = dialURLContext(dialCtx, url)
+		cancel()
+
+		close(doneHandleInterrupt)
+
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			log.Printf("Connect to %s (%s) timed out after %s", url.Target, url.Scheme, timeout)
+			err = dialTimeoutError{timeout}
+		}
+
+		eventLog.LogConn("connect "+connectStr, currFreq, conn, err)
+
+		if err == nil || attempt >= retries || !isTransientDialErr(err) {
+			break
+		}
+
+		log.Printf("Unable to establish connection to remote: %s", err)
+		log.Printf("Retrying in %s (attempt %d of %d)...", backoff, attempt+2, retries+1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+	}
+
+	switch {
+	case err == nil:
+		opts := sessionOptions{Robust: robust, P2P: p2p, Abort: func() { abortTNC(url) }}
+		return &dialedConn{conn, url, currFreq, opts, revertFreq}, nil
+	case isBusyTimeout(err):
+		revertFreq()
+		return nil, err
+	default:
+		revertFreq()
+		return nil, connectError{err}
+	}
+}
+
+// ResolveConnectURL expands aliases in connectStr and parses it as a transport.URL with config defaults applied.
+//
+// This does everything Connect does to the connect string short of dialing: alias expansion, named
+// channels, mycall and host defaults, and transport specific parameters.
+func ResolveConnectURL(connectStr string) (*transport.URL, error) {
+	if connectStr == "" {
+		return nil, fmt.Errorf("Missing connect string")
+	}
+
+	connectStr, err := resolveAlias(config.ConnectAliases, connectStr)
+	if err != nil {
+		return nil, err
+	}
+	if connectStr, err = withChannelScheme(connectStr); err != nil {
+		return nil, err
 	}
 
 	url, err := transport.ParseURL(connectStr)
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+
+	// "vara" is short for VARA HF
+	if url.Scheme == "vara" {
+		url.Scheme = MethodVaraHF
+	}
+
+	// "kisstcp" is the same as "kiss-tcp"
+	if url.Scheme == "kisstcp" {
+		url.Scheme = MethodKISSTCP
+	}
+
+	// Digipeater path (?via=)
+	if err := applyVia(url); err != nil {
+		return nil, err
+	}
+
+	// telnet://...?tls=true is the same as telnets://
+	if url.Scheme == MethodTelnet {
+		if useTLS, _ := strconv.ParseBool(url.Params.Get("tls")); useTLS {
+			url.Scheme = MethodTelnets
+		}
+	}
+
+	// Named channel (freq=@name)
+	if err := applyChannel(url); err != nil {
+		return nil, err
+	}
+
+	// Set default userinfo (mycall)
+	if url.User == nil {
+		url.SetUser(fOptions.MyCall)
+		if url.Scheme == MethodAGWPE && config.AGWPE.Callsign != "" {
+			url.SetUser(config.AGWPE.Callsign)
+		}
+	}
+
+	// Set default host interface address
+	if url.Host == "" {
+		switch url.Scheme {
+		case "ax25":
+			url.Host = config.AX25.Port
+		case "serial-tnc":
+			url.Host = config.SerialTNC.Path
+			if config.SerialTNC.Baudrate > 0 {
+				url.Params.Set("hbaud", fmt.Sprint(config.SerialTNC.Baudrate))
+			}
+		case MethodAGWPE:
+			url.Host = config.AGWPE.Addr
+		case MethodKISSTCP:
+			url.Host = config.KISSTCP.Host
+		case MethodPactor:
+			url.Host = config.Pactor.Path
+			if config.Pactor.Baudrate > 0 && url.Params.Get("hbaud") == "" {
+				url.Params.Set("hbaud", fmt.Sprint(config.Pactor.Baudrate))
+			}
+		}
+	}
+
+	if url.Scheme == MethodAGWPE && url.Params.Get("radio_port") == "" {
+		url.Params.Set("radio_port", fmt.Sprint(config.AGWPE.RadioPort))
+	}
+	if url.Scheme == MethodKISSTCP && url.Params.Get("kiss_port") == "" {
+		url.Params.Set("kiss_port", fmt.Sprint(config.KISSTCP.Port))
+	}
+	if url.Scheme == MethodSerialTNC {
+		if err := setSerialTNCParams(url); err != nil {
+			return nil, err
+		}
+	}
+
+	// Radio Only?
+	radioOnly := fOptions.RadioOnly
+	if v := url.Params.Get("radio_only"); v != "" {
+		radioOnly, _ = strconv.ParseBool(v)
+	}
+	if radioOnly {
+		if hasSSID(fOptions.MyCall) {
+			return nil, fmt.Errorf("Radio Only does not support callsign with SSID")
+		}
+
+		switch url.Scheme {
+		case "ax25", "serial-tnc", MethodAGWPE, MethodKISSTCP:
+			return nil, fmt.Errorf("Radio-Only is not available for %s", url.Scheme)
+		default:
+			url.SetUser(url.User.Username() + "-T")
+		}
+	}
+
+	return url, nil
+}
+
+// applyVia sets the digipeater path of a packet connect from the comma-separated list of digipeaters given by the
+// URL parameter ?via= (e.g. via=LD5HU,WIDE2-1), in order.
+func applyVia(url *transport.URL) error {
+	str := url.Params.Get("via")
+	if str == "" {
+		return nil
+	}
+	if !containsStr(packetTransports, url.Scheme) {
+		return fmt.Errorf("The via parameter is not supported by %s", url.Scheme)
+	}
+	if len(url.Digis) > 0 {
+		return fmt.Errorf("Digipeaters given both in the path and in the via parameter")
+	}
+
+	var digis []string
+	for _, digi := range strings.Split(str, ",") {
+		digi = strings.ToUpper(strings.TrimSpace(digi))
+		if !isValidDigi(digi) {
+			return fmt.Errorf("Invalid digipeater '%s' in via parameter", digi)
+		}
+		digis = append(digis, digi)
+	}
+	url.Digis = digis
+	url.Params.Del("via")
+	return nil
+}
+
+// isValidDigi returns true if str is a plausible AX.25 address: a callsign (or alias, e.g. WIDE2) of up to
+// six letters and digits, optionally followed by an SSID (0-15).
+func isValidDigi(str string) bool {
+	parts := strings.SplitN(str, "-", 2)
+	if call := parts[0]; len(call) == 0 || len(call) > 6 {
 		return false
 	}
+	for _, r := range parts[0] {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	if len(parts) == 1 {
+		return true
+	}
+	ssid, err := strconv.Atoi(parts[1])
+	return err == nil && len(parts[1]) <= 2 && ssid >= 0 && ssid <= 15
+}
+
+// agwpeClient returns an AGWPE client using the configured credentials.
+func agwpeClient() agwpe.Client {
+	return agwpe.Client{Username: config.AGWPE.Username, Password: config.AGWPE.Password}
+}
+
+// dialTimeoutError is returned when the dial did not complete within the transport's connect timeout.
+type dialTimeoutError struct{ timeout time.Duration }
+
+func (e dialTimeoutError) Error() string   { return fmt.Sprintf("Connect timed out after %s", e.timeout) }
+func (e dialTimeoutError) Timeout() bool   { return true }
+func (e dialTimeoutError) Temporary() bool { return true }
+
+// dialURLContext dials the given URL, aborting the dial if ctx is done before the connection is established.
+func dialURLContext(ctx context.Context, url *transport.URL) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	if addrs := telnetAddrs(ctx, url); len(addrs) > 1 {
+		return dialTelnetFailover(ctx, url, addrs)
+	}
+
+	dialer := dialerForURL(url)
+	if d, ok := dialer.(contextDialer); ok {
+		return d.DialURLContext(ctx, url)
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dialer.DialURL(url)
+		done <- result{conn, err}
+	}()
 
-	// Init TNCs
+	select {
+	case res := <-done:
+		return res.conn, res.err
+	case <-ctx.Done():
+	}
+
+	abortTNC(url)
+	go func() {
+		// The dial might still succeed after we've given up on it
+		if res := <-done; res.conn != nil {
+			res.conn.Close()
+		}
+	}()
+
+	return nil, ctx.Err()
+}
+
+// contextDialer is implemented by dialers that abort the connect themselves when ctx is done (e.g. the VARA modems).
+type contextDialer interface {
+	DialURLContext(ctx context.Context, url *transport.URL) (net.Conn, error)
+}
+
+// dialerForURL returns the dialer for the given URL.
+//
+// ARDOP URLs are dialed directly by the selected ARDOP TNC, as only the default instance is registered with the transport package.
+// VARA URLs are dialed directly by the modem, so that the connect timeout is applied by the modem.
+func dialerForURL(url *transport.URL) transport.Dialer {
 	switch url.Scheme {
-	case "ardop":
-		if err := initArdopTNC(); err != nil {
-			log.Println(err)
-			return
+	case MethodArdop:
+		if tnc, ok := ardopTNCForURL(url); ok {
+			return tnc
 		}
-	case "winmor":
-		if err := initWinmorTNC(); err != nil {
-			log.Println(err)
-			return
+	case MethodVaraHF, MethodVaraFM:
+		tncMu.Lock()
+		m := varaHFTNC
+		if url.Scheme == MethodVaraFM {
+			m = varaFMTNC
 		}
-	case "pactor":
-		if err := initPactorModem(); err != nil {
-			log.Println(err)
-			return
+		tncMu.Unlock()
+		if m != nil {
+			return m
+		}
+	}
+	return transportDialer{}
+}
+
+// transportDialer dials using the dialers registered with the transport package.
+type transportDialer struct{}
+
+func (transportDialer) DialURL(url *transport.URL) (net.Conn, error) { return transport.DialURL(url) }
+
+// abortTNC aborts any pending connect or connection on the TNC/modem used by the given URL,
+// returning it to an idle state.
+func abortTNC(url *transport.URL) {
+	switch url.Scheme {
+	case MethodArdop:
+		if tnc, ok := ardopTNCForURL(url); ok && !tnc.Idle() {
+			tnc.Abort()
+		}
+	case MethodWinmor:
+		if wmTNC != nil && !wmTNC.Idle() {
+			wmTNC.DirtyDisconnect()
+		}
+	case MethodPactor:
+		if pModem != nil {
+			pModem.Close()
+		}
+	case MethodVaraHF:
+		if varaHFTNC != nil && !varaHFTNC.Idle() {
+			varaHFTNC.Abort()
+		}
+	case MethodVaraFM:
+		if varaFMTNC != nil && !varaFMTNC.Idle() {
+			varaFMTNC.Abort()
+		}
+	}
+}
+
+// connectTimeout returns the maximum duration of the dial phase for the given URL (zero means no timeout).
+//
+// The transport's config value is used unless overridden by the URL parameter ?dial_timeout=.
+func connectTimeout(url *transport.URL) (time.Duration, error) {
+	if v := url.Params.Get("dial_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		switch {
+		case err != nil:
+			return 0, fmt.Errorf("Invalid dial_timeout parameter: %s", err)
+		case d <= 0:
+			return 0, fmt.Errorf("Invalid dial_timeout parameter: %s is not positive", v)
+		}
+		return d, nil
+	}
+
+	var secs int
+	switch url.Scheme {
+	case MethodVaraHF:
+		secs = config.VaraHF.ConnectTimeout
+	case MethodVaraFM:
+		secs = config.VaraFM.ConnectTimeout
+	case MethodWinmor:
+		secs = config.Winmor.ConnectTimeout
+	case MethodArdop:
+		secs = ardopConfigOrDefault(url).ConnectTimeout
+	case MethodPactor:
+		secs = config.Pactor.ConnectTimeout
+	case MethodAX25:
+		secs = config.AX25.ConnectTimeout
+	case MethodSerialTNC:
+		secs = config.SerialTNC.ConnectTimeout
+	case MethodAGWPE:
+		secs = config.AGWPE.ConnectTimeout
+	case MethodKISSTCP:
+		secs = config.KISSTCP.ConnectTimeout
+	case MethodTelnet, MethodTelnets:
+		secs = config.Telnet.ConnectTimeout
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// connectRetries returns the number of times a failed dial should be retried for the given URL, and the delay between each attempt.
+//
+// The transport's config values are used unless overridden by the URL parameters ?retries= and ?retry_backoff=.
+func connectRetries(url *transport.URL) (retries int, backoff time.Duration, err error) {
+	var backoffSecs int
+	switch url.Scheme {
+	case MethodVaraHF:
+		retries, backoffSecs = config.VaraHF.ConnectRetries, config.VaraHF.RetryBackoff
+	case MethodVaraFM:
+		retries, backoffSecs = config.VaraFM.ConnectRetries, config.VaraFM.RetryBackoff
+	case MethodWinmor:
+		retries, backoffSecs = config.Winmor.ConnectRetries, config.Winmor.RetryBackoff
+	case MethodArdop:
+		conf := ardopConfigOrDefault(url)
+		retries, backoffSecs = conf.ConnectRetries, conf.RetryBackoff
+	case MethodPactor:
+		retries, backoffSecs = config.Pactor.ConnectRetries, config.Pactor.RetryBackoff
+	case MethodAX25:
+		retries, backoffSecs = config.AX25.ConnectRetries, config.AX25.RetryBackoff
+	case MethodSerialTNC:
+		retries, backoffSecs = config.SerialTNC.ConnectRetries, config.SerialTNC.RetryBackoff
+	case MethodAGWPE:
+		retries, backoffSecs = config.AGWPE.ConnectRetries, config.AGWPE.RetryBackoff
+	case MethodKISSTCP:
+		retries, backoffSecs = config.KISSTCP.ConnectRetries, config.KISSTCP.RetryBackoff
+	case MethodTelnet, MethodTelnets:
+		retries, backoffSecs = config.Telnet.ConnectRetries, config.Telnet.RetryBackoff
+	}
+
+	backoff = defaultRetryBackoff
+	if backoffSecs > 0 {
+		backoff = time.Duration(backoffSecs) * time.Second
+	}
+
+	if v := url.Params.Get("retries"); v != "" {
+		if retries, err = strconv.Atoi(v); err != nil {
+			return 0, 0, fmt.Errorf("Invalid retries parameter: %s", err)
 		}
 	}
+	if v := url.Params.Get("retry_backoff"); v != "" {
+		if backoff, err = time.ParseDuration(v); err != nil {
+			return 0, 0, fmt.Errorf("Invalid retry_backoff parameter: %s", err)
+		}
+	}
+
+	return retries, backoff, nil
+}
+
+// isTransientDialErr returns true if the dial error is likely to go away if the connect is retried (e.g. connect timeout or busy channel).
+func isTransientDialErr(err error) bool {
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return true
+	}
+	if isBusyTimeout(err) {
+		return true
+	}
+	switch err {
+	case ardop.ErrConnectTimeout, winmor.ErrConnectTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// The default QSY delays, used if not configured for the rig.
+const (
+	defaultQSYSettleDelay = 3 * time.Second
+	defaultQSXDelay       = time.Second
+)
+
+// qsy changes the frequency of the rig used by the given URL's transport.
+//
+// If settle is non-empty, it overrides the rig's configured settle delay (e.g. "5s"). The QSY hooks
+// (see QSYHooks) are called before QSY, after QSY and after QSX.
+// The returned revert func changes back to the previous frequency. The QSX delay is skipped if transmitted is false.
+func qsy(url *transport.URL, addr, settle string) (revert func(transmitted bool), err error) {
+	noop := func(bool) {}
+	method := url.Scheme
+
+	rigName, rig, err := qsyRig(url)
+	if err != nil {
+		return noop, err
+	}
 
-	// Set default userinfo (mycall)
-	if url.User == nil {
-		url.SetUser(fOptions.MyCall)
+	settleDelay, qsxDelay := qsyDelays(config.HamlibRigs[rigName])
+	if settle != "" {
+		if settleDelay, err = time.ParseDuration(settle); err != nil {
+			return noop, fmt.Errorf("Invalid settle parameter: %s", err)
+		}
 	}
 
-	// Set default host interface address
-	if url.Host == "" {
-		switch url.Scheme {
-		case "ax25":
-			url.Host = config.AX25.Port
-		case "serial-tnc":
-			url.Host = config.SerialTNC.Path
-			if config.SerialTNC.Baudrate > 0 {
-				url.Params.Set("hbaud", fmt.Sprint(config.SerialTNC.Baudrate))
-			}
+	// Center frequency (e.g. from the RMS list) to dial frequency
+	var offset int
+	if v := url.Params.Get("center"); v != "" {
+		isCenter, err := strconv.ParseBool(v)
+		if err != nil {
+			return noop, fmt.Errorf("Invalid center parameter: %s", err)
+		}
+		if isCenter {
+			offset = freqOffset(config.HamlibRigs[rigName], method)
 		}
 	}
 
-	// Radio Only?
-	radioOnly := fOptions.RadioOnly
-	if v := url.Params.Get("radio_only"); v != "" {
-		radioOnly, _ = strconv.ParseBool(v)
+	// Pre-QSY hooks (see QSYHooks)
+	f, err := ParseFrequency(addr)
+	if err != nil {
+		return noop, err
+	}
+	if err := qsyHooks.runPreQSY(method, f+Frequency(offset)); err != nil {
+		return noop, fmt.Errorf("Pre-QSY hook failed: %s", err)
 	}
-	if radioOnly {
-		if hasSSID(fOptions.MyCall) {
-			log.Println("Radio Only does not support callsign with SSID")
-			return
-		}
 
-		switch url.Scheme {
-		case "ax25", "serial-tnc":
-			log.Printf("Radio-Only is not available for %s", url.Scheme)
-			return
-		default:
-			url.SetUser(url.User.Username() + "-T")
-		}
+	mode := qsyMode(url)
+
+	newFreq, oldFreq, err := setFreq(rig, addr, offset)
+	if err != nil {
+		return noop, err
+	}
+	if offset != 0 {
+		log.Printf("QSY %s: %s (center), dial %.3f", method, addr, float64(newFreq)/1e3)
+	} else {
+		log.Printf("QSY %s: %s", method, addr)
 	}
+	restoreMode, modeSet := setMode(method, rigName, mode)
+	start := time.Now()
+	qsyHooks.runPostQSY(method, Frequency(newFreq))
+	settleDelay -= time.Since(start)
 
-	// QSY
-	var revertFreq func()
-	if freq := url.Params.Get("freq"); freq != "" {
-		revertFreq, err = qsy(url.Scheme, freq)
+	tolerance := config.HamlibRigs[rigName].QSYTolerance
+	if config.HamlibRigs[rigName].QSYPollFreq {
+		waitFreq(rig, newFreq, tolerance, settleDelay)
+	} else {
+		time.Sleep(settleDelay)
+	}
+
+	// Verify that the rig actually changed frequency (some rigs silently reject e.g. out-of-band frequencies)
+	if tolerance > 0 {
+		actual, err := rig.GetFreq()
 		if err != nil {
-			log.Printf("Unable to QSY: %s", err)
-			return
+			qsxFreq(method, rig, oldFreq)
+			restoreMode()
+			return noop, fmt.Errorf("Unable to verify rig frequency: %s", err)
+		}
+		if abs(actual-newFreq) > tolerance {
+			qsxFreq(method, rig, oldFreq)
+			restoreMode()
+			return noop, fmt.Errorf("Rig did not change frequency (requested %.3f, rig reports %.3f)", float64(newFreq)/1e3, float64(actual)/1e3)
 		}
-		defer revertFreq()
+		log.Printf("QSY %s: requested %.3f, rig reports %.3f", method, float64(newFreq)/1e3, float64(actual)/1e3)
 	}
-	var currFreq Frequency
-	if vfo, ok := VFOForTransport(url.Scheme); ok {
-		f, _ := vfo.GetFreq()
-		currFreq = Frequency(f)
+
+	event := map[string]interface{}{"transport": method, "freq": Frequency(newFreq)}
+	if modeSet {
+		event["mode"] = mode
 	}
+	eventLog.Log("qsy", event)
 
-	// Wait for a clear channel
+	return func(transmitted bool) {
+		if transmitted {
+			time.Sleep(qsxDelay)
+		}
+		log.Printf("QSX %s: %.3f", method, float64(oldFreq)/1e3)
+		qsxFreq(method, rig, oldFreq)
+		restoreMode()
+		qsyHooks.runPostQSX(method, Frequency(oldFreq))
+	}, nil
+}
+
+// qsyMode returns the rig mode to set on QSY for the given URL: the ?mode= parameter or the transport's configured mode.
+//
+// An empty string means that the mode is left unchanged.
+func qsyMode(url *transport.URL) string {
+	if mode := url.Params.Get("mode"); mode != "" {
+		return mode
+	}
 	switch url.Scheme {
-	case "ardop":
-		waitBusy(adTNC)
-	case "winmor":
-		waitBusy(wmTNC)
+	case MethodWinmor:
+		return config.Winmor.Mode
+	case MethodArdop:
+		return ardopConfigOrDefault(url).Mode
+	case MethodVaraHF:
+		return config.VaraHF.Mode
+	case MethodVaraFM:
+		return config.VaraFM.Mode
+	case MethodPactor:
+		return config.Pactor.Mode
+	default:
+		return ""
 	}
+}
 
-	// Catch interrupts (signals) while dialing, so users can abort ardop/winmor connects.
-	doneHandleInterrupt := handleInterrupt()
-
-	log.Printf("Connecting to %s (%s)...", url.Target, url.Scheme)
-	conn, err := transport.DialURL(url)
+// setMode sets the mode of the named rig, returning a func restoring the previous mode (and passband).
+//
+// Rigs that does not support or rejects the mode are left unchanged with a warning (frequency only QSY).
+// ok is true if the mode was set.
+func setMode(method, rigName, mode string) (restore func(), ok bool) {
+	noop := func() {}
+	if mode == "" {
+		return noop, false
+	}
 
-	close(doneHandleInterrupt)
+	rig, isModeRig := rigModes[rigName]
+	if !isModeRig {
+		log.Printf("Warning: Rig does not support setting mode, continuing without changing mode to %s.", mode)
+		return noop, false
+	}
+	oldMode, passband, err := rig.GetMode()
+	if err != nil {
+		log.Printf("Warning: Unable to get rig mode, continuing without changing mode to %s: %s", mode, err)
+		return noop, false
+	}
+	if strings.EqualFold(oldMode, mode) {
+		return noop, true
+	}
+	if err := rig.SetMode(mode, 0); err != nil {
+		log.Printf("Warning: Rig rejected mode %s, continuing without changing mode: %s", mode, err)
+		return noop, false
+	}
+	log.Printf("QSY %s: mode %s", method, mode)
 
-	eventLog.LogConn("connect "+connectStr, currFreq, conn, err)
+	return func() {
+		if err := rig.SetMode(oldMode, passband); err != nil {
+			log.Printf("QSX %s failed: Unable to set mode back to %s: %s", method, oldMode, err)
+			return
+		}
+		log.Printf("QSX %s: mode %s", method, oldMode)
+	}, true
+}
 
-	if err != nil {
-		log.Printf("Unable to establish connection to remote: %s", err)
+// qsxFreq sets the rig's frequency back to freq (Hz) after QSY.
+//
+// Failures are logged and recorded in the event log, as the rig is left on the wrong frequency.
+func qsxFreq(method string, rig hamlib.VFO, freq int) {
+	err := rig.SetFreq(freq)
+	if err == nil {
 		return
 	}
 
-	err = exchange(conn, url.Target, false)
-	if err != nil {
-		log.Printf("Exchange failed: %s", err)
-	} else {
-		log.Println("Disconnected.")
-		success = true
+	log.Printf("QSX %s failed: Unable to set rig back to %.3f: %s", method, float64(freq)/1e3, err)
+	eventLog.Log("qsx_failed", map[string]interface{}{
+		"transport": method,
+		"freq":      Frequency(freq),
+		"error":     err.Error(),
+	})
+}
+
+// The interval between frequency reads in waitFreq.
+const freqPollInterval = 100 * time.Millisecond
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
 	}
+	return i
+}
 
-	return
+// waitFreq waits until the rig reports the given frequency (Hz) within tolerance (zero means exactly), or timeout.
+func waitFreq(rig hamlib.VFO, freq, tolerance int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if f, err := rig.GetFreq(); err == nil && abs(f-freq) <= tolerance {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Rig did not report the new frequency within %s, proceeding anyway.", timeout)
+			return
+		}
+		time.Sleep(freqPollInterval)
+	}
 }
 
-func qsy(method, addr string) (revert func(), err error) {
-	noop := func() {}
+// freqOffset returns the offset (Hz) from center frequency to dial frequency for the given rig and transport.
+func freqOffset(rigConf cfg.HamlibConfig, method string) int {
+	if rigConf.FreqOffsetHz != 0 {
+		return rigConf.FreqOffsetHz
+	}
+	return int(Frequency(0).Dial(method))
+}
 
-	var rigName string
+// qsyRig returns the name of the rig referenced by the config section of the given URL's transport (or the URL
+// parameter ?rig=), and the loaded rig.
+func qsyRig(url *transport.URL) (rigName string, rig hamlib.VFO, err error) {
+	method := url.Scheme
 	switch method {
 	case MethodWinmor:
 		rigName = config.Winmor.Rig
 	case MethodArdop:
-		rigName = config.Ardop.Rig
+		rigName = ardopConfigOrDefault(url).Rig
+	case MethodVaraHF:
+		rigName = config.VaraHF.Rig
+	case MethodVaraFM:
+		rigName = config.VaraFM.Rig
 	case MethodAX25:
 		rigName = config.AX25.Rig
+	case MethodAGWPE:
+		rigName = config.AGWPE.Rig
+	case MethodKISSTCP:
+		rigName = config.KISSTCP.Rig
 	case MethodPactor:
 		rigName = config.Pactor.Rig
 	default:
-		return noop, fmt.Errorf("Not supported with transport '%s'", method)
+		return "", nil, fmt.Errorf("Not supported with transport '%s'", method)
+	}
+	if name := url.Params.Get("rig"); name != "" {
+		rigName = name
 	}
 
 	if rigName == "" {
-		return noop, fmt.Errorf("Missing rig reference in config section for %s, don't know which rig to qsy", method)
+		return "", nil, fmt.Errorf("Missing rig reference in config section for %s, don't know which rig to qsy", method)
 	}
 
-	var ok bool
 	rig, ok := rigs[rigName]
 	if !ok {
-		return noop, fmt.Errorf("Hamlib rig '%s' not loaded.", rigName)
+		return rigName, nil, fmt.Errorf("Hamlib rig '%s' not loaded.", rigName)
 	}
+	return rigName, rig, nil
+}
 
-	log.Printf("QSY %s: %s", method, addr)
+// vfoForURL returns the loaded rig used by the given URL's transport, if any.
+func vfoForURL(url *transport.URL) (hamlib.VFO, bool) {
+	_, rig, err := qsyRig(url)
+	return rig, err == nil
+}
 
-	_, oldFreq, err := setFreq(rig, addr)
-	if err != nil {
-		return noop, err
+// qsyDelays returns the QSY settle delay and the delay before QSX for the given rig.
+func qsyDelays(rig cfg.HamlibConfig) (settle, qsx time.Duration) {
+	settle, qsx = defaultQSYSettleDelay, defaultQSXDelay
+	if rig.QSYSettleDelay > 0 {
+		settle = time.Duration(rig.QSYSettleDelay) * time.Millisecond
+	}
+	if rig.QSXDelay > 0 {
+		qsx = time.Duration(rig.QSXDelay) * time.Millisecond
+	}
+	return settle, qsx
+}
+
+// busyTimeout returns the maximum duration to wait for a clear channel before giving up on the given URL (zero means forever).
+//
+// The transport's config value is used unless overridden by the URL parameter ?busy_timeout=.
+func busyTimeout(url *transport.URL) (time.Duration, error) {
+	if v := url.Params.Get("busy_timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid busy_timeout parameter: %s", err)
+		}
+		return d, nil
 	}
 
-	time.Sleep(3 * time.Second)
+	switch url.Scheme {
+	case MethodArdop:
+		return time.Duration(ardopConfigOrDefault(url).BusyTimeout) * time.Second, nil
+	case MethodWinmor:
+		return time.Duration(config.Winmor.BusyTimeout) * time.Second, nil
+	case MethodVaraHF:
+		return time.Duration(config.VaraHF.BusyTimeout) * time.Second, nil
+	case MethodVaraFM:
+		return time.Duration(config.VaraFM.BusyTimeout) * time.Second, nil
+	case MethodAX25:
+		return time.Duration(config.AX25.BusyTimeout) * time.Second, nil
+	default:
+		return 0, nil
+	}
+}
 
-	return func() {
-		time.Sleep(time.Second)
-		log.Printf("QSX %s: %.3f", method, float64(oldFreq)/1e3)
-		rig.SetFreq(oldFreq)
-	}, nil
+// busyClearWindow returns the duration the channel must stay clear before connecting to the given URL.
+//
+// The transport's config value is used unless overridden by the URL parameter ?busy_clear_window=.
+func busyClearWindow(url *transport.URL) (time.Duration, error) {
+	if v := url.Params.Get("busy_clear_window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid busy_clear_window parameter: %s", err)
+		}
+		return d, nil
+	}
+
+	switch url.Scheme {
+	case MethodArdop:
+		return time.Duration(ardopConfigOrDefault(url).BusyClearWindow) * time.Second, nil
+	case MethodWinmor:
+		return time.Duration(config.Winmor.BusyClearWindow) * time.Second, nil
+	case MethodVaraHF:
+		return time.Duration(config.VaraHF.BusyClearWindow) * time.Second, nil
+	case MethodVaraFM:
+		return time.Duration(config.VaraFM.BusyClearWindow) * time.Second, nil
+	case MethodAX25:
+		return time.Duration(config.AX25.BusyClearWindow) * time.Second, nil
+	default:
+		return 0, nil
+	}
+}
+
+// busyTimeoutError is returned by waitBusy when the channel did not clear within the timeout.
+type busyTimeoutError struct{ timeout time.Duration }
+
+func (e busyTimeoutError) Error() string {
+	return fmt.Sprintf("Channel never cleared, giving up after %s", e.timeout)
 }
 
-func waitBusy(b transport.BusyChannelChecker) {
-	printed := false
+func isBusyTimeout(err error) bool { _, ok := err.(busyTimeoutError); return ok }
+
+// waitBusy blocks until the channel is clear, or returns immediately if ignoreBusy is true.
+//
+// A nil error means the channel is clear (or ignored). A busyTimeoutError is returned if the channel never
+// cleared within timeout (zero means wait forever).
+// If clearWindow is positive, the channel must stay clear for that long (restarting whenever it goes busy).
+// If ctx is done before the channel clears, the context's error is returned.
+func waitBusy(ctx context.Context, url *transport.URL, b transport.BusyChannelChecker, ignoreBusy bool, timeout, clearWindow time.Duration) error {
+	clock := busyPoll.Clock
+	start := clock.Now()
+	interval := busyPoll.Initial
+
+	clearPolls, clearInterval := busyClearConfirm(url)
+	if ignoreBusy {
+		clearPolls, clearWindow = 1, 0
+	}
+
+	var busy, printed bool
+	var clearReads int
+	since := start // Time of the last busy state change
+	for {
+		if b.Busy() != busy {
+			busy = !busy
+			var prev time.Duration
+			if printed {
+				prev = clock.Now().Sub(since)
+			}
+			logBusyTransition(url, busy, prev)
+			since = clock.Now()
+			interval = busyPoll.Initial
+		}
+		if !busy {
+			// Require N consecutive clear readings to avoid racing on a stale reading,
+			// and the channel to have been clear for the full clear window
+			if clearReads++; clearReads >= clearPolls && clock.Now().Sub(since) >= clearWindow {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-clock.After(clearInterval):
+			}
+			continue
+		}
+		clearReads = 0
 
-	for b.Busy() {
-		if !printed && fOptions.IgnoreBusy {
+		if !printed && ignoreBusy {
 			log.Println("Ignoring busy channel!")
 			break
 		} else if !printed {
 			log.Println("Waiting for clear channel...")
 			printed = true
 		}
-		time.Sleep(300 * time.Millisecond)
+
+		if timeout > 0 && clock.Now().Sub(start) >= timeout {
+			err := busyTimeoutError{timeout}
+			log.Println(err)
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Busy channel wait aborted.")
+			return ctx.Err()
+		case <-clock.After(interval):
+		}
+
+		// Back off to reduce the traffic to the TNC while the channel stays busy
+		if interval *= 2; interval > busyPoll.Max {
+			interval = busyPoll.Max
+		}
+	}
+	if printed {
+		log.Printf("Channel cleared after %s.", clock.Now().Sub(start).Round(time.Second))
+	}
+	return nil
+}
+
+// busyClearConfirm returns the number of consecutive clear readings required before the channel is considered
+// clear for the given URL's transport, and the interval between them.
+func busyClearConfirm(url *transport.URL) (polls int, interval time.Duration) {
+	var ms int
+	switch url.Scheme {
+	case MethodArdop:
+		conf := ardopConfigOrDefault(url)
+		polls, ms = conf.BusyClearPolls, conf.BusyClearInterval
+	case MethodWinmor:
+		polls, ms = config.Winmor.BusyClearPolls, config.Winmor.BusyClearInterval
+	case MethodVaraHF:
+		polls, ms = config.VaraHF.BusyClearPolls, config.VaraHF.BusyClearInterval
+	case MethodVaraFM:
+		polls, ms = config.VaraFM.BusyClearPolls, config.VaraFM.BusyClearInterval
+	}
+
+	if polls < 1 {
+		polls = 1
+	}
+	interval = busyPoll.Initial
+	if ms > 0 {
+		interval = time.Duration(ms) * time.Millisecond
+	}
+	return polls, interval
+}
+
+// logBusyTransition records a busy channel state change in the event log, along with the current frequency.
+func logBusyTransition(url *transport.URL, busy bool, prev time.Duration) {
+	var freq Frequency
+	if vfo, ok := vfoForURL(url); ok {
+		f, _ := vfo.GetFreq()
+		freq = Frequency(f)
+	}
+	eventLog.LogBusy(url.Scheme, freq, busy, prev)
+}
+
+// busyPoll controls how often waitBusy polls the TNC while the channel is busy.
+//
+// The interval starts at Initial and is doubled for each poll, up to Max.
+var busyPoll = struct {
+	Initial, Max time.Duration
+	Clock        clock
+}{
+	Initial: 300 * time.Millisecond,
+	Max:     2 * time.Second,
+	Clock:   realClock{},
+}
+
+// clock abstracts the passing of time, so time dependent behavior can be made deterministic.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// dialRegistry keeps track of the connects in progress, so they can be aborted.
+type dialRegistry struct {
+	mu     sync.Mutex
+	nextID int
+	cancel map[int]context.CancelFunc
+}
+
+var pendingDials = &dialRegistry{cancel: make(map[int]context.CancelFunc)}
+
+// add registers the cancel func of a pending connect. The returned func must be called when the connect is no longer pending.
+func (r *dialRegistry) add(cancel context.CancelFunc) (remove func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextID
+	r.nextID++
+	r.cancel[id] = cancel
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.cancel, id)
+	}
+}
+
+// pending returns the number of pending connects.
+func (r *dialRegistry) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cancel)
+}
+
+// abortAll cancels all pending connects, returning the number of connects that was aborted.
+func (r *dialRegistry) abortAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cancel := range r.cancel {
+		cancel()
+	}
+	return len(r.cancel)
+}
+
+// abortConnect aborts all connects in progress (waiting for clear channel, dialing or exchanging).
+//
+// Connects in the exchange phase are disconnected gracefully.
+func abortConnect() bool { return pendingDials.abortAll() > 0 }
+
+// applyWinmorSettings pushes the optional drive level of config.Winmor to the WINMOR TNC. Failures are
+// logged as warnings, as older TNC versions don't support all settings.
+func applyWinmorSettings() {
+	lvl := config.Winmor.DriveLevel
+	if lvl == 0 {
+		return
+	}
+	if err := wmTNC.SetDriveLevel(lvl); err != nil {
+		log.Printf("Warning: Failed to set WINMOR drive level: %s", err)
+	} else {
+		log.Printf("WINMOR drive level set to %d", lvl)
 	}
 }
 
 func initWinmorTNC() error {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+
 	if wmTNC != nil && wmTNC.Ping() == nil {
 		return nil
 	}
 
+	// The TNC might have crashed or been restarted. Tear down the stale TNC, including any active
+	// listener, and re-open it. The listener is re-established by the listener hub.
 	if wmTNC != nil {
+		log.Println("WINMOR TNC connection lost, reconnecting...")
 		wmTNC.Close()
+		wmTNC = nil
 	}
 
-	var err error
-	wmTNC, err = winmor.Open(config.Winmor.Addr, fOptions.MyCall, config.Locator)
+	// Fail before grabbing the sound device if the PTT rig is missing
+	ptt, err := pttRig(MethodWinmor, config.Winmor.PTTControl, config.Winmor.Rig)
 	if err != nil {
-		return fmt.Errorf("WINMOR TNC initialization failed: %s", err)
+		return err
 	}
 
-	if config.Winmor.DriveLevel != 0 {
-		if err := wmTNC.SetDriveLevel(config.Winmor.DriveLevel); err != nil {
-			log.Println("Failed to set WINMOR drive level:", err)
-		}
+	wmTNC, err = winmor.Open(config.Winmor.Addr, fOptions.MyCall, config.Locator)
+	if err != nil {
+		return tncUnavailableError{fmt.Errorf("WINMOR TNC initialization failed: %s", err)}
 	}
 
 	if v, err := wmTNC.Version(); err != nil {
@@ -243,79 +1743,201 @@ func initWinmorTNC() error {
 		log.Printf("WINMOR TNC v%s initialized", v)
 	}
 
+	applyWinmorSettings()
+
 	transport.RegisterDialer("winmor", wmTNC)
 
-	if !config.Winmor.PTTControl {
-		return nil
+	if ptt != nil {
+		wmTNC.SetPTT(ptt)
 	}
+	watchTNCEvents(MethodWinmor, wmTNC)
+	return nil
+}
 
-	rig, ok := rigs[config.Winmor.Rig]
-	if !ok {
-		return fmt.Errorf("Unable to set PTT rig '%s': Not defined or not loaded.", config.Winmor.Rig)
+// checkRigParam returns an error if the rig given by the URL parameter ?rig= is not loaded.
+func checkRigParam(url *transport.URL) error {
+	name := url.Params.Get("rig")
+	if name == "" {
+		return nil
+	}
+	if _, ok := rigs[name]; ok {
+		return nil
+	}
+	if _, ok := config.HamlibRigs[name]; ok {
+		return fmt.Errorf("Hamlib rig '%s' not loaded.", name)
 	}
-	wmTNC.SetPTT(rig)
+	return fmt.Errorf("Unknown rig '%s'", name)
+}
 
-	return nil
+// pttSetter is implemented by TNCs with PTT controlled by a rig.
+type pttSetter interface {
+	SetPTT(ptt transport.PTTController)
 }
 
-func initArdopTNC() error {
-	if adTNC != nil && adTNC.Ping() == nil {
-		return nil
+// transportPTT returns the PTT config of the given URL's transport.
+func transportPTT(url *transport.URL) (enabled bool, rigName string) {
+	switch url.Scheme {
+	case MethodWinmor:
+		return config.Winmor.PTTControl, config.Winmor.Rig
+	case MethodArdop:
+		conf := ardopConfigOrDefault(url)
+		return conf.PTTControl, conf.Rig
+	case MethodVaraHF:
+		return config.VaraHF.PTTControl, config.VaraHF.Rig
+	case MethodVaraFM:
+		return config.VaraFM.PTTControl, config.VaraFM.Rig
+	default:
+		return false, ""
 	}
+}
 
-	if adTNC != nil {
-		adTNC.Close()
+// setSessionPTT switches the TNC's PTT control to the rig given by the URL parameter ?rig=, if the
+// transport has PTT control enabled.
+//
+// The returned func switches back to the configured rig.
+func setSessionPTT(url *transport.URL, tnc TNC) (revert func(), err error) {
+	noop := func() {}
+	name := url.Params.Get("rig")
+	enabled, confRig := transportPTT(url)
+	if name == "" || !enabled || name == confRig {
+		return noop, nil
 	}
 
-	var err error
-	adTNC, err = ardop.OpenTCP(config.Ardop.Addr, fOptions.MyCall, config.Locator)
+	setter, ok := tnc.(pttSetter)
+	if !ok {
+		return noop, fmt.Errorf("Unable to set PTT rig '%s': Not supported by the %s TNC", name, url.Scheme)
+	}
+	ptt, err := pttRig(url.Scheme, true, name)
 	if err != nil {
-		return fmt.Errorf("ARDOP TNC initialization failed: %s", err)
+		return noop, err
 	}
-
-	if !config.Ardop.ARQBandwidth.IsZero() {
-		if err := adTNC.SetARQBandwidth(config.Ardop.ARQBandwidth); err != nil {
-			return fmt.Errorf("Unable to set ARQ bandwidth for ardop TNC: %s", err)
-		}
+	prev, err := pttRig(url.Scheme, true, confRig)
+	if err != nil {
+		return noop, err
 	}
+	setter.SetPTT(ptt)
+	log.Printf("PTT rig set to %s", name)
+	return func() { setter.SetPTT(prev) }, nil
+}
 
-	if err := adTNC.SetCWID(config.Ardop.CWID); err != nil {
-		return fmt.Errorf("Unable to configure CWID for ardop TNC: %s", err)
+// pttRig returns the PTT controller of the given rig for use by the given transport, or nil if PTT control is disabled.
+//
+// Init funcs look up the rig before opening the TNC, so that a missing rig fails fast without
+// grabbing the sound device.
+func pttRig(scheme string, enabled bool, rigName string) (transport.PTTController, error) {
+	if !enabled {
+		return nil, nil
 	}
-
-	if v, err := adTNC.Version(); err != nil {
-		return fmt.Errorf("ARDOP TNC initialization failed: %s", err)
-	} else {
-		log.Printf("ARDOP TNC (%s) initialized", v)
+	rig, ok := rigs[rigName]
+	if !ok {
+		return nil, fmt.Errorf("Unable to set PTT rig '%s': Not defined or not loaded.", rigName)
 	}
+	return guardedPTT{rig, rigName, scheme}, nil
+}
 
-	transport.RegisterDialer("ardop", adTNC)
+// guardedPTT refuses to key the rig while it is in use by a session of another transport (see tncGuard).
+type guardedPTT struct {
+	transport.PTTController
+	rigName string
+	scheme  string
+}
 
-	if !config.Ardop.PTTControl {
-		return nil
+func (p guardedPTT) SetPTT(on bool) error {
+	if on {
+		if err := tncGuard.checkRig(p.rigName, p.scheme); err != nil {
+			log.Println(err)
+			return err
+		}
 	}
+	return p.PTTController.SetPTT(on)
+}
 
-	rig, ok := rigs[config.Ardop.Rig]
-	if !ok {
-		return fmt.Errorf("Unable to set PTT rig '%s': Not defined or not loaded.", config.Ardop.Rig)
-	}
+// initPactorModem opens the PACTOR modem on the given serial device and baudrate.
+//
+// The device and baudrate from the config is used if empty.
+func initPactorModem(path, baud string) error {
+	tncMu.Lock()
+	defer tncMu.Unlock()
 
-	adTNC.SetPTT(rig)
-	return nil
-}
+	if path == "" {
+		path = config.Pactor.Path
+	}
+	baudrate := config.Pactor.Baudrate
+	if baud != "" {
+		var err error
+		if baudrate, err = strconv.Atoi(baud); err != nil {
+			return fmt.Errorf("Invalid hbaud parameter: %s", err)
+		}
+	}
 
-func initPactorModem() error {
 	if pModem != nil {
 		pModem.Close()
 	}
 
 	var err error
-	pModem, err = pactor.OpenModem(config.Pactor.Path, config.Pactor.Baudrate, fOptions.MyCall, config.Pactor.InitScript)
+	pModem, err = pactor.OpenModem(path, baudrate, fOptions.MyCall, config.Pactor.InitScript)
 	if err != nil || pModem == nil {
-		return fmt.Errorf("Pactor initialization failed: %s", err)
+		return tncUnavailableError{fmt.Errorf("Pactor initialization failed (%s): %s", path, err)}
 	}
 
 	transport.RegisterDialer("pactor", pModem)
 
 	return nil
 }
+
+func initVaraHFTNC() error { return initVaraModem(&varaHFTNC, MethodVaraHF, "VARA HF", config.VaraHF) }
+func initVaraFMTNC() error { return initVaraModem(&varaFMTNC, MethodVaraFM, "VARA FM", config.VaraFM) }
+
+// initVaraModem initializes the VARA modem pointed to by tnc, and registers it as the dialer for scheme.
+func initVaraModem(tnc **vara.Modem, scheme, name string, conf cfg.VaraConfig) error {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+
+	if *tnc != nil && (*tnc).Ping() == nil {
+		return nil
+	}
+
+	if *tnc != nil {
+		log.Printf("%s modem connection lost, reconnecting...", name)
+		(*tnc).Close()
+		*tnc = nil
+	}
+
+	// Fail before grabbing the sound device if the PTT rig is missing
+	ptt, err := pttRig(scheme, conf.PTTControl, conf.Rig)
+	if err != nil {
+		return err
+	}
+
+	m, err := vara.Open(scheme, conf.Addr, fOptions.MyCall)
+	if err != nil {
+		return tncUnavailableError{fmt.Errorf("%s modem initialization failed: %s", name, err)}
+	}
+	*tnc = m
+	watchTNCEvents(scheme, m)
+
+	if v, err := m.Version(); err != nil {
+		return fmt.Errorf("%s modem initialization failed: %s", name, err)
+	} else {
+		log.Printf("%s modem (%s) initialized", name, v)
+	}
+
+	if conf.Bandwidth != 0 {
+		if err := m.SetBandwidth(conf.Bandwidth); err != nil {
+			return fmt.Errorf("Unable to set %s bandwidth: %s", name, err)
+		}
+	}
+
+	if conf.Wide != nil && scheme == MethodVaraFM {
+		if err := m.SetWide(*conf.Wide); err != nil {
+			log.Printf("Unable to set %s wide/narrow mode: %s", name, err)
+		}
+	}
+
+	transport.RegisterDialer(scheme, m)
+
+	if ptt != nil {
+		m.SetPTT(ptt)
+	}
+	return nil
+}
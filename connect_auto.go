@@ -0,0 +1,73 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The connect string selecting the connect strings from the connect history (e.g. auto or auto:ardop).
+const autoConnectStr = "auto"
+
+// isAutoConnectStr returns the scheme of an auto connect string (empty for any), and false if it is not an auto
+// connect string. A connect alias named auto takes precedence.
+func isAutoConnectStr(connectStr string) (scheme string, ok bool) {
+	if _, isAlias := config.ConnectAliases[autoConnectStr]; isAlias {
+		return "", false
+	}
+	switch {
+	case connectStr == autoConnectStr:
+		return "", true
+	case strings.HasPrefix(connectStr, autoConnectStr+":"):
+		return strings.TrimPrefix(connectStr, autoConnectStr+":"), true
+	default:
+		return "", false
+	}
+}
+
+// autoConnectStrs returns the connect strings to try for an auto connect with the given scheme (empty for any):
+// the previously successful connect strings (see selectAuto), or config.AutoConnect if there are none.
+func autoConnectStrs(scheme string) ([]string, error) {
+	if selected := selectAuto(reachCache.History(), scheme); len(selected) > 0 {
+		return selected, nil
+	}
+
+	var fallback []string
+	for _, str := range config.AutoConnect {
+		if scheme == "" {
+			fallback = append(fallback, str)
+		} else if url, err := ResolveConnectURL(str); err == nil && url.Scheme == scheme {
+			fallback = append(fallback, str)
+		}
+	}
+	if len(fallback) == 0 {
+		return nil, fmt.Errorf("No connect history for auto connect, and no matching connect strings in auto_connect")
+	}
+	return fallback, nil
+}
+
+// selectAuto returns the connect strings of the successful connects in history with the given scheme (empty for
+// any), most recent first and without duplicates.
+func selectAuto(history []connectRecord, scheme string) []string {
+	var succeeded []connectRecord
+	for _, rec := range history {
+		if rec.Success && (scheme == "" || rec.Scheme == scheme) {
+			succeeded = append(succeeded, rec)
+		}
+	}
+	sort.SliceStable(succeeded, func(i, j int) bool { return succeeded[i].Time.After(succeeded[j].Time) })
+
+	seen := make(map[string]bool, len(succeeded))
+	var selected []string
+	for _, rec := range succeeded {
+		if !seen[rec.ConnectStr] {
+			seen[rec.ConnectStr] = true
+			selected = append(selected, rec.ConnectStr)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestSelectAuto(t *testing.T) {
+	at := func(min int) time.Time { return time.Unix(0, 0).Add(time.Duration(min) * time.Minute) }
+	history := []connectRecord{
+		{Time: at(1), ConnectStr: "LA1B", Scheme: MethodArdop, Success: true},
+		{Time: at(2), ConnectStr: "telnet", Scheme: MethodTelnet, Success: true},
+		{Time: at(3), ConnectStr: "LA2B", Scheme: MethodArdop, Success: false},
+		{Time: at(5), ConnectStr: "LA3C", Scheme: MethodVaraHF, Success: true},
+		{Time: at(4), ConnectStr: "LA1B", Scheme: MethodArdop, Success: true},
+		{Time: at(6), ConnectStr: "LA4D", Scheme: MethodArdop, Success: true},
+		{Time: at(6), ConnectStr: "LA5E", Scheme: MethodArdop, Success: true},
+	}
+
+	tests := []struct {
+		history []connectRecord
+		scheme  string
+		want    []string
+	}{
+		{nil, "", nil},
+		{history[2:3], "", nil}, // Failed connects only
+		{history, "", []string{"LA4D", "LA5E", "LA3C", "LA1B", "telnet"}},
+		{history, MethodArdop, []string{"LA4D", "LA5E", "LA1B"}},
+		{history, MethodVaraHF, []string{"LA3C"}},
+		{history, MethodWinmor, nil},
+	}
+	for _, tt := range tests {
+		if got := selectAuto(tt.history, tt.scheme); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectAuto(%d records, %q) = %q, want %q", len(tt.history), tt.scheme, got, tt.want)
+		}
+	}
+}
+
+func TestIsAutoConnectStr(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+
+	tests := []struct {
+		in         string
+		wantScheme string
+		wantOK     bool
+	}{
+		{"auto", "", true},
+		{"auto:ardop", MethodArdop, true},
+		{"autoardop", "", false},
+		{"ardop:///LA1B", "", false},
+	}
+	for _, tt := range tests {
+		if scheme, ok := isAutoConnectStr(tt.in); scheme != tt.wantScheme || ok != tt.wantOK {
+			t.Errorf("isAutoConnectStr(%q) = %q, %t, want %q, %t", tt.in, scheme, ok, tt.wantScheme, tt.wantOK)
+		}
+	}
+
+	// An alias named auto takes precedence
+	config.ConnectAliases = map[string]string{"auto": "telnet"}
+	if _, ok := isAutoConnectStr("auto"); ok {
+		t.Error("isAutoConnectStr(auto) = true with alias auto")
+	}
+}
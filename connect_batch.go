@@ -0,0 +1,69 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// readConnectTargets reads connect strings (or aliases) from the file at path ("-" for stdin), one per line.
+//
+// Blank lines and lines starting with # are ignored.
+func readConnectTargets(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var targets []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, s.Err()
+}
+
+// batchError is returned by connectBatch if one or more targets failed.
+type batchError struct{ failed, total int }
+
+func (e batchError) Error() string { return fmt.Sprintf("%d of %d connects failed", e.failed, e.total) }
+
+// connectBatch connects to each of the targets in turn, continuing past failures, and prints a tally.
+func connectBatch(targets []string) error {
+	var failed []string
+	for i, str := range targets {
+		if i > 0 {
+			connectCooldown.wait(context.Background(), 0)
+		}
+		log.Printf("Batch: connecting to %s (%d of %d)...", str, i+1, len(targets))
+		if res := ConnectWithResult(context.Background(), str); !res.Success {
+			failed = append(failed, str)
+		}
+	}
+
+	fmt.Printf("Batch complete: %d succeeded, %d failed (of %d)\n", len(targets)-len(failed), len(failed), len(targets))
+	for _, str := range failed {
+		fmt.Printf("  FAILED: %s\n", str)
+	}
+	if len(failed) > 0 {
+		return batchError{len(failed), len(targets)}
+	}
+	return nil
+}
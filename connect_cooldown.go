@@ -0,0 +1,80 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// connectCooldown keeps automated connects (scheduled connects, and the next candidate when trying multiple
+// aliases/URLs in turn) at least config.ConnectCooldown apart. Manual connects are not delayed.
+var connectCooldown = &cooldown{clock: realClock{}, skip: make(chan struct{})}
+
+type cooldown struct {
+	mu      sync.Mutex
+	clock   clock
+	lastEnd time.Time     // The time the last connect ended.
+	waiting int           // The number of connects waiting for the cool-down.
+	skip    chan struct{} // Closed (and replaced) by Skip.
+}
+
+// done marks the end of a connect.
+func (c *cooldown) done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastEnd = c.clock.Now()
+}
+
+// wait blocks until the cool-down since the last connect has passed, Skip is called or ctx is done.
+//
+// At least minDelay is waited, regardless of the cool-down (e.g. config.ConnectAttemptDelay).
+func (c *cooldown) wait(ctx context.Context, minDelay time.Duration) error {
+	c.mu.Lock()
+	var remaining time.Duration
+	if config.ConnectCooldown > 0 && !c.lastEnd.IsZero() {
+		remaining = time.Duration(config.ConnectCooldown)*time.Second - c.clock.Now().Sub(c.lastEnd)
+	}
+	if minDelay > remaining {
+		remaining = minDelay
+	}
+	if remaining <= 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	skip := c.skip
+	c.waiting++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.waiting--
+		c.mu.Unlock()
+	}()
+
+	log.Printf("Waiting %s before next connect...", remaining.Round(time.Second))
+	select {
+	case <-c.clock.After(remaining):
+		return nil
+	case <-skip:
+		log.Println("Cool-down skipped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Skip ends the cool-down of all connects waiting for it, returning false if none are waiting.
+func (c *cooldown) Skip() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.waiting == 0 {
+		return false
+	}
+	close(c.skip)
+	c.skip = make(chan struct{})
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestCooldownWait(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+
+	tests := []struct {
+		cooldown  int           // config.ConnectCooldown
+		connected bool          // A connect has ended.
+		elapsed   time.Duration // Since the connect ended.
+		minDelay  time.Duration
+		wantWaits []time.Duration
+	}{
+		{cooldown: 30},
+		{cooldown: 30, connected: true, wantWaits: []time.Duration{30 * time.Second}},
+		{cooldown: 30, connected: true, elapsed: 10 * time.Second, wantWaits: []time.Duration{20 * time.Second}},
+		{cooldown: 30, connected: true, elapsed: time.Minute},
+		{cooldown: 30, connected: true, minDelay: 45 * time.Second, wantWaits: []time.Duration{45 * time.Second}},
+		{cooldown: 30, connected: true, minDelay: 5 * time.Second, wantWaits: []time.Duration{30 * time.Second}},
+		{connected: true},
+		{connected: true, minDelay: 5 * time.Second, wantWaits: []time.Duration{5 * time.Second}},
+		{minDelay: 5 * time.Second, wantWaits: []time.Duration{5 * time.Second}},
+	}
+	for i, tt := range tests {
+		config = cfg.Config{ConnectCooldown: tt.cooldown}
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := &cooldown{clock: clock, skip: make(chan struct{})}
+		if tt.connected {
+			c.done()
+		}
+		clock.now = clock.now.Add(tt.elapsed)
+		if err := c.wait(context.Background(), tt.minDelay); err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+		if !reflect.DeepEqual(clock.waits, tt.wantWaits) {
+			t.Errorf("%d: got waits %v, want %v", i, clock.waits, tt.wantWaits)
+		}
+	}
+}
+
+func TestCooldownInterrupt(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{ConnectCooldown: 3600}
+	c := &cooldown{clock: realClock{}, skip: make(chan struct{})}
+	c.done()
+
+	if c.Skip() {
+		t.Error("Skip() = true with no connects waiting")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.wait(ctx, 0); err != context.Canceled {
+		t.Errorf("wait: got error %v, want %v", err, context.Canceled)
+	}
+
+	// Skip ends both the cool-down and the minimum delay
+	done := make(chan error, 1)
+	go func() { done <- c.wait(context.Background(), 2*time.Hour) }()
+	for !c.Skip() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("wait: unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait: not ended by Skip")
+	}
+}
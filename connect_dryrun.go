@@ -0,0 +1,245 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+	"github.com/la5nta/wl2k-go/transport/ardop"
+)
+
+// dryRunConnect resolves and validates connectStr the same way Connect does, and prints a summary
+// of what a real connect would do.
+//
+// Neither the TNC nor the rig is touched: the TNC is not initialized (only reported as configured and
+// whether it is already open), and the rig frequency is never read or changed. An error is returned if
+// any problem that would fail a real connect was found.
+func dryRunConnect(connectStr string) error {
+	if str, err := resolveAlias(config.ConnectAliases, connectStr); err == nil && isRMSConnectStr(str) {
+		return dryRunRMS(str)
+	}
+
+	url, err := ResolveConnectURL(connectStr)
+	if err != nil {
+		return err
+	}
+
+	pf := func(key string, v interface{}) { fmt.Printf("  %-14s %v\n", key+":", v) }
+
+	fmt.Printf("Dry run: %s\n", connectStr)
+	pf("URL", url)
+	pf("Scheme", url.Scheme)
+	pf("Mycall", url.User.Username())
+	pf("Target", url.Target)
+	if url.Host != "" {
+		pf("Host", url.Host)
+	}
+	if len(url.Digis) > 0 {
+		pf("Digis", strings.Join(url.Digis, ","))
+	}
+	keys := make([]string, 0, len(url.Params))
+	for key := range url.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pf("?"+key, url.Params.Get(key))
+	}
+
+	var errs []error
+
+	// TNC
+	if tncs.Has(url.Scheme) {
+		if status, err := tncStatus(url); err != nil {
+			errs = append(errs, err)
+			pf("TNC", fmt.Sprintf("FAILED (%s)", err))
+		} else if status != "" {
+			pf("TNC", status)
+		}
+	}
+	isBusyChecker := hasBusyDetection(url.Scheme)
+
+	// ARQ bandwidth
+	if str := url.Params.Get("bw"); str != "" && url.Scheme == MethodArdop {
+		if bw, err := ardop.BandwidthFromString(str); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid ARQ bandwidth '%s': %s", str, err))
+			pf("Bandwidth", fmt.Sprintf("INVALID (%s)", err))
+		} else {
+			pf("Bandwidth", bw)
+		}
+	}
+	if str := url.Params.Get("cwid"); str != "" && url.Scheme == MethodArdop {
+		if cwid, err := strconv.ParseBool(str); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid cwid parameter: %s", err))
+			pf("CWID", fmt.Sprintf("INVALID (%s)", err))
+		} else {
+			pf("CWID", cwid)
+		}
+	}
+
+	if p2p, err := p2pMode(url); err != nil {
+		errs = append(errs, err)
+		pf("Exchange mode", fmt.Sprintf("INVALID (%s)", err))
+	} else if p2p != nil {
+		pf("Exchange mode", exchangeMode(*p2p))
+	}
+
+	// QSY
+	if freq := url.Params.Get("freq"); freq != "" {
+		rigName, rig, err := qsyRig(url)
+		switch {
+		case err != nil && rigName == "":
+			errs = append(errs, fmt.Errorf("Unable to QSY: %s", err))
+			pf("Rig", fmt.Sprintf("FAILED (%s)", err))
+		case err != nil:
+			errs = append(errs, fmt.Errorf("Unable to QSY: %s", err))
+			pf("Rig", fmt.Sprintf("%s (FAILED: %s)", rigName, err))
+		default:
+			pf("Rig", fmt.Sprintf("%s (loaded)", rigName))
+		}
+
+		var offset int
+		if isCenter, _ := strconv.ParseBool(url.Params.Get("center")); isCenter {
+			offset = freqOffset(config.HamlibRigs[rigName], url.Scheme)
+		}
+		if f, err := ParseFrequency(freq); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to QSY: %s", err))
+			pf("QSY", fmt.Sprintf("FAILED (%s)", err))
+		} else {
+			pf("QSY", f+Frequency(offset))
+		}
+
+		if mode := qsyMode(url); mode != "" {
+			_, canSetMode := rigModes[rigName]
+			if rig != nil && !canSetMode {
+				mode += " (not supported by rig, frequency only QSY)"
+			}
+			pf("Mode", mode)
+		}
+
+		settle, qsx := qsyDelays(config.HamlibRigs[rigName])
+		if v := url.Params.Get("settle"); v != "" {
+			if settle, err = time.ParseDuration(v); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid settle parameter: %s", err))
+			}
+		}
+		pf("QSY delays", fmt.Sprintf("settle %s, qsx %s", settle, qsx))
+	}
+
+	// Timeouts and retries
+	if d, err := busyTimeout(url); err != nil {
+		errs = append(errs, err)
+	} else if isBusyChecker {
+		pf("Busy timeout", durationOrNone(d))
+	}
+	if d, err := busyClearWindow(url); err != nil {
+		errs = append(errs, err)
+	} else if isBusyChecker && d > 0 {
+		pf("Busy clear window", d.String())
+	}
+	if d, err := connectTimeout(url); err != nil {
+		errs = append(errs, err)
+	} else {
+		pf("Dial timeout", durationOrNone(d))
+	}
+	if retries, backoff, err := connectRetries(url); err != nil {
+		errs = append(errs, err)
+	} else {
+		pf("Retries", fmt.Sprintf("%d (backoff %s)", retries, backoff))
+	}
+
+	if len(errs) == 0 {
+		fmt.Println("Dry run OK, nothing was transmitted.")
+		return nil
+	}
+	for _, err := range errs {
+		fmt.Printf("Error: %s\n", err)
+	}
+	return fmt.Errorf("Dry run failed with %d error(s)", len(errs))
+}
+
+// tncStatus describes the TNC used by the given URL's transport without initializing it.
+//
+// An empty string is returned for transports without a TNC of their own (e.g. ax25).
+func tncStatus(url *transport.URL) (string, error) {
+	var addr string
+	var open bool
+	switch url.Scheme {
+	case MethodArdop:
+		conf, err := ardopConfigForURL(url)
+		if err != nil {
+			return "", err
+		}
+		_, open = ardopTNC(conf)
+		addr = conf.Addr
+	case MethodWinmor:
+		open = lockedTNC(func() TNC { return wmTNC }) != nil
+		addr = config.Winmor.Addr
+	case MethodVaraHF:
+		open = lockedTNC(func() TNC { return varaHFTNC }) != nil
+		addr = config.VaraHF.Addr
+	case MethodVaraFM:
+		open = lockedTNC(func() TNC { return varaFMTNC }) != nil
+		addr = config.VaraFM.Addr
+	case MethodPactor:
+		open = lockedTNC(func() TNC { return pModem }) != nil
+		addr = url.Host
+	default:
+		return "", nil
+	}
+	if addr == "" {
+		return "", fmt.Errorf("Missing TNC address in config section for %s", url.Scheme)
+	}
+	if open {
+		return addr + " (open)", nil
+	}
+	return addr + " (not open, opened on connect)", nil
+}
+
+// hasBusyDetection returns true if the given transport waits for a clear channel before connecting.
+func hasBusyDetection(scheme string) bool {
+	switch scheme {
+	case MethodArdop, MethodWinmor, MethodVaraHF, MethodVaraFM, MethodAX25:
+		return true
+	default:
+		return false
+	}
+}
+
+func durationOrNone(d time.Duration) string {
+	if d == 0 {
+		return "none"
+	}
+	return d.String()
+}
+
+// dryRunRMS does a dry run of each of the channels the rms:<callsign> connect string resolves to.
+//
+// An error is returned only if all channels fail.
+func dryRunRMS(connectStr string) error {
+	urls, err := rmsConnectURLs(connectStr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s resolved to %d channel(s), tried in order:\n", connectStr, len(urls))
+	var failed int
+	for _, str := range urls {
+		fmt.Println()
+		if err := dryRunConnect(str); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			failed++
+		}
+	}
+	if failed == len(urls) {
+		return fmt.Errorf("Dry run failed for all channels")
+	}
+	return nil
+}
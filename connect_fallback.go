@@ -0,0 +1,88 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// connectChain tries each link (alias or URL) of the named fallback chain in turn (see config.ConnectFallbacks),
+// until one succeeds. Any QSY is reverted before the next link is tried.
+//
+// If config.ConnectFallbackTimeout is set, no link is started after the timeout, and the busy channel wait
+// and dial timeout of each link is capped to the time remaining. An exchange in progress is never cut short.
+func connectChain(name string, links []string) (winner string, err error) {
+	if len(links) == 0 {
+		return "", fmt.Errorf("Fallback chain '%s' is empty", name)
+	}
+
+	var deadline time.Time
+	if config.ConnectFallbackTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(config.ConnectFallbackTimeout) * time.Second)
+	}
+
+	for i, link := range links {
+		if i > 0 {
+			connectCooldown.wait(context.Background(), 0)
+		}
+
+		connectStr := link
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				err = fmt.Errorf("Fallback chain '%s' timed out after %s", name, time.Duration(config.ConnectFallbackTimeout)*time.Second)
+				break
+			}
+			connectStr = capLinkTimeouts(link, remaining)
+		}
+
+		log.Printf("Fallback chain %s: trying link %d/%d (%s)...", name, i+1, len(links), link)
+		res := ConnectWithResult(context.Background(), connectStr)
+		if res.Success {
+			log.Printf("Fallback chain %s: link %d/%d (%s) succeeded.", name, i+1, len(links), link)
+			eventLog.Log("fallback_chain", map[string]interface{}{
+				"chain":   name,
+				"link":    link,
+				"index":   i,
+				"success": true,
+			})
+			return link, nil
+		}
+		err = res.Err
+	}
+
+	log.Printf("Fallback chain %s: all links failed.", name)
+	e := map[string]interface{}{"chain": name, "success": false}
+	if err != nil {
+		e["error"] = err.Error()
+	}
+	eventLog.Log("fallback_chain", e)
+	return "", err
+}
+
+// capLinkTimeouts caps the busy timeout and dial timeout of the connect string to max, by setting the
+// ?busy_timeout= and ?dial_timeout= parameters unless the resolved values are already smaller.
+func capLinkTimeouts(connectStr string, max time.Duration) string {
+	u, err := ResolveConnectURL(connectStr)
+	if err != nil {
+		return connectStr // Not a single URL (e.g. rms:), or the connect reports the error
+	}
+
+	params := url.Values{}
+	if d, err := busyTimeout(u); err == nil && (d == 0 || d > max) {
+		params.Set("busy_timeout", max.String())
+	}
+	if d, err := connectTimeout(u); err == nil && (d == 0 || d > max) {
+		params.Set("dial_timeout", max.String())
+	}
+	if str, err := mergeParams(connectStr, params.Encode()); err == nil {
+		return str
+	}
+	return connectStr
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// The default maximum duration of a connect hook command (see ConnectHooks.Timeout).
+const defaultHookTimeout = 2 * time.Minute
+
+// hookError is returned when a hook command fails (or times out).
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e hookError) Error() string { return fmt.Sprintf("%s_cmd failed: %s", e.hook, e.err) }
+
+// isPreConnectAbort returns true if the connect was aborted by the pre_connect hook.
+func isPreConnectAbort(err error) bool {
+	e, ok := err.(hookError)
+	return ok && e.hook == "pre_connect"
+}
+
+// connectHooks returns the connect hooks for the given connect string.
+//
+// Hooks defined for the connect alias take precedence over the global hooks.
+func connectHooks(connectStr string) cfg.ConnectHooks {
+	hooks := config.ConnectHooks
+	name, _ := splitParams(connectStr)
+	if aliasHooks, ok := config.AliasConnectHooks[name]; ok {
+		if aliasHooks.PreConnectCmd != "" {
+			hooks.PreConnectCmd = aliasHooks.PreConnectCmd
+		}
+		if aliasHooks.PostConnectCmd != "" {
+			hooks.PostConnectCmd = aliasHooks.PostConnectCmd
+		}
+		if aliasHooks.PreTransmitCmd != "" {
+			hooks.PreTransmitCmd = aliasHooks.PreTransmitCmd
+		}
+		if aliasHooks.PostTransmitCmd != "" {
+			hooks.PostTransmitCmd = aliasHooks.PostTransmitCmd
+		}
+		if aliasHooks.Timeout > 0 {
+			hooks.Timeout = aliasHooks.Timeout
+		}
+	}
+	return hooks
+}
+
+// runPostConnectHook runs the post_connect hook (if any) with the outcome of the connect, logging any error.
+//
+// The hook is not run if the connect was aborted by the pre_connect hook.
+func runPostConnectHook(connectStr string, res ConnectResult) {
+	if res.URL == nil || isPreConnectAbort(res.Err) {
+		return
+	}
+	hooks := connectHooks(connectStr)
+	if err := runHook(context.Background(), "post_connect", hooks.PostConnectCmd, connectStr, res.URL, &res); err != nil {
+		log.Println(err)
+	}
+}
+
+// runHook runs the given hook command with environment variables describing the connect, logging its output.
+//
+// The variables are PAT_HOOK (e.g. pre_connect or pre_transmit), PAT_CONNECT_STR, PAT_TARGET, PAT_SCHEME and PAT_FREQ (kHz, if any).
+// If res is given (post_connect), the outcome is described by PAT_SUCCESS, PAT_ERROR, PAT_BYTES_IN, PAT_BYTES_OUT and
+// PAT_DURATION (seconds). The same details are written to the command's stdin as a JSON object.
+func runHook(ctx context.Context, hook, command, connectStr string, url *transport.URL, res *ConnectResult) error {
+	if command == "" {
+		return nil
+	}
+
+	timeout := defaultHookTimeout
+	if secs := connectHooks(connectStr).Timeout; secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	info := map[string]interface{}{
+		"hook":        hook,
+		"connect_str": connectStr,
+		"target":      url.Target,
+		"scheme":      url.Scheme,
+		"freq":        url.Params.Get("freq"),
+	}
+	cmd.Env = append(os.Environ(),
+		"PAT_HOOK="+hook,
+		"PAT_CONNECT_STR="+connectStr,
+		"PAT_TARGET="+url.Target,
+		"PAT_SCHEME="+url.Scheme,
+		"PAT_FREQ="+url.Params.Get("freq"),
+	)
+	if res != nil {
+		var errStr string
+		if res.Err != nil {
+			errStr = res.Err.Error()
+		}
+		info["success"], info["error"] = res.Success, errStr
+		info["bytes_in"], info["bytes_out"] = res.BytesIn, res.BytesOut
+		info["duration"] = res.Duration.Seconds()
+		cmd.Env = append(cmd.Env,
+			"PAT_SUCCESS="+strconv.FormatBool(res.Success),
+			"PAT_ERROR="+errStr,
+			"PAT_BYTES_IN="+strconv.FormatInt(res.BytesIn, 10),
+			"PAT_BYTES_OUT="+strconv.FormatInt(res.BytesOut, 10),
+			"PAT_DURATION="+strconv.FormatFloat(res.Duration.Seconds(), 'f', 0, 64),
+		)
+	}
+	stdin, _ := json.Marshal(info)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	log.Printf("Running %s_cmd...", hook)
+	out, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		log.Printf("%s_cmd: %s", hook, scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return hookError{hook, fmt.Errorf("timed out after %s", timeout)}
+	}
+	if err != nil {
+		return hookError{hook, err}
+	}
+	return nil
+}
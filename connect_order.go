@@ -0,0 +1,172 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ConnectOrderAsGiven    = ""            // Try the connect strings in the given order.
+	ConnectOrderShuffle    = "shuffle"     // Try the connect strings in random order.
+	ConnectOrderRoundRobin = "round-robin" // Rotate the first connect string between invocations.
+)
+
+// ConnectOrder decides the order in which connectAny tries a list of connect strings.
+type ConnectOrder struct {
+	Mode string // One of the ConnectOrder* constants.
+
+	// If true, the last successful connect string of a list is tried first.
+	PreferLastSuccess bool
+
+	// The source of randomness used by the shuffle mode.
+	Rand *rand.Rand
+
+	mu          sync.Mutex
+	next        map[string]int    // Next round-robin offset by list.
+	lastSuccess map[string]string // Last successful connect string by list.
+}
+
+// NewConnectOrder returns a new ConnectOrder with the given mode.
+func NewConnectOrder(mode string, preferLastSuccess bool, rand *rand.Rand) (*ConnectOrder, error) {
+	switch mode {
+	case ConnectOrderAsGiven, ConnectOrderShuffle, ConnectOrderRoundRobin:
+	default:
+		return nil, fmt.Errorf("Unknown connect order '%s'", mode)
+	}
+	return &ConnectOrder{
+		Mode:              mode,
+		PreferLastSuccess: preferLastSuccess,
+		Rand:              rand,
+		next:              make(map[string]int),
+		lastSuccess:       make(map[string]string),
+	}, nil
+}
+
+// Order returns a copy of connectStr in the order the connect strings should be tried.
+func (o *ConnectOrder) Order(connectStr []string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := strings.Join(connectStr, "\n")
+	ordered := make([]string, len(connectStr))
+	copy(ordered, connectStr)
+	if len(ordered) < 2 {
+		return ordered
+	}
+
+	switch o.Mode {
+	case ConnectOrderShuffle:
+		o.Rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	case ConnectOrderRoundRobin:
+		offset := o.next[key] % len(ordered)
+		ordered = append(ordered[offset:], ordered[:offset]...)
+		o.next[key] = offset + 1
+	}
+
+	if last, ok := o.lastSuccess[key]; ok && o.PreferLastSuccess {
+		for i, str := range ordered {
+			if str == last {
+				copy(ordered[1:i+1], ordered[:i])
+				ordered[0] = last
+				break
+			}
+		}
+	}
+
+	return ordered
+}
+
+// Success records the connect string that succeeded for the given list of connect strings.
+func (o *ConnectOrder) Success(connectStr []string, winner string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastSuccess[strings.Join(connectStr, "\n")] = winner
+}
+
+// orderConnectStrs returns the connect strings in the order connectAny should try them.
+//
+// The order is decided by the following, in order of precedence. Each only decides between the connect
+// strings that are equal by the ones above it.
+//
+//  1. smart_order: Connect strings with an unusable channel are tried last.
+//  2. reach_cache: Connect strings reached recently are tried first (most recent first), and the ones that
+//     failed recently are tried last.
+//  3. propagation_order: HF connect strings are ordered by band, keeping the positions of the others.
+//  4. connect_order (and connect_order_prefer_last).
+//
+// With reach_cache.skip, the connect strings that failed recently are left out, unless all of them did.
+func orderConnectStrs(connectStr []string) []string {
+	ordered := connectOrder.Order(connectStr)
+	if len(ordered) < 2 {
+		return ordered
+	}
+	if p := propagationPredictor(); p != nil {
+		ordered = propagationOrder(p, ordered)
+	}
+
+	outcomes := reachCache.Outcomes(ordered)
+	var failed []string
+	for _, str := range ordered {
+		if o, ok := outcomes[str]; ok && !o.success {
+			failed = append(failed, str)
+		}
+	}
+	switch {
+	case len(failed) == 0:
+	case config.ReachCache.Skip && len(failed) < len(ordered):
+		log.Printf("Skipping recently failed: %s", strings.Join(failed, ", "))
+		ordered = without(ordered, failed)
+	case config.ReachCache.Skip:
+		log.Println("All targets failed recently, trying them anyway.")
+	default:
+		log.Printf("Trying recently failed last: %s", strings.Join(failed, ", "))
+	}
+
+	var unusable map[string]bool
+	if config.SmartOrder {
+		unusable = unusableChannels(ordered)
+	}
+
+	// The reach cache rank: reached, unknown and failed
+	reachRank := func(str string) int {
+		o, ok := outcomes[str]
+		switch {
+		case !ok:
+			return 1
+		case o.success:
+			return 0
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if unusable[a] != unusable[b] {
+			return unusable[b]
+		}
+		if ra, rb := reachRank(a), reachRank(b); ra != rb {
+			return ra < rb
+		}
+		return reachRank(a) == 0 && outcomes[a].time.After(outcomes[b].time)
+	})
+	return ordered
+}
+
+// without returns the strings of slice not in remove.
+func without(slice, remove []string) []string {
+	var result []string
+	for _, str := range slice {
+		if !containsStr(remove, str) {
+			result = append(result, str)
+		}
+	}
+	return result
+}
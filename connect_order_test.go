@@ -0,0 +1,85 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestConnectOrder(t *testing.T) {
+	strs := []string{"a", "b", "c"}
+
+	asGiven, _ := NewConnectOrder(ConnectOrderAsGiven, false, nil)
+	for i := 0; i < 2; i++ {
+		if got := asGiven.Order(strs); !reflect.DeepEqual(got, strs) {
+			t.Errorf("as given: got %q, want %q", got, strs)
+		}
+	}
+
+	roundRobin, _ := NewConnectOrder(ConnectOrderRoundRobin, false, nil)
+	for _, want := range [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}} {
+		if got := roundRobin.Order(strs); !reflect.DeepEqual(got, want) {
+			t.Errorf("round-robin: got %q, want %q", got, want)
+		}
+	}
+
+	shuffle, _ := NewConnectOrder(ConnectOrderShuffle, false, rand.New(rand.NewSource(1)))
+	got := shuffle.Order(strs)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, strs) {
+		t.Errorf("shuffle: got %q, want a permutation of %q", got, strs)
+	}
+
+	preferLast, _ := NewConnectOrder(ConnectOrderAsGiven, true, nil)
+	preferLast.Success(strs, "c")
+	if got, want := preferLast.Order(strs), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prefer last: got %q, want %q", got, want)
+	}
+
+	if _, err := NewConnectOrder("random", false, nil); err == nil {
+		t.Error("Expected error for unknown connect order")
+	}
+}
+
+func TestOrderConnectStrs(t *testing.T) {
+	defer func(c cfg.Config, o *ConnectOrder) { config, connectOrder = c, o }(config, connectOrder)
+	defer reachCache.Clear()
+
+	const (
+		reached = "ardop:///LA1B?freq=7064"
+		failed  = "ardop:///LA2B?freq=3590"
+		unknown = "ardop:///LA3C?freq=14094"
+		telnet  = "telnet:///LA4D"
+	)
+	config = cfg.Config{}
+	config.ReachCache.TTL = 3600
+	connectOrder, _ = NewConnectOrder(ConnectOrderAsGiven, false, nil)
+	reachCache.Clear()
+	reachCache.Write(Event{"what": "connect_result", "connect_str": reached, "scheme": "ardop", "success": true})
+	reachCache.Write(Event{"what": "connect_result", "connect_str": failed, "scheme": "ardop", "success": false})
+
+	tests := []struct {
+		skip bool
+		in   []string
+		want []string
+	}{
+		{false, []string{telnet}, []string{telnet}},
+		{false, []string{failed}, []string{failed}},
+		{false, []string{failed, unknown, reached, telnet}, []string{reached, unknown, telnet, failed}},
+		{true, []string{failed, unknown, reached}, []string{reached, unknown}},
+		{true, []string{failed, failed + "&bw=500MAX"}, []string{failed, failed + "&bw=500MAX"}}, // Never drops all
+	}
+	for _, tt := range tests {
+		config.ReachCache.Skip = tt.skip
+		if got := orderConnectStrs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("orderConnectStrs(%q) (skip=%t) = %q, want %q", tt.in, tt.skip, got, tt.want)
+		}
+	}
+}
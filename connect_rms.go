@@ -0,0 +1,196 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pd0mz/go-maidenhead"
+
+	"github.com/la5nta/pat/internal/cmsapi"
+)
+
+// The prefix of connect strings addressing an RMS gateway by callsign (e.g. rms:LA1B-10).
+const rmsPrefix = "rms:"
+
+// The transports used to dial packet channels.
+var packetTransports = []string{MethodAX25, MethodAGWPE, MethodKISSTCP, MethodSerialTNC}
+
+func isRMSConnectStr(connectStr string) bool {
+	return strings.HasPrefix(strings.ToLower(connectStr), rmsPrefix)
+}
+
+// dialRMS dials the RMS gateway given by the rms:<callsign> connect string, trying each of the
+// gateway's channels (see rmsConnectURLs) in turn until one succeeds.
+func dialRMS(ctx context.Context, connectStr string) (*dialedConn, error) {
+	urls, err := rmsConnectURLs(connectStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, str := range urls {
+		log.Printf("Trying %s (channel %d of %d)...", str, i+1, len(urls))
+		conn, err := dial(ctx, str)
+		switch {
+		case err == nil:
+			return conn, nil
+		case i == len(urls)-1 || ctx.Err() != nil:
+			return nil, err
+		default:
+			log.Println(err)
+		}
+	}
+	panic("not reached")
+}
+
+// rmsConnectURLs looks up the callsign of the rms:<callsign>[?params] connect string in the RMS
+// list, and returns a connect URL (including freq) for each channel supported by the transports in
+// config.RMSTransports.
+//
+// The URLs are ordered by transport preference, then by distance. Any params are merged onto each URL.
+func rmsConnectURLs(connectStr string) ([]string, error) {
+	str, params := splitParams(connectStr[len(rmsPrefix):])
+	callsign := strings.ToUpper(strings.TrimLeft(str, "/"))
+	if callsign == "" {
+		return nil, fmt.Errorf("Missing gateway callsign in '%s'", connectStr)
+	}
+
+	status, err := loadRMSList(false)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load RMS list: %s", err)
+	}
+
+	var gw *cmsapi.Gateway
+	for i := range status.Gateways {
+		if strings.EqualFold(status.Gateways[i].Callsign, callsign) {
+			gw = &status.Gateways[i]
+			break
+		}
+	}
+	if gw == nil {
+		if matches := closeCallsigns(status.Gateways, callsign); len(matches) > 0 {
+			return nil, fmt.Errorf("Unknown RMS gateway '%s' (did you mean %s?)", callsign, strings.Join(matches, ", "))
+		}
+		return nil, fmt.Errorf("Unknown RMS gateway '%s'", callsign)
+	}
+
+	me, locErr := maidenhead.ParseLocator(config.Locator)
+
+	type candidate struct {
+		url      string
+		pref     int
+		distance float64
+	}
+	var candidates []candidate
+	for _, channel := range gw.Channels {
+		scheme, pref := rmsTransport(channel)
+		if scheme == "" {
+			continue
+		}
+
+		freq := Frequency(channel.Frequency).Dial(channel.SupportedModes)
+		url, err := mergeParams(fmt.Sprintf("%s:///%s?freq=%v", scheme, gw.Callsign, freq.KHz()), params)
+		if err != nil {
+			return nil, err
+		}
+
+		c := candidate{url: url, pref: pref}
+		if them, err := maidenhead.ParseLocator(channel.Gridsquare); err == nil && locErr == nil {
+			c.distance = me.Distance(them)
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("RMS gateway '%s' has no channels supported by the configured transports (%s)", gw.Callsign, strings.Join(config.RMSTransports, ", "))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].pref != candidates[j].pref {
+			return candidates[i].pref < candidates[j].pref
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	urls := make([]string, len(candidates))
+	for i, c := range candidates {
+		urls[i] = c.url
+	}
+	return urls, nil
+}
+
+// rmsTransport returns the transport (and its index in config.RMSTransports) to use for the given channel.
+//
+// The scheme is empty if the channel is not supported by any of the configured transports.
+func rmsTransport(channel cmsapi.GatewayChannel) (scheme string, pref int) {
+	transport := toTransport(channel)
+	for i, method := range config.RMSTransports {
+		switch {
+		case method == transport:
+			return method, i
+		case transport == "packet":
+			for _, t := range packetTransports {
+				if method == t {
+					return method, i
+				}
+			}
+		}
+	}
+	return "", 0
+}
+
+// closeCallsigns returns the callsigns of the gateways that are similar to callsign (same base
+// callsign or a small edit distance), sorted alphabetically.
+func closeCallsigns(gateways []cmsapi.Gateway, callsign string) []string {
+	const maxMatches = 5
+	base := strings.SplitN(callsign, "-", 2)[0]
+
+	var matches []string
+	for _, gw := range gateways {
+		gwCall := strings.ToUpper(gw.Callsign)
+		if strings.SplitN(gwCall, "-", 2)[0] == base || editDistance(gwCall, callsign) <= 2 {
+			matches = append(matches, gw.Callsign)
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) > maxMatches {
+		matches = matches[:maxMatches]
+	}
+	return matches
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
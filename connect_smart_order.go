@@ -0,0 +1,164 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+const (
+	defaultSmartOrderBudget = 3 * time.Second        // The default maximum total duration of the channel probes.
+	smartOrderPollInterval  = 250 * time.Millisecond // The interval between busy readings while probing.
+)
+
+// snrReporter is implemented by TNCs reporting the signal-to-noise ratio (dB) of the channel (e.g. VARA).
+type snrReporter interface {
+	SNR() (float64, error)
+}
+
+// unusableChannels probes the channel of each HF connect string (see probeChannel), and returns the connect
+// strings with an unusable channel (see orderConnectStrs).
+//
+// The probes never take longer than config.SmartOrderBudget in total. Connect strings that could not
+// be probed within the budget are considered usable.
+func unusableChannels(ordered []string) map[string]bool {
+	budget := defaultSmartOrderBudget
+	if config.SmartOrderBudget > 0 {
+		budget = time.Duration(config.SmartOrderBudget) * time.Second
+	}
+	deadline := time.Now().Add(budget)
+
+	var nHF int
+	urls := make([]*transport.URL, len(ordered))
+	for i, str := range ordered {
+		url, err := ResolveConnectURL(str)
+		if err != nil || !isHFTransport(url.Scheme) {
+			continue
+		}
+		urls[i] = url
+		nHF++
+	}
+
+	unusable := make(map[string]bool)
+	for i, str := range ordered {
+		if urls[i] == nil {
+			continue
+		}
+
+		// Split the remaining budget between the remaining HF connect strings
+		remaining := time.Until(deadline) / time.Duration(nHF)
+		nHF--
+		if remaining <= 0 {
+			continue
+		}
+
+		if reason, ok := probeChannel(urls[i], remaining); !ok {
+			log.Printf("Channel of %s looks unusable (%s), trying it last.", str, reason)
+			unusable[str] = true
+		}
+	}
+	return unusable
+}
+
+func isHFTransport(scheme string) bool {
+	switch scheme {
+	case MethodArdop, MethodWinmor, MethodVaraHF, MethodPactor:
+		return true
+	default:
+		return false
+	}
+}
+
+// probeChannel samples the channel of the given URL's TNC for at most the given duration (see probeTNC).
+//
+// The channel is only probed if the TNC is already initialized and not in use by a session, and the rig is
+// already on the URL's frequency (or the URL has none). It is considered usable if it can't be probed.
+func probeChannel(url *transport.URL, timeout time.Duration) (reason string, ok bool) {
+	tnc := openTNC(url)
+	if tnc == nil || !onFrequency(url) {
+		return "", true
+	}
+	release, err := tncGuard.tryAcquire(url)
+	if err != nil {
+		return "", true
+	}
+	defer release()
+	return probeTNC(tnc, timeout, realClock{})
+}
+
+// probeTNC samples the channel using the TNC's busy detector and SNR for at most the given duration.
+//
+// The channel is considered unusable (ok is false) if it is busy throughout the probe, or if the TNC
+// reports an SNR below config.SmartOrderMinSNR.
+func probeTNC(tnc interface{}, timeout time.Duration, clk clock) (reason string, ok bool) {
+	if r, ok := tnc.(snrReporter); ok && config.SmartOrderMinSNR != 0 {
+		if snr, err := r.SNR(); err == nil && snr < float64(config.SmartOrderMinSNR) {
+			return "SNR " + strconv.FormatFloat(snr, 'f', 1, 64) + " dB", false
+		}
+	}
+	b, ok := tnc.(transport.BusyChannelChecker)
+	if !ok {
+		return "", true
+	}
+	deadline := clk.Now().Add(timeout)
+	for {
+		if !b.Busy() {
+			return "", true
+		}
+		if !clk.Now().Add(smartOrderPollInterval).Before(deadline) {
+			return "busy", false
+		}
+		<-clk.After(smartOrderPollInterval)
+	}
+}
+
+// openTNC returns the TNC used by the given HF URL if it is already initialized, without initializing it.
+func openTNC(url *transport.URL) interface{} {
+	if url.Scheme == MethodArdop {
+		if tnc, ok := ardopTNCForURL(url); ok {
+			return tnc
+		}
+		return nil
+	}
+
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	switch {
+	case url.Scheme == MethodWinmor && wmTNC != nil:
+		return wmTNC
+	case url.Scheme == MethodVaraHF && varaHFTNC != nil:
+		return varaHFTNC
+	case url.Scheme == MethodPactor && pModem != nil:
+		return pModem
+	}
+	return nil
+}
+
+// onFrequency returns true if the URL has no freq parameter, or if the rig of the URL's transport is
+// already tuned to it.
+func onFrequency(url *transport.URL) bool {
+	str := url.Params.Get("freq")
+	if str == "" {
+		return true
+	}
+	want, err := ParseFrequency(str)
+	if err != nil {
+		return false // Channel reference
+	}
+	vfo, ok := vfoForURL(url)
+	if !ok {
+		return false
+	}
+	f, err := vfo.GetFreq()
+	if err != nil {
+		return false
+	}
+	return math.Abs(float64(f)-float64(want)) < 100
+}
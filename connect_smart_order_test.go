@@ -0,0 +1,57 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// snrTNC is a TNC reporting the given SNR (or error) and busy readings.
+type snrTNC struct {
+	busyReadings
+	snr float64
+	err error
+}
+
+func (t *snrTNC) SNR() (float64, error) { return t.snr, t.err }
+
+func TestProbeTNC(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{SmartOrderMinSNR: -5}
+
+	tests := []struct {
+		tnc        interface{}
+		timeout    time.Duration
+		wantReason string
+		wantOK     bool
+		wantWaits  int
+	}{
+		{struct{}{}, time.Second, "", true, 0},
+		{&busyReadings{false}, time.Second, "", true, 0},
+		{&busyReadings{true, true, false}, time.Second, "", true, 2},
+		{&busyReadings{true}, time.Second, "busy", false, 3},
+		{&busyReadings{true}, 0, "busy", false, 0},
+		{&snrTNC{busyReadings{false}, -12, nil}, time.Second, "SNR -12.0 dB", false, 0},
+		{&snrTNC{busyReadings{false}, 3, nil}, time.Second, "", true, 0},
+		{&snrTNC{busyReadings{false}, -12, errors.New("No SNR reported")}, time.Second, "", true, 0},
+	}
+	for i, tt := range tests {
+		clk := &fakeClock{now: time.Unix(0, 0)}
+		reason, ok := probeTNC(tt.tnc, tt.timeout, clk)
+		if reason != tt.wantReason || ok != tt.wantOK {
+			t.Errorf("%d: got %q, %t, want %q, %t", i, reason, ok, tt.wantReason, tt.wantOK)
+		}
+		if len(clk.waits) != tt.wantWaits {
+			t.Errorf("%d: got %d waits, want %d", i, len(clk.waits), tt.wantWaits)
+		}
+		if elapsed := clk.now.Sub(time.Unix(0, 0)); elapsed > tt.timeout {
+			t.Errorf("%d: probe took %s, longer than the timeout %s", i, elapsed, tt.timeout)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// The default maximum duration of each host attempt in dialTelnetFailover.
+const defaultTelnetHostTimeout = 10 * time.Second
+
+// failoverConn is a connection established by dialTelnetFailover.
+type failoverConn struct {
+	net.Conn
+	host string // The address that was dialed.
+}
+
+func (c *failoverConn) Banner() string { return remoteBanner(c.Conn) }
+
+// telnetAddrs returns the addresses to try in turn when dialing the given telnet URL.
+//
+// The URL's host may be a comma-separated list of hosts. Hosts without a port use the port of the
+// last host. For plain telnet, each host is resolved to all of its addresses (A and AAAA records).
+// Secure telnet dials the hostnames as-is, as the server certificate is verified against the hostname.
+//
+// Nil is returned for other transports.
+func telnetAddrs(ctx context.Context, url *transport.URL) []string {
+	if url.Scheme != MethodTelnet && url.Scheme != MethodTelnets {
+		return nil
+	}
+
+	hosts := strings.Split(url.Host, ",")
+	_, defaultPort, _ := net.SplitHostPort(strings.TrimSpace(hosts[len(hosts)-1]))
+
+	var addrs []string
+	for _, str := range hosts {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(str)
+		if err != nil {
+			host, port = str, defaultPort
+		}
+
+		ips := []string{host}
+		if url.Scheme == MethodTelnet {
+			if resolved, err := net.DefaultResolver.LookupHost(ctx, host); err == nil {
+				ips = resolved
+			}
+		}
+		for _, ip := range ips {
+			if port == "" {
+				addrs = append(addrs, ip)
+			} else {
+				addrs = append(addrs, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+	return addrs
+}
+
+// dialTelnetFailover dials each of the given addresses in turn (see telnetAddrs) with a short
+// timeout, until one succeeds.
+//
+// The error returned when all addresses failed is not transient, so the dial is not retried as a
+// whole (see connectRetries).
+func dialTelnetFailover(ctx context.Context, url *transport.URL, addrs []string) (net.Conn, error) {
+	timeout := defaultTelnetHostTimeout
+	if config.Telnet.HostTimeout > 0 {
+		timeout = time.Duration(config.Telnet.HostTimeout) * time.Second
+	}
+
+	var errs []string
+	for i, addr := range addrs {
+		u := *url
+		u.Host = addr
+
+		log.Printf("Trying %s (host %d of %d)...", addr, i+1, len(addrs))
+		hostCtx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err := dialURLContext(hostCtx, &u)
+		cancel()
+
+		switch {
+		case err == nil:
+			log.Printf("Connected to %s", addr)
+			return &failoverConn{conn, addr}, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err == context.DeadlineExceeded:
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		log.Printf("Unable to connect to %s: %s", addr, err)
+		errs = append(errs, fmt.Sprintf("%s: %s", addr, err))
+	}
+	return nil, fmt.Errorf("All hosts failed (%s)", strings.Join(errs, "; "))
+}
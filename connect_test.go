@@ -0,0 +1,289 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/rigcontrol/hamlib"
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestParallelGroups(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Ardop.Addr = "localhost:8515"
+	config.Ardop.Rig = "ft991"
+	config.VaraHF.Rig = "ft991"
+	config.Winmor.Rig = "ic7300"
+
+	tests := []struct {
+		in   []string
+		want [][]string
+	}{
+		{
+			[]string{"telnet:///LA1B", "ardop:///LA2B"},
+			[][]string{{"telnet:///LA1B"}, {"ardop:///LA2B"}},
+		},
+		{ // Same TNC
+			[]string{"ardop:///LA1B?freq=7064", "winmor:///LA3C", "ardop:///LA2B?freq=3590"},
+			[][]string{{"ardop:///LA1B?freq=7064", "ardop:///LA2B?freq=3590"}, {"winmor:///LA3C"}},
+		},
+		{ // Same rig, different TNCs
+			[]string{"winmor:///LA3C", "ardop:///LA1B", "varahf:///LA2B"},
+			[][]string{{"winmor:///LA3C"}, {"ardop:///LA1B", "varahf:///LA2B"}},
+		},
+		{ // Unresolvable
+			[]string{"", "telnet:///LA1B"},
+			[][]string{{""}, {"telnet:///LA1B"}},
+		},
+	}
+	for _, tt := range tests {
+		if got := parallelGroups(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parallelGroups(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveAliasCycle(t *testing.T) {
+	deep := make(map[string]string)
+	for i := 0; i < maxAliasDepth+1; i++ {
+		deep[string(rune('a'+i))] = string(rune('a' + i + 1))
+	}
+
+	tests := []struct {
+		aliases map[string]string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{map[string]string{"a": "b", "b": "telnet:///LA1B"}, "a", "telnet:///LA1B", ""},
+		{map[string]string{"a": "a"}, "a", "", "alias cycle detected: a -> a"},
+		{map[string]string{"a": "b", "b": "c", "c": "a"}, "a", "", "alias cycle detected: a -> b -> c -> a"},
+		{map[string]string{"a": "b", "b": "c", "c": "b"}, "a", "", "alias cycle detected: a -> b -> c -> b"},
+		{map[string]string{"a": "b?freq=7064", "b": "a"}, "a", "", "alias cycle detected: a -> b -> a"},
+		{deep, "a", "", "alias expansion too deep"},
+	}
+	for _, tt := range tests {
+		got, err := resolveAlias(tt.aliases, tt.in)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("resolveAlias(%v, %q): unexpected error: %s", tt.aliases, tt.in, err)
+		case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+			t.Errorf("resolveAlias(%v, %q): got error %v, want %q", tt.aliases, tt.in, err, tt.wantErr)
+		case got != tt.want:
+			t.Errorf("resolveAlias(%v, %q) = %q, want %q", tt.aliases, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Winmor.ConnectTimeout = 120
+	config.Telnet.ConnectTimeout = 30
+
+	tests := []struct {
+		url     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"winmor:///LA1B", 2 * time.Minute, false},
+		{"telnet://cms.winlink.org:8772/wl2k", 30 * time.Second, false},
+		{"ardop:///LA1B", 0, false},
+		{"winmor:///LA1B?dial_timeout=45s", 45 * time.Second, false},
+		{"ardop:///LA1B?dial_timeout=1m30s", 90 * time.Second, false},
+		{"winmor:///LA1B?dial_timeout=45", 0, true},
+		{"winmor:///LA1B?dial_timeout=0s", 0, true},
+		{"winmor:///LA1B?dial_timeout=-5s", 0, true},
+	}
+	for _, tt := range tests {
+		url, err := transport.ParseURL(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := connectTimeout(url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("connectTimeout(%s): unexpected error: %v", tt.url, err)
+		} else if got != tt.want {
+			t.Errorf("connectTimeout(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestResolveAliasParams(t *testing.T) {
+	aliases := map[string]string{
+		"LA1B":      "ardop:///LA1B?freq=7064&bw=500MAX",
+		"LA1B-80m":  "LA1B?freq=3590",
+		"LA1B?rob":  "ardop:///LA1B?robust=true",
+		"telnet-la": "telnet:///LA1B",
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"LA1B", "ardop:///LA1B?freq=7064&bw=500MAX"},
+		{"LA1B?freq=7068", "ardop:///LA1B?bw=500MAX&freq=7068"},
+		{"LA1B?freq=7068&cwid=false", "ardop:///LA1B?bw=500MAX&cwid=false&freq=7068"},
+		{"LA1B-80m", "ardop:///LA1B?bw=500MAX&freq=3590"},
+		{"LA1B-80m?bw=2000MAX", "ardop:///LA1B?bw=2000MAX&freq=3590"},
+		{"LA1B?rob", "ardop:///LA1B?robust=true"}, // The full connect string matches an alias
+		{"telnet-la?retries=2", "telnet:///LA1B?retries=2"},
+		{"ardop:///LA2B?freq=7064", "ardop:///LA2B?freq=7064"},
+	}
+	for _, tt := range tests {
+		got, err := resolveAlias(aliases, tt.in)
+		if err != nil {
+			t.Errorf("resolveAlias(%q): unexpected error: %s", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("resolveAlias(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := mergeParams("ardop:///LA1B", "freq=%zz"); err == nil {
+		t.Error("mergeParams: expected error for invalid parameters")
+	}
+}
+
+// fakeClock is a clock where time only passes when waited for.
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// busyReadings is a BusyChannelChecker returning the given readings in turn, repeating the last one.
+type busyReadings []bool
+
+func (r *busyReadings) Busy() bool {
+	busy := (*r)[0]
+	if len(*r) > 1 {
+		*r = (*r)[1:]
+	}
+	return busy
+}
+
+func TestWaitBusy(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	defer func(l *EventLogger) { eventLog = l }(eventLog)
+	eventLog = &EventLogger{}
+	defer func(c clock) { busyPoll.Clock = c }(busyPoll.Clock)
+
+	const ms = time.Millisecond
+	tests := []struct {
+		readings    busyReadings
+		ignoreBusy  bool
+		timeout     time.Duration
+		clearWindow time.Duration
+		wantWaits   []time.Duration
+		wantErr     error
+	}{
+		{
+			readings: busyReadings{false},
+		},
+		{ // Backs off up to the max interval
+			readings:  busyReadings{true, true, true, true, true, false},
+			wantWaits: []time.Duration{300 * ms, 600 * ms, 1200 * ms, 2000 * ms, 2000 * ms},
+		},
+		{ // Resets the interval when the channel clears
+			readings:    busyReadings{true, true, false, true, true, false},
+			clearWindow: time.Second,
+			wantWaits:   []time.Duration{300 * ms, 600 * ms, 300 * ms, 300 * ms, 600 * ms, 300 * ms, 300 * ms, 300 * ms, 300 * ms},
+		},
+		{
+			readings:   busyReadings{true},
+			ignoreBusy: true,
+		},
+		{
+			readings:  busyReadings{true},
+			timeout:   time.Second,
+			wantWaits: []time.Duration{300 * ms, 600 * ms, 1200 * ms},
+			wantErr:   busyTimeoutError{time.Second},
+		},
+	}
+	url, _ := transport.ParseURL("ardop:///LA1B")
+	for i, tt := range tests {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		busyPoll.Clock = clock
+		err := waitBusy(context.Background(), url, &tt.readings, tt.ignoreBusy, tt.timeout, tt.clearWindow)
+		if err != tt.wantErr {
+			t.Errorf("%d: got error %v, want %v", i, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(clock.waits, tt.wantWaits) {
+			t.Errorf("%d: got waits %v, want %v", i, clock.waits, tt.wantWaits)
+		}
+	}
+}
+
+// fakeVFO is a rig VFO keeping the frequency and PTT state in memory.
+type fakeVFO struct {
+	freq int
+	ptt  bool
+}
+
+func (v *fakeVFO) GetFreq() (int, error) { return v.freq, nil }
+func (v *fakeVFO) SetFreq(f int) error   { v.freq = f; return nil }
+func (v *fakeVFO) GetPTT() (bool, error) { return v.ptt, nil }
+func (v *fakeVFO) SetPTT(on bool) error  { v.ptt = on; return nil }
+
+func TestPTTRig(t *testing.T) {
+	defer func(r map[string]hamlib.VFO) { rigs = r }(rigs)
+	ft991 := &fakeVFO{}
+	rigs = map[string]hamlib.VFO{"ft991": ft991}
+
+	tests := []struct {
+		enabled bool
+		rig     string
+		want    transport.PTTController
+		wantErr bool
+	}{
+		{true, "ft991", guardedPTT{ft991, "ft991", MethodArdop}, false},
+		{false, "ft991", nil, false},
+		{true, "ic7300", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := pttRig(MethodArdop, tt.enabled, tt.rig)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pttRig(%t, %q): unexpected error: %v", tt.enabled, tt.rig, err)
+		} else if got != tt.want {
+			t.Errorf("pttRig(%t, %q) = %v, want %v", tt.enabled, tt.rig, got, tt.want)
+		}
+	}
+
+	// The ardop PTT keys the configured rig, unless it's in use by another transport
+	ptt, _ := pttRig(MethodArdop, true, "ft991")
+	if err := ptt.SetPTT(true); err != nil || !ft991.ptt {
+		t.Errorf("SetPTT(true): got PTT %t, error %v", ft991.ptt, err)
+	}
+	ptt.SetPTT(false)
+
+	tncGuard.mu.Lock()
+	tncGuard.held[rigKeyPrefix+"ft991"] = sessionOwner{sessionOutbound, MethodVaraHF}
+	tncGuard.mu.Unlock()
+	defer func() {
+		tncGuard.mu.Lock()
+		delete(tncGuard.held, rigKeyPrefix+"ft991")
+		tncGuard.mu.Unlock()
+	}()
+	if err := ptt.SetPTT(true); err == nil || ft991.ptt {
+		t.Errorf("SetPTT(true) while in use by varahf: got PTT %t, error %v", ft991.ptt, err)
+	}
+}
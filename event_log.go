@@ -5,30 +5,100 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"log"
 	"net"
 	"os"
+	"sync"
 	"time"
 )
 
+// EventLogger writes events to the event log file and any additional sinks (see EventSink).
 type EventLogger struct {
-	file *os.File
-	enc  *json.Encoder
+	mu    sync.Mutex
+	file  *fileSink // The event log file, written synchronously.
+	sinks []*asyncSink
+
+	jsonSink io.WriteCloser // Optional sink for connect records (see LogConnect).
+	jsonEnc  *json.Encoder
 }
 
+// NewEventLogger returns an EventLogger writing to the event log file at path.
 func NewEventLogger(path string) (*EventLogger, error) {
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
-	return &EventLogger{file, json.NewEncoder(file)}, err
+	file, err := newFileSink(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLogger{file: file}, nil
 }
 
-func (l *EventLogger) Close() error { return l.file.Close() }
+// AddSink adds a sink receiving all subsequent events.
+//
+// Events are written to each sink by a separate goroutine, so that a slow or failing sink does not
+// block the others (or the caller). Unlike the event log file, events may be dropped.
+func (l *EventLogger) AddSink(name string, sink EventSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = append(l.sinks, newAsyncSink(name, sink))
+}
+
+// SetJSONSink sets the path of the file where connect records are written, one JSON object per line (see LogConnect).
+//
+// The path "-" means stdout.
+func (l *EventLogger) SetJSONSink(path string) error {
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	l.jsonSink, l.jsonEnc = w, json.NewEncoder(w)
+	return nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// Close flushes and closes all sinks.
+func (l *EventLogger) Close() error {
+	if l.jsonSink != nil {
+		l.jsonSink.Close()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var firstErr error
+	if l.file != nil {
+		firstErr = l.file.Close()
+		l.file = nil
+	}
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.sinks = nil
+	return firstErr
+}
 
 func (l *EventLogger) Log(what string, event map[string]interface{}) {
 	event["log_time"] = time.Now()
 	event["what"] = what
 
-	if err := l.enc.Encode(event); err != nil {
-		panic(err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		if err := l.file.Write(Event(event)); err != nil {
+			log.Printf("Unable to write event log: %s", err)
+		}
+	}
+	for _, s := range l.sinks {
+		s.write(Event(event))
 	}
 }
 
@@ -37,6 +107,12 @@ func (l *EventLogger) LogConn(op string, freq Frequency, conn net.Conn, err erro
 
 	if err != nil {
 		e["error"] = err.Error()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			e["timeout"] = true // Distinguishes "no answer" from other failures
+		}
+		if err == context.Canceled {
+			e["aborted"] = true
+		}
 	} else {
 		if remote := conn.RemoteAddr(); remote != nil {
 			e["remote_addr"] = remote.String()
@@ -45,6 +121,10 @@ func (l *EventLogger) LogConn(op string, freq Frequency, conn net.Conn, err erro
 		if local := conn.LocalAddr(); local != nil {
 			e["local_addr"] = local.String()
 		}
+		e["banner"] = remoteBanner(conn)
+		if conn, ok := conn.(*failoverConn); ok {
+			e["host"] = conn.host
+		}
 	}
 
 	if freq > 0 {
@@ -55,3 +135,96 @@ func (l *EventLogger) LogConn(op string, freq Frequency, conn net.Conn, err erro
 
 	l.Log("connect", e)
 }
+
+// remoteBanner returns the banner (e.g. software and version) sent by the remote when the
+// connection was established, or an empty string if the transport doesn't provide one.
+func remoteBanner(conn net.Conn) string {
+	if conn, ok := conn.(interface{ Banner() string }); ok {
+		return conn.Banner()
+	}
+	return ""
+}
+
+// connectRecord is the structured record of a connect, written by LogConnect.
+type connectRecord struct {
+	Time       time.Time `json:"time"`
+	ConnectStr string    `json:"connect_str"`
+	Scheme     string    `json:"scheme,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Freq       Frequency `json:"freq,omitempty"` // kHz.
+	Success    bool      `json:"success"`
+	Duration   float64   `json:"duration"` // Seconds from the connection was established until disconnect.
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	Via        []string  `json:"via,omitempty"`    // Digipeater path of packet connects.
+	Faults     []string  `json:"faults,omitempty"` // Faults reported by the TNC during the connect.
+	Error      string    `json:"error,omitempty"`
+}
+
+// LogConnect records the outcome of a connect (dial and exchange), and writes it to the JSON sink (if any).
+func (l *EventLogger) LogConnect(connectStr string, res ConnectResult) {
+	rec := connectRecord{
+		Time:       time.Now(),
+		ConnectStr: connectStr,
+		Scheme:     res.Scheme,
+		Target:     res.Target,
+		Freq:       res.Frequency,
+		Success:    res.Success,
+		Duration:   res.Duration.Seconds(),
+		BytesIn:    res.BytesIn,
+		BytesOut:   res.BytesOut,
+	}
+	if res.URL != nil {
+		rec.Via = res.URL.Digis
+	}
+	rec.Faults = res.Faults
+	if res.Err != nil {
+		rec.Error = res.Err.Error()
+	}
+
+	e := map[string]interface{}{
+		"connect_str": rec.ConnectStr,
+		"scheme":      rec.Scheme,
+		"target":      rec.Target,
+		"success":     rec.Success,
+		"duration":    rec.Duration,
+		"bytes_in":    rec.BytesIn,
+		"bytes_out":   rec.BytesOut,
+	}
+	if rec.Freq > 0 {
+		e["freq"] = rec.Freq
+	}
+	if len(rec.Via) > 0 {
+		e["via"] = rec.Via
+	}
+	if len(rec.Faults) > 0 {
+		e["faults"] = rec.Faults
+	}
+	if rec.Error != "" {
+		e["error"] = rec.Error
+	}
+	l.Log("connect_result", e)
+
+	if l.jsonEnc != nil {
+		l.jsonEnc.Encode(rec)
+	}
+}
+
+// LogBusy records a busy channel state change for the given transport.
+//
+// The duration is how long the channel was in the previous state, if known.
+func (l *EventLogger) LogBusy(method string, freq Frequency, busy bool, prev time.Duration) {
+	e := map[string]interface{}{
+		"busy":      busy,
+		"transport": method,
+	}
+
+	if freq > 0 {
+		e["freq"] = freq
+	}
+	if prev > 0 {
+		e["duration"] = prev.Seconds()
+	}
+
+	l.Log("busy_channel", e)
+}
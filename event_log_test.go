@@ -0,0 +1,47 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEventLoggerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "eventlog.json")
+
+	l, err := NewEventLogger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Log("test", map[string]interface{}{"n": 1})
+
+	// The event log file is written before Log returns
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Got %d lines, want 1", len(lines))
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["what"] != "test" || e["n"] != 1.0 {
+		t.Errorf("Got event %v", e)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// +build !windows,!plan9
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// The default syslog tag of event log records.
+const defaultSyslogTag = "pat"
+
+// syslogSink writes events to the local syslog daemon, as JSON objects.
+type syslogSink struct{ w *syslog.Writer }
+
+func newSyslogSink(tag string) (EventSink, error) {
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogSink{w}, nil
+}
+
+func (s syslogSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(b))
+}
+
+func (s syslogSink) Close() error { return s.w.Close() }
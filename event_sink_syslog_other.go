@@ -0,0 +1,13 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// +build windows plan9
+
+package main
+
+import "fmt"
+
+func newSyslogSink(tag string) (EventSink, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// Event is an event log record.
+type Event map[string]interface{}
+
+// EventSink is a destination of event log records (see EventLogger.AddSink).
+type EventSink interface {
+	Write(Event) error
+	Close() error
+}
+
+// newEventSink returns the event sink described by the given config.
+func newEventSink(c cfg.EventSinkConfig) (EventSink, error) {
+	var sink EventSink
+	var err error
+	switch c.Type {
+	case "file":
+		if c.Path == "" {
+			return nil, fmt.Errorf("missing path")
+		}
+		sink, err = newFileSink(c.Path, int64(c.MaxSize)*1024, c.MaxBackups)
+	case "syslog":
+		sink, err = newSyslogSink(c.Tag)
+	case "webhook":
+		if c.URL == "" {
+			return nil, fmt.Errorf("missing url")
+		}
+		sink = &webhookSink{url: c.URL, client: &http.Client{Timeout: webhookTimeout}}
+	default:
+		return nil, fmt.Errorf("unknown sink type '%s'", c.Type)
+	}
+	if err != nil || len(c.Events) == 0 {
+		return sink, err
+	}
+	return filteredSink{sink, c.Events}, nil
+}
+
+// The maximum number of events queued for a sink. Events are dropped while the queue is full.
+const sinkQueueSize = 256
+
+// asyncSink writes events to a sink from a separate goroutine.
+type asyncSink struct {
+	name  string
+	sink  EventSink
+	queue chan Event
+	done  chan struct{}
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func newAsyncSink(name string, sink EventSink) *asyncSink {
+	s := &asyncSink{
+		name:  name,
+		sink:  sink,
+		queue: make(chan Event, sinkQueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *asyncSink) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		if err := s.sink.Write(e); err != nil {
+			log.Printf("Event log sink %s failed: %s", s.name, err)
+		}
+	}
+}
+
+// write queues the event, dropping it if the queue is full.
+func (s *asyncSink) write(e Event) {
+	select {
+	case s.queue <- e:
+	default:
+		s.mu.Lock()
+		if s.dropped++; s.dropped == 1 {
+			log.Printf("Event log sink %s is not keeping up, dropping events.", s.name)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close waits for the queued events to be written, and closes the sink.
+func (s *asyncSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return s.sink.Close()
+}
+
+// filteredSink writes only the given events (by "what") to the sink.
+type filteredSink struct {
+	EventSink
+	events []string
+}
+
+func (s filteredSink) Write(e Event) error {
+	for _, what := range s.events {
+		if e["what"] == what {
+			return s.EventSink.Write(e)
+		}
+	}
+	return nil
+}
+
+// fileSink appends events to a file, one JSON object per line.
+//
+// If maxSize is positive, the file is rotated when it would grow beyond maxSize bytes, keeping
+// maxBackups old files (path.1 being the most recent).
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	return s, s.open()
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, stat.Size()
+	return nil
+}
+
+func (s *fileSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("unable to rotate %s: %s", s.path, err)
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		os.Rename(s.path, s.path+".1")
+	} else {
+		os.Remove(s.path)
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error { return s.file.Close() }
+
+// The maximum duration of each webhook request.
+const webhookTimeout = 10 * time.Second
+
+// webhookSink POSTs each event as a JSON object to an HTTP endpoint.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error { return nil }
@@ -0,0 +1,84 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	tests := []struct {
+		maxSize    int64
+		maxBackups int
+		want       map[string]string // Content by file name.
+	}{
+		{0, 0, map[string]string{"log": "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n{\"n\":4}\n{\"n\":5}\n"}},
+		{20, 0, map[string]string{"log": "{\"n\":5}\n"}},
+		{20, 2, map[string]string{
+			"log":   "{\"n\":5}\n",
+			"log.1": "{\"n\":3}\n{\"n\":4}\n",
+			"log.2": "{\"n\":1}\n{\"n\":2}\n",
+		}},
+		{16, 1, map[string]string{
+			"log":   "{\"n\":5}\n",
+			"log.1": "{\"n\":3}\n{\"n\":4}\n",
+		}},
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "pat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "log")
+
+		// The size of the existing file counts when reopened
+		s, err := newFileSink(path, tt.maxSize, tt.maxBackups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 1; n <= 5; n++ {
+			if n == 4 {
+				s.Close()
+				if s, err = newFileSink(path, tt.maxSize, tt.maxBackups); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Write(Event{"n": n}); err != nil {
+				t.Fatalf("%d: Write: unexpected error: %s", i, err)
+			}
+		}
+		s.Close()
+
+		files, _ := filepath.Glob(path + "*")
+		if len(files) != len(tt.want) {
+			t.Errorf("%d: got files %q, want %d", i, files, len(tt.want))
+		}
+		for name, want := range tt.want {
+			if got, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+				t.Errorf("%d: %s: got %q, %v, want %q", i, name, got, err, want)
+			}
+		}
+	}
+}
+
+func TestFilteredSink(t *testing.T) {
+	var got []interface{}
+	s := filteredSink{&funcSink{func(e Event) { got = append(got, e["what"]) }}, []string{"connect", "busy"}}
+	for _, what := range []string{"connect", "qsy", "busy", "connect_result"} {
+		s.Write(Event{"what": what})
+	}
+	if len(got) != 2 || got[0] != "connect" || got[1] != "busy" {
+		t.Errorf("Got events %v, want [connect busy]", got)
+	}
+}
+
+type funcSink struct{ write func(Event) }
+
+func (s *funcSink) Write(e Event) error { s.write(e); return nil }
+func (s *funcSink) Close() error        { return nil }
@@ -10,24 +10,64 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/transport"
+	"github.com/la5nta/wl2k-go/transport/ardop"
 )
 
 type ex struct {
 	conn   net.Conn
 	target string
 	master bool
+	opts   sessionOptions
+	stats  *SessionSummary // Set before the error is sent.
 	errors chan error
 }
 
+// transferStats holds the number of message bytes transferred during an exchange.
+type transferStats struct {
+	Sent     int64
+	Received int64
+}
+
+// String returns a one-line summary of the session, e.g. "Received 3 message(s) (42 KB, 40 KB compressed, avg
+// 0.31 kB/s), sent 1 (2 KB, 1 KB compressed) in 2m15s.". The average rate is left out when it can not be computed
+// (zero duration or nothing received).
+func (s SessionSummary) String() string {
+	d := time.Duration(s.Duration * float64(time.Second)).Round(time.Second)
+	str := fmt.Sprintf("Received %d message(s)", s.MessagesReceived)
+	if s.MessagesReceived > 0 {
+		str += fmt.Sprintf(" (%.0f KB, %.0f KB compressed", float64(s.PayloadReceived)/1024, float64(s.BytesReceived)/1024)
+		if s.Duration > 0 && s.BytesReceived > 0 {
+			str += fmt.Sprintf(", avg %.2f kB/s", float64(s.BytesReceived)/1024/s.Duration)
+		}
+		str += ")"
+	}
+	str += fmt.Sprintf(", sent %d", s.MessagesSent)
+	if s.MessagesSent > 0 {
+		str += fmt.Sprintf(" (%.0f KB, %.0f KB compressed)", float64(s.PayloadSent)/1024, float64(s.BytesSent)/1024)
+	}
+	str += fmt.Sprintf(" in %s", d)
+	if s.MessagesSent == 0 && s.MessagesReceived == 0 {
+		str += " (no traffic)"
+	}
+	if s.SNR != nil {
+		str += fmt.Sprintf(", SNR %.0f/%.0f/%.0f dB (min/avg/last)", s.SNR.Min, s.SNR.Avg, s.SNR.Last)
+	}
+	return str + "."
+}
+
 func exchangeLoop() (ce chan ex) {
 	ce = make(chan ex)
 	go func() {
 		for ex := range ce {
-			ex.errors <- sessionExchange(ex.conn, ex.target, ex.master)
+			stats, err := sessionExchange(ex.conn, ex.target, ex.master, ex.opts)
+			*ex.stats = stats
+			ex.errors <- err
 			close(ex.errors)
 		}
 	}()
@@ -35,23 +75,65 @@ func exchangeLoop() (ce chan ex) {
 }
 
 func exchange(conn net.Conn, targetCall string, master bool) error {
+	_, err := exchangeWithStats(conn, targetCall, master, sessionOptions{})
+	return err
+}
+
+// sessionOptions are the per-connect options of an exchange.
+type sessionOptions struct {
+	Robust bool   // Force robust modes for the session (as with --robust).
+	P2P    *bool  // Force P2P (true) or CMS (false) message selection (see p2pMode). Nil leaves it to the mailbox.
+	Abort  func() // Forces an immediate disconnect (e.g. by aborting the TNC). Nil if not supported.
+}
+
+// exchangeWithStats is like exchange, but also returns a summary of the session.
+func exchangeWithStats(conn net.Conn, targetCall string, master bool, opts sessionOptions) (SessionSummary, error) {
 	e := ex{
 		conn:   conn,
 		target: targetCall,
 		master: master,
+		opts:   opts,
+		stats:  new(SessionSummary),
 		errors: make(chan error),
 	}
 	exchangeChan <- e
-	return <-e.errors
+	err := <-e.errors
+	return *e.stats, err
 }
 
-type NotifyMBox struct{ fbb.MBoxHandler }
+// NotifyMBox notifies the Web GUI of new messages, and keeps track of the size of the messages transferred.
+type NotifyMBox struct {
+	fbb.MBoxHandler
+
+	p2p    *bool  // Forced P2P (true) or CMS (false) message selection, if not nil (see filterOutbound).
+	target string // The remote station.
+
+	outbound map[string]int64 // Size of the outbound messages by MID.
+	received int64            // Total size of the messages received.
+}
 
-func (m NotifyMBox) ProcessInbound(msgs ...*fbb.Message) error {
+func (m *NotifyMBox) GetOutbound(fw ...fbb.Address) []*fbb.Message {
+	msgs := m.MBoxHandler.GetOutbound(fw...)
+	if m.p2p != nil {
+		msgs = filterOutbound(msgs, *m.p2p, m.target)
+	}
+	m.outbound = make(map[string]int64, len(msgs))
+	for _, msg := range msgs {
+		if b, err := msg.Bytes(); err == nil {
+			m.outbound[msg.MID()] = int64(len(b))
+		}
+	}
+	return msgs
+}
+
+func (m *NotifyMBox) ProcessInbound(msgs ...*fbb.Message) error {
 	if err := m.MBoxHandler.ProcessInbound(msgs...); err != nil {
 		return err
 	}
 	for _, msg := range msgs {
+		if b, err := msg.Bytes(); err == nil {
+			m.received += int64(len(b))
+		}
 		websocketHub.WriteJSON(struct{ Notification Notification }{
 			Notification{
 				Title: fmt.Sprintf("New message from %s", msg.From().Addr),
@@ -62,18 +144,19 @@ func (m NotifyMBox) ProcessInbound(msgs ...*fbb.Message) error {
 	return nil
 }
 
-func sessionExchange(conn net.Conn, targetCall string, master bool) error {
+func sessionExchange(conn net.Conn, targetCall string, master bool, opts sessionOptions) (SessionSummary, error) {
 	exchangeConn = conn
 	websocketHub.UpdateStatus()
 	defer func() { exchangeConn = nil; websocketHub.UpdateStatus() }()
 
 	// New wl2k Session
 	targetCall = strings.Split(targetCall, ` `)[0]
+	notifyMBox := &NotifyMBox{MBoxHandler: mbox, p2p: opts.P2P, target: targetCall}
 	session := fbb.NewSession(
 		fOptions.MyCall,
 		targetCall,
 		config.Locator,
-		NotifyMBox{mbox},
+		notifyMBox,
 	)
 
 	session.SetUserAgent(fbb.UserAgent{
@@ -101,21 +184,33 @@ func sessionExchange(conn net.Conn, targetCall string, master bool) error {
 	session.IsMaster(master)
 	session.SetLogger(log.New(logWriter, "", 0))
 
-	session.SetStatusUpdater(new(StatusUpdate))
+	progress := new(StatusUpdate)
+	session.SetStatusUpdater(progress)
 
-	if fOptions.Robust {
+	if fOptions.Robust || opts.Robust {
 		session.SetRobustMode(fbb.RobustForced)
 	}
 
 	log.Printf("Connected to %s (%s)", conn.RemoteAddr(), conn.RemoteAddr().Network())
+	if opts.P2P != nil {
+		log.Printf("Exchange mode: %s (forced)", exchangeMode(*opts.P2P))
+	}
 
 	// Close connection on os.Interrupt
-	stop := handleInterrupt()
-	defer close(stop)
+	defer handleExchangeInterrupt(conn, opts.Abort)()
 
 	startTs := time.Now()
+	link := monitorLink(conn)
 
-	stats, err := session.Exchange(conn)
+	exConn := conn
+	if fs := progressFuncs(); len(fs) > 0 {
+		var stopProgress func()
+		exConn, stopProgress = withProgress(conn, fs)
+		defer stopProgress()
+	}
+
+	stats, err := session.Exchange(exConn)
+	snr := link.Stop()
 	if fbb.IsLoginFailure(err) {
 		fmt.Println("NOTE: A new password scheme for Winlink is being implemented as of 2018-01-31.")
 		fmt.Println("      Users with passwords created/changed prior to January 31, 2018 should be")
@@ -129,6 +224,7 @@ func sessionExchange(conn net.Conn, targetCall string, master bool) error {
 		"targetcall":          session.Targetcall(),
 		"remote_fw":           session.RemoteForwarders(),
 		"remote_sid":          session.RemoteSID(),
+		"remote_banner":       remoteBanner(conn),
 		"master":              master,
 		"local_locator":       config.Locator,
 		"auxiliary_addresses": config.AuxAddrs,
@@ -144,10 +240,31 @@ func sessionExchange(conn net.Conn, targetCall string, master bool) error {
 	if err != nil {
 		event["error"] = err.Error()
 	}
+	if opts.P2P != nil {
+		event["mode"] = exchangeMode(*opts.P2P)
+	}
+
+	summary := SessionSummary{
+		MessagesSent:     len(stats.Sent),
+		MessagesReceived: len(stats.Received),
+		PayloadReceived:  notifyMBox.received,
+		BytesSent:        progress.stats.Sent,
+		BytesReceived:    progress.stats.Received,
+		Duration:         time.Since(startTs).Seconds(),
+		SNR:              snr,
+	}
+	for _, mid := range stats.Sent {
+		summary.PayloadSent += notifyMBox.outbound[mid]
+	}
+	if summary.Duration > 0 {
+		summary.BytesPerSecond = float64(summary.BytesSent+summary.BytesReceived) / summary.Duration
+	}
+	event["summary"] = summary
 
 	eventLog.Log("exchange", event)
+	websocketHub.WriteSessionSummary(summary)
 
-	return err
+	return summary, err
 }
 
 func handleInterrupt() (stop chan struct{}) {
@@ -158,8 +275,8 @@ func handleInterrupt() (stop chan struct{}) {
 		signal.Notify(sig, os.Interrupt)
 		defer func() { signal.Stop(sig); close(sig) }()
 
-		wmDisc := false // So we can DirtyDisconnect on second interrupt
-		adDisc := false // So we can Abort on second interrupt
+		wmDisc := false                 // So we can DirtyDisconnect on second interrupt
+		adDisc := map[*ardop.TNC]bool{} // So we can Abort on second interrupt
 		for {
 			select {
 			case <-stop:
@@ -194,21 +311,30 @@ func handleInterrupt() (stop chan struct{}) {
 						}()
 					}
 				}
-				if adTNC != nil && !adTNC.Idle() {
-					if adDisc {
+				if varaHFTNC != nil && !varaHFTNC.Idle() {
+					log.Println("Aborting VARA HF...")
+					varaHFTNC.Abort()
+				}
+				if varaFMTNC != nil && !varaFMTNC.Idle() {
+					log.Println("Aborting VARA FM...")
+					varaFMTNC.Abort()
+				}
+				for _, tnc := range allArdopTNCs() {
+					if tnc.Idle() {
+						continue
+					}
+					if adDisc[tnc] {
 						log.Println("Dirty disconnecting ardop...")
-						adTNC.Abort()
-						adDisc = false
+						tnc.Abort()
+						adDisc[tnc] = false
 					} else {
 						log.Println("Disconnecting ardop...")
-						adDisc = true
-						go func() {
-							if err := adTNC.Disconnect(); err != nil {
+						adDisc[tnc] = true
+						go func(tnc *ardop.TNC) {
+							if err := tnc.Disconnect(); err != nil {
 								log.Println(err)
-							} else {
-								adDisc = false
 							}
-						}()
+						}(tnc)
 					}
 				}
 			}
@@ -218,7 +344,96 @@ func handleInterrupt() (stop chan struct{}) {
 	return stop
 }
 
-type StatusUpdate int
+// cancelOnInterrupt calls cancel if an interrupt (signal) is received before stop is called.
+func cancelOnInterrupt(cancel func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		defer signal.Stop(sig)
+
+		select {
+		case <-done:
+		case s := <-sig:
+			log.Printf("Got %s, aborting...", s)
+			cancel()
+		}
+	}()
+	return func() { close(done) }
+}
+
+// handleExchangeInterrupt closes conn (disconnecting gracefully) on the first interrupt (signal) received
+// before stop is called. Any further interrupt forces an immediate disconnect by calling abort, if non-nil.
+func handleExchangeInterrupt(conn net.Conn, abort func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		defer signal.Stop(sig)
+
+		state := exchangeInterrupt{canForce: abort != nil}
+		for {
+			select {
+			case <-done:
+				return
+			case s := <-sig:
+				switch state.next() {
+				case interruptDisconnect:
+					if abort != nil {
+						log.Printf("Got %s, disconnecting (interrupt again to force)...", s)
+					} else {
+						log.Printf("Got %s, disconnecting...", s)
+					}
+					// Don't block the next interrupt, a graceful disconnect might take a while (e.g. flushing the TX buffer)
+					go conn.Close()
+				case interruptForce:
+					log.Printf("Got %s, forcing disconnect...", s)
+					abort()
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+type interruptAction int
+
+const (
+	interruptDisconnect interruptAction = iota // Disconnect gracefully.
+	interruptForce                             // Force an immediate disconnect.
+)
+
+// exchangeInterrupt keeps track of the interrupts received during an exchange.
+//
+// The first interrupt disconnects gracefully. The following interrupts force the disconnect if canForce is
+// true, or else repeat the graceful disconnect.
+type exchangeInterrupt struct {
+	canForce bool
+	n        int
+}
+
+// next returns the action of the next interrupt.
+func (e *exchangeInterrupt) next() interruptAction {
+	if e.n++; e.n > 1 && e.canForce {
+		return interruptForce
+	}
+	return interruptDisconnect
+}
+
+// The weight of the most recent sample in the transfer rate estimate.
+const rateSmoothing = 0.3
+
+// StatusUpdate reports the progress of the proposals transferred during an exchange, and keeps
+// track of the total number of message bytes transferred.
+type StatusUpdate struct {
+	stats transferStats
+
+	// Transfer rate estimate of the current proposal.
+	mid    string
+	lastTs time.Time
+	lastN  int
+	rate   float64 // Bytes per second.
+}
 
 func (s *StatusUpdate) UpdateStatus(stat fbb.Status) {
 	var prop fbb.Proposal
@@ -229,10 +444,20 @@ func (s *StatusUpdate) UpdateStatus(stat fbb.Status) {
 		prop = *stat.Sending
 	}
 
+	s.updateRate(prop.MID(), stat.BytesTransferred)
+	if stat.Done {
+		if stat.Receiving != nil {
+			s.stats.Received += int64(stat.BytesTransferred)
+		} else {
+			s.stats.Sent += int64(stat.BytesTransferred)
+		}
+	}
+
 	websocketHub.WriteProgress(Progress{
 		MID:              prop.MID(),
 		BytesTotal:       stat.BytesTotal,
 		BytesTransferred: stat.BytesTransferred,
+		BytesPerSecond:   s.rate,
 		Subject:          prop.Title(),
 		Receiving:        stat.Receiving != nil,
 		Sending:          stat.Sending != nil,
@@ -240,10 +465,87 @@ func (s *StatusUpdate) UpdateStatus(stat fbb.Status) {
 	})
 
 	percent := float64(stat.BytesTransferred) / float64(stat.BytesTotal) * 100
-	fmt.Printf("\r%s: %3.0f%%", prop.Title(), percent)
+	fmt.Printf("\r%s: %3.0f%% (%d/%d bytes", prop.Title(), percent, stat.BytesTransferred, stat.BytesTotal)
+	if s.rate > 0 {
+		fmt.Printf(", %.0f B/s", s.rate)
+	}
+	fmt.Print(")")
 
 	if stat.Done {
 		fmt.Println("")
 	}
 	os.Stdout.Sync()
 }
+
+// updateRate updates the transfer rate estimate with the number of bytes transferred n of the given proposal.
+//
+// The estimate is a moving average, so that it follows the recent throughput of the channel.
+func (s *StatusUpdate) updateRate(mid string, n int) {
+	now := time.Now()
+	if mid != s.mid {
+		// New proposal. Keep the previous estimate, as it's likely the same channel conditions.
+		s.mid, s.lastTs, s.lastN = mid, now, n
+		return
+	}
+
+	dt := now.Sub(s.lastTs).Seconds()
+	if dt <= 0 || n < s.lastN {
+		return
+	}
+	sample := float64(n-s.lastN) / dt
+	if s.rate == 0 {
+		s.rate = sample
+	} else {
+		s.rate = rateSmoothing*sample + (1-rateSmoothing)*s.rate
+	}
+	s.lastTs, s.lastN = now, n
+}
+
+// p2pMode returns the exchange mode given by the URL parameter ?p2p=: true forces P2P message selection, false forces
+// CMS message selection. Nil if not given.
+func p2pMode(url *transport.URL) (*bool, error) {
+	v := url.Params.Get("p2p")
+	if v == "" {
+		return nil, nil
+	}
+	p2p, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid p2p parameter: %s", err)
+	}
+	return &p2p, nil
+}
+
+func exchangeMode(p2p bool) string {
+	if p2p {
+		return "P2P"
+	}
+	return "CMS"
+}
+
+// filterOutbound holds back the outbound messages not meant for the remote station.
+//
+// In P2P mode, only messages addressed to the remote station are proposed, so that messages for CMS forwarding
+// are not dumped on a peer. In CMS mode, P2P only messages (X-P2POnly) are held back.
+func filterOutbound(msgs []*fbb.Message, p2p bool, target string) []*fbb.Message {
+	filtered := make([]*fbb.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if p2p && isAddressedTo(msg, target) || !p2p && msg.Header.Get("X-P2POnly") != "true" {
+			filtered = append(filtered, msg)
+		}
+	}
+	if n := len(msgs) - len(filtered); n > 0 {
+		log.Printf("%d outbound message(s) held back (%s mode)", n, exchangeMode(p2p))
+	}
+	return filtered
+}
+
+// isAddressedTo returns true if any of the message's receivers is the given station.
+func isAddressedTo(msg *fbb.Message, call string) bool {
+	call = strings.SplitN(call, "-", 2)[0] // Ignore SSID
+	for _, addr := range msg.Receivers() {
+		if strings.EqualFold(strings.SplitN(addr.Addr, "-", 2)[0], call) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExchangeInterrupt(t *testing.T) {
+	const (
+		disc  = interruptDisconnect
+		force = interruptForce
+	)
+	tests := []struct {
+		canForce bool
+		presses  int
+		want     []interruptAction
+	}{
+		{true, 1, []interruptAction{disc}},
+		{true, 2, []interruptAction{disc, force}},
+		{true, 4, []interruptAction{disc, force, force, force}},
+		{false, 1, []interruptAction{disc}},
+		{false, 3, []interruptAction{disc, disc, disc}},
+	}
+	for _, tt := range tests {
+		state := exchangeInterrupt{canForce: tt.canForce}
+		var got []interruptAction
+		for i := 0; i < tt.presses; i++ {
+			got = append(got, state.next())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("canForce=%t, %d presses: got %v, want %v", tt.canForce, tt.presses, got, tt.want)
+		}
+	}
+}
+
+func TestSessionSummaryString(t *testing.T) {
+	tests := []struct {
+		in   SessionSummary
+		want string
+	}{
+		{SessionSummary{Duration: 5}, "Received 0 message(s), sent 0 in 5s (no traffic)."},
+		{ // Telnet sessions can be too short for a rate
+			SessionSummary{MessagesSent: 1, PayloadSent: 2048, BytesSent: 1024},
+			"Received 0 message(s), sent 1 (2 KB, 1 KB compressed) in 0s.",
+		},
+		{
+			SessionSummary{MessagesReceived: 3, PayloadReceived: 43008, BytesReceived: 40960, MessagesSent: 1, PayloadSent: 2048, BytesSent: 1024, Duration: 135},
+			"Received 3 message(s) (42 KB, 40 KB compressed, avg 0.30 kB/s), sent 1 (2 KB, 1 KB compressed) in 2m15s.",
+		},
+		{
+			SessionSummary{MessagesReceived: 1, PayloadReceived: 1024, BytesReceived: 1024, Duration: 10, SNR: &LinkReading{Min: -3, Avg: 2.4, Last: 5}},
+			"Received 1 message(s) (1 KB, 1 KB compressed, avg 0.10 kB/s), sent 0 in 10s, SNR -3/2/5 dB (min/avg/last).",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -7,10 +7,12 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/la5nta/wl2k-go/rigcontrol/hamlib"
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 var bands = map[string]Band{
@@ -42,15 +44,52 @@ func (b Band) Contains(f Frequency) bool {
 
 type Frequency int // Hz
 
-func (f Frequency) String() string {
-	m := f / 1e6
-	k := (float64(f) - float64(m)*1e6) / 1e3
+// ParseFrequency parses a frequency with an optional unit suffix (Hz, kHz or MHz, case-insensitive),
+// e.g. "7.064MHz", "7064 kHz" or "7064000Hz".
+//
+// A bare number is kHz (e.g. "7064"), as in the freq URL parameter.
+func ParseFrequency(str string) (Frequency, error) {
+	s := strings.ToLower(strings.TrimSpace(str))
+	mult := 1e3
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{{"mhz", 1e6}, {"khz", 1e3}, {"hz", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
 
-	return fmt.Sprintf("%d.%06.2f MHz", m, k)
+	f, err := strconv.ParseFloat(s, 64)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("Invalid frequency '%s'", str)
+	case f <= 0:
+		return 0, fmt.Errorf("Invalid frequency '%s': must be positive", str)
+	}
+	return Frequency(math.Round(f * mult)), nil
+}
+
+// String formats the frequency as MHz (with kHz decimals), or as kHz/Hz below 1 MHz/1 kHz.
+func (f Frequency) String() string {
+	switch {
+	case f >= 1e6:
+		m := f / 1e6
+		k := (float64(f) - float64(m)*1e6) / 1e3
+		return fmt.Sprintf("%d.%06.2f MHz", m, k)
+	case f >= 1e3:
+		return fmt.Sprintf("%.2f kHz", f.KHz())
+	default:
+		return fmt.Sprintf("%d Hz", int(f))
+	}
 }
 
 func (f Frequency) KHz() float64 { return float64(f) / 1e3 }
 
+// MarshalJSON marshals the frequency as kHz with three decimals (as in the log).
+func (f Frequency) MarshalJSON() ([]byte, error) { return []byte(fmt.Sprintf("%.3f", f.KHz())), nil }
+
 func (f Frequency) Dial(mode string) Frequency {
 	mode = strings.ToLower(mode)
 
@@ -64,6 +103,7 @@ func (f Frequency) Dial(mode string) Frequency {
 		"winmor": 1500,
 		"pactor": 1500,
 		"ardop":  1500,
+		"vara":   1500,
 	}
 
 	var shift Frequency
@@ -78,14 +118,24 @@ func (f Frequency) Dial(mode string) Frequency {
 }
 
 func VFOForTransport(transport string) (vfo hamlib.VFO, ok bool) {
+	var rigName string
 	switch transport {
 	case MethodWinmor:
-		vfo, ok = rigs[config.Winmor.Rig]
+		rigName = config.Winmor.Rig
 	case MethodArdop:
-		vfo, ok = rigs[config.Ardop.Rig]
+		rigName = config.Ardop.Rig
+	case MethodVaraHF:
+		rigName = config.VaraHF.Rig
+	case MethodVaraFM:
+		rigName = config.VaraFM.Rig
 	case MethodAX25:
-		vfo, ok = rigs[config.AX25.Rig]
+		rigName = config.AX25.Rig
+	case MethodAGWPE:
+		rigName = config.AGWPE.Rig
+	case MethodKISSTCP:
+		rigName = config.KISSTCP.Rig
 	}
+	vfo, ok = rigs[rigName]
 	return
 }
 
@@ -110,23 +160,30 @@ func freq(param string) {
 		return
 	}
 
-	if _, _, err := setFreq(rig, parts[1]); err != nil {
+	if rigName, _, _ := qsyRig(&transport.URL{Scheme: parts[0]}); rigName != "" {
+		if err := tncGuard.checkRig(rigName, parts[0]); err != nil {
+			log.Printf("Unable to set frequency: %s", err)
+			return
+		}
+	}
+	if _, _, err := setFreq(rig, parts[1], 0); err != nil {
 		log.Printf("Unable to set frequency: %s", err)
 	}
 }
 
-func setFreq(rig hamlib.VFO, freq string) (newFreq, oldFreq int, err error) {
-	oldFreq, err = rig.GetFreq()
+// setFreq sets the rig's frequency to freq (see ParseFrequency) plus offsetHz, returning the new and previous frequency (Hz).
+func setFreq(rig hamlib.VFO, freq string, offsetHz int) (newFreq, oldFreq int, err error) {
+	f, err := ParseFrequency(freq)
 	if err != nil {
-		return 0, 0, fmt.Errorf("Unable to get rig frequency: %s", err)
+		return 0, 0, err
 	}
 
-	f, err := strconv.ParseFloat(freq, 64)
+	oldFreq, err = rig.GetFreq()
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("Unable to get rig frequency: %s", err)
 	}
 
-	newFreq = int(f * 1e3)
+	newFreq = int(f) + offsetHz
 	err = rig.SetFreq(newFreq)
 	return
 }
@@ -0,0 +1,85 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestParseFrequency(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Frequency
+		wantErr bool
+	}{
+		// Bare numbers are kHz, including the ones that look like MHz
+		{"7064", 7064000, false},
+		{"14105.5", 14105500, false},
+		{"7.064", 7064, false},
+		{"144", 144000, false},
+		{"0.5", 500, false},
+		{" 3590 ", 3590000, false},
+
+		{"7064kHz", 7064000, false},
+		{"7064 KHZ", 7064000, false},
+		{"7.064MHz", 7064000, false},
+		{"144.800 mhz", 144800000, false},
+		{"7064000Hz", 7064000, false},
+		{"7064000 hz", 7064000, false},
+		{"7.0641MHz", 7064100, false},
+		{"7064.0004", 7064000, false}, // Rounded to the nearest Hz
+
+		{"", 0, true},
+		{"MHz", 0, true},
+		{"abc", 0, true},
+		{"7,064", 0, true},
+		{"7064 GHz", 0, true},
+		{"7MHzz", 0, true},
+		{"0", 0, true},
+		{"-7064", 0, true},
+		{"0MHz", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFrequency(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFrequency(%q): unexpected error: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("ParseFrequency(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFrequencyString(t *testing.T) {
+	tests := []struct {
+		in   Frequency
+		want string
+	}{
+		{7064000, "7.064.00 MHz"},
+		{14105500, "14.105.50 MHz"},
+		{144800000, "144.800.00 MHz"},
+		{1000000, "1.000.00 MHz"},
+		{475500, "475.50 kHz"},
+		{1000, "1.00 kHz"},
+		{500, "500 Hz"},
+		{0, "0 Hz"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Frequency(%d).String() = %q, want %q", int(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestSetFreq(t *testing.T) {
+	rig := &fakeVFO{freq: 3590000}
+	newFreq, oldFreq, err := setFreq(rig, "7.064MHz", -1500)
+	if err != nil {
+		t.Fatalf("setFreq: unexpected error: %s", err)
+	}
+	if newFreq != 7062500 || oldFreq != 3590000 || rig.freq != 7062500 {
+		t.Errorf("setFreq: got new %d, old %d and rig %d", newFreq, oldFreq, rig.freq)
+	}
+	if _, _, err := setFreq(rig, "7064 GHz", 0); err == nil || rig.freq != 7062500 {
+		t.Errorf("setFreq: got rig %d, error %v", rig.freq, err)
+	}
+}
@@ -33,21 +33,46 @@ import (
 
 // Status represents a status report as sent to the Web GUI
 type Status struct {
-	ActiveListeners []string `json:"active_listeners"`
-	Connected       bool     `json:"connected"`
-	RemoteAddr      string   `json:"remote_addr"`
-	HTTPClients     []string `json:"http_clients"`
+	ActiveListeners []string          `json:"active_listeners"`
+	Connected       bool              `json:"connected"`
+	RemoteAddr      string            `json:"remote_addr"`
+	HTTPClients     []string          `json:"http_clients"`
+	BusyTNCs        []string          `json:"busy_tncs"`  // TNCs in use by an outbound session (connect attempts will fail).
+	RigOwners       map[string]string `json:"rig_owners"` // The transport using each rig in use by a session.
 }
 
 // Progress represents a progress report as sent to the Web GUI
 type Progress struct {
-	BytesTransferred int    `json:"bytes_transferred"`
-	BytesTotal       int    `json:"bytes_total"`
-	MID              string `json:"mid"`
-	Subject          string `json:"subject"`
-	Receiving        bool   `json:"receiving"`
-	Sending          bool   `json:"sending"`
-	Done             bool   `json:"done"`
+	BytesTransferred int     `json:"bytes_transferred"`
+	BytesTotal       int     `json:"bytes_total"`
+	BytesPerSecond   float64 `json:"bytes_per_second"` // Estimated transfer rate (recent throughput).
+	MID              string  `json:"mid"`
+	Subject          string  `json:"subject"`
+	Receiving        bool    `json:"receiving"`
+	Sending          bool    `json:"sending"`
+	Done             bool    `json:"done"`
+}
+
+// SessionSummary summarizes an exchange, as logged after the session and sent to the Web GUI
+type SessionSummary struct {
+	MessagesSent     int     `json:"messages_sent"`
+	MessagesReceived int     `json:"messages_received"`
+	PayloadSent      int64   `json:"payload_sent"`     // Uncompressed size of the messages sent.
+	PayloadReceived  int64   `json:"payload_received"` // Uncompressed size of the messages received.
+	BytesSent        int64   `json:"bytes_sent"`       // Compressed message bytes sent on the wire.
+	BytesReceived    int64   `json:"bytes_received"`   // Compressed message bytes received on the wire.
+	Duration         float64 `json:"duration"`         // Seconds.
+	BytesPerSecond   float64 `json:"bytes_per_second"` // Effective throughput (compressed bytes in both directions).
+
+	SNR *LinkReading `json:"snr,omitempty"` // SNR (dB) reported by the modem, if any.
+}
+
+// TransferProgress is the number of bytes transferred so far in an exchange, as sent periodically to the Web GUI
+type TransferProgress struct {
+	BytesSent      int64   `json:"bytes_sent"`
+	BytesReceived  int64   `json:"bytes_received"`
+	BytesPerSecond float64 `json:"bytes_per_second"` // Throughput since the previous report.
+	Elapsed        float64 `json:"elapsed"`          // Seconds since the exchange started.
 }
 
 // Notification represents a desktop notification as sent to the Web GUI
@@ -71,7 +96,11 @@ func ListenAndServe(addr string) error {
 
 	r := mux.NewRouter()
 	r.HandleFunc("/api/connect_aliases", connectAliasesHandler).Methods("GET")
+	r.HandleFunc("/api/connect/abort", abortConnectHandler).Methods("POST")
 	r.HandleFunc("/api/connect", ConnectHandler)
+	r.HandleFunc("/api/listen", listenHandler).Methods("GET")
+	r.HandleFunc("/api/listen", startListenHandler).Methods("POST")
+	r.HandleFunc("/api/listen/{method}", stopListenHandler).Methods("DELETE")
 	r.HandleFunc("/api/mailbox/{box}", mailboxHandler).Methods("GET")
 	r.HandleFunc("/api/mailbox/{box}/{mid}", messageHandler).Methods("GET")
 	r.HandleFunc("/api/mailbox/{box}/{mid}", messageDeleteHandler).Methods("DELETE")
@@ -90,6 +119,14 @@ func ListenAndServe(addr string) error {
 
 	websocketHub = NewWSHub()
 
+	// Forward inbound session events to the Web GUI
+	events, _ := listenHub.Subscribe()
+	go func() {
+		for e := range events {
+			websocketHub.WriteInboundEvent(e)
+		}
+	}()
+
 	return http.ListenAndServe(addr, nil)
 }
 
@@ -332,6 +369,8 @@ func getStatus() Status {
 		ActiveListeners: []string{},
 		Connected:       exchangeConn != nil,
 		HTTPClients:     websocketHub.ClientAddrs(),
+		BusyTNCs:        tncGuard.Held(),
+		RigOwners:       tncGuard.RigOwners(),
 	}
 
 	for _, tl := range listenHub.Active() {
@@ -388,6 +427,7 @@ func ConnectHandler(w http.ResponseWriter, req *http.Request) {
 
 	nMsgs := mbox.InboxCount()
 
+	preemptScan()
 	if success := Connect(connectStr); !success {
 		http.Error(w, "Session failure", http.StatusInternalServerError)
 	}
@@ -399,6 +439,37 @@ func ConnectHandler(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+func listenHandler(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(getStatus().ActiveListeners)
+}
+
+// startListenHandler starts the listeners given by a JSON array of listen methods (e.g. ["ardop", "telnet"]).
+func startListenHandler(w http.ResponseWriter, req *http.Request) {
+	var methods []string
+	if err := json.NewDecoder(req.Body).Decode(&methods); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := listenHub.Start(methods...); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode("OK")
+}
+
+func stopListenHandler(w http.ResponseWriter, req *http.Request) {
+	listenHub.Stop(mux.Vars(req)["method"])
+	json.NewEncoder(w).Encode("OK")
+}
+
+func abortConnectHandler(w http.ResponseWriter, req *http.Request) {
+	if !abortConnect() {
+		http.Error(w, "No connect in progress", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode("OK")
+}
+
 func mailboxHandler(w http.ResponseWriter, r *http.Request) {
 	box := mux.Vars(r)["box"]
 
@@ -10,11 +10,14 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/la5nta/wl2k-go/transport/ax25"
 	"github.com/peterh/liner"
+
+	"github.com/la5nta/pat/internal/vara"
 )
 
 func Interactive() {
@@ -32,6 +35,11 @@ func Interactive() {
 			continue
 		}
 
+		// Manual connects preempt the scan
+		if cmd, _ := parseCommand(str); cmd == "connect" {
+			preemptScan()
+		}
+
 		if quit := execCmd(str); quit {
 			break
 		}
@@ -47,11 +55,35 @@ func execCmd(line string) (quit bool) {
 			return
 		}
 
-		Connect(param)
+		connectAny(false, strings.Fields(param)...)
 	case "listen":
 		Listen(param)
 	case "unlisten":
 		Unlisten(param)
+	case "abort":
+		if !abortConnect() {
+			fmt.Println("No connect in progress")
+		}
+	case "scan":
+		if param == "stop" {
+			if !scanner.Stop() {
+				fmt.Println("No scan in progress")
+			}
+			return
+		}
+		if err := scanner.Start(); err != nil {
+			log.Println(err)
+		}
+	case "reach":
+		if param == "clear" {
+			reachCache.Clear()
+			return
+		}
+		reachCache.Print()
+	case "skip":
+		if !connectCooldown.Skip() {
+			fmt.Println("No connect waiting for cool-down")
+		}
 	case "heard":
 		PrintHeard()
 	case "freq":
@@ -61,6 +93,9 @@ func execCmd(line string) (quit bool) {
 	case "debug":
 		os.Setenv("ardop_debug", "1")
 		os.Setenv("winmor_debug", "1")
+		os.Setenv("vara_debug", "1")
+		os.Setenv("agwpe_debug", "1")
+		os.Setenv("kiss_debug", "1")
 		fmt.Println("Number of goroutines:", runtime.NumGoroutine())
 	case "q", "quit":
 		return true
@@ -78,18 +113,27 @@ func printInteractiveUsage() {
 	methods := []string{
 		MethodWinmor,
 		MethodArdop,
+		MethodVaraHF,
+		MethodVaraFM,
 		MethodAX25,
 		MethodTelnet,
+		MethodTelnets,
 		MethodSerialTNC,
+		MethodAGWPE,
+		MethodKISSTCP,
 	}
 	fmt.Println("Methods:", strings.Join(methods, ", "))
 
 	cmds := []string{
-		"connect  METHOD:[URI] or alias  Connect to a remote station.",
+		"connect  METHOD:[URI] or alias  Connect to a remote station (multiple are tried in turn).",
 		"listen   METHOD                 Listen for incoming connections.",
 		"unlisten METHOD                 Unregister listener for incoming connections.",
 		"freq     METHOD:FREQ            Change rig frequency.",
+		"scan     [stop]                 Cycle the scan_transport listener across the scan_list frequencies.",
+		"abort                           Abort all connects in progress (e.g. scheduled).",
+		"skip                            Skip the cool-down before the next automated connect.",
 		"heard                           Display all stations heard over the air.",
+		"reach    [clear]                Display (or clear) the recent connect outcomes (see reach_cache).",
 		"qtc                             Print pending outbound messages.",
 	}
 	fmt.Println("Commands: ")
@@ -127,14 +171,38 @@ func PrintHeard() {
 		}
 	}
 
-	fmt.Println("ardop:")
-	if adTNC == nil {
-		fmt.Println("  (not initialized)")
-	} else if heard := adTNC.Heard(); len(heard) == 0 {
-		fmt.Println("  (none)")
-	} else {
-		for call, t := range heard {
-			pf(call, t)
+	names := []string{""}
+	for name := range config.ArdopInstances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conf, _ := ardopConfig(name)
+		fmt.Println(ARDOPListener{Instance: name}.Name() + ":")
+		if tnc, ok := ardopTNC(conf); !ok {
+			fmt.Println("  (not initialized)")
+		} else if heard := tnc.Heard(); len(heard) == 0 {
+			fmt.Println("  (none)")
+		} else {
+			for call, t := range heard {
+				pf(call, t)
+			}
+		}
+	}
+
+	for _, tnc := range []struct {
+		method string
+		modem  *vara.Modem
+	}{{MethodVaraHF, varaHFTNC}, {MethodVaraFM, varaFMTNC}} {
+		fmt.Printf("%s:\n", tnc.method)
+		if tnc.modem == nil {
+			fmt.Println("  (not initialized)")
+		} else if heard := tnc.modem.Heard(); len(heard) == 0 {
+			fmt.Println("  (none)")
+		} else {
+			for call, t := range heard {
+				pf(call, t)
+			}
 		}
 	}
 
@@ -0,0 +1,622 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package agwpe provides AX.25 connected mode over an AGWPE compatible TCP interface (e.g. AGWPE, Direwolf or UZ7HO soundmodem).
+//
+// Importing this package registers the "agwpe" scheme with the transport package. The URL host is the
+// network address of the AGWPE TCP port (e.g. localhost:8000) and the radio port is selected with the
+// URL parameter ?radio_port= (default 0). Register a Client with credentials to connect to AGWPE
+// instances requiring login.
+package agwpe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// DefaultAddr is the default network address of the AGWPE TCP port.
+const DefaultAddr = "localhost:8000"
+
+var (
+	ErrEngineClosed      = errors.New("AGWPE connection closed")
+	ErrRegisterFailed    = errors.New("Callsign registration rejected by AGWPE")
+	ErrConnectInProgress = errors.New("A connection to this station is already in progress")
+	ErrConnectFailed     = errors.New("Connect failed")
+)
+
+const (
+	maxPacketLen       = 256 // Max number of bytes per data frame.
+	maxOutstanding     = 8   // Max number of outstanding frames before Write blocks.
+	outstandingPoll    = 500 * time.Millisecond
+	registerTimeout    = 10 * time.Second // Time to wait for callsign registration reply.
+	disconnectTimeout  = 30 * time.Second // Time to wait for disconnect confirmation.
+	flushTimeout       = 10 * time.Minute // Time to wait for outstanding frames on close.
+	outstandingTimeout = 10 * time.Second
+)
+
+func init() {
+	transport.RegisterDialer("agwpe", Client{})
+}
+
+func debugEnabled() bool { return os.Getenv("agwpe_debug") != "" }
+
+// Client dials and listens using the AGWPE TCP port.
+//
+// If Username is set, the client logs in to the AGWPE TCP port before registering the callsign.
+type Client struct {
+	Username string
+	Password string
+}
+
+func (c Client) DialURL(url *transport.URL) (net.Conn, error) {
+	addr := url.Host
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	var radioPort int
+	if v := url.Params.Get("radio_port"); v != "" {
+		var err error
+		if radioPort, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("Invalid radio_port parameter: %s", err)
+		}
+	}
+
+	return c.Dial(addr, radioPort, url.User.Username(), url.Target, url.Digis...)
+}
+
+// Dial opens a new connection to the AGWPE TCP port at addr and connects to target on the given radio port,
+// optionally via the given digipeaters.
+func Dial(addr string, radioPort int, mycall, target string, digis ...string) (net.Conn, error) {
+	return Client{}.Dial(addr, radioPort, mycall, target, digis...)
+}
+
+// Dial is like the package level Dial, but logs in using the client's credentials.
+func (c Client) Dial(addr string, radioPort int, mycall, target string, digis ...string) (net.Conn, error) {
+	e, err := c.open(addr, radioPort, mycall)
+	if err != nil {
+		return nil, err
+	}
+
+	// Registration is only required for inbound connections, but some implementations require it for
+	// outbound connections as well. Ignore failure, as the callsign might already be registered by a listener.
+	if err := e.register(); err != nil && debugEnabled() {
+		log.Printf("agwpe: %s", err)
+	}
+
+	conn, err := e.connect(target, digis)
+	if err != nil {
+		e.close()
+		return nil, err
+	}
+	conn.ownsEngine = true
+	return conn, nil
+}
+
+// Listen opens a new connection to the AGWPE TCP port at addr and accepts inbound connections to mycall on the
+// given radio port.
+func Listen(addr string, radioPort int, mycall string) (net.Listener, error) {
+	return Client{}.Listen(addr, radioPort, mycall)
+}
+
+// Listen is like the package level Listen, but logs in using the client's credentials.
+func (c Client) Listen(addr string, radioPort int, mycall string) (net.Listener, error) {
+	e, err := c.open(addr, radioPort, mycall)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.register(); err != nil {
+		e.close()
+		return nil, err
+	}
+
+	l := &listener{
+		engine:   e,
+		incoming: make(chan *Conn, 8),
+		done:     make(chan struct{}),
+	}
+
+	e.mu.Lock()
+	e.listener = l
+	e.mu.Unlock()
+
+	return l, nil
+}
+
+// engine is a single TCP connection to the AGWPE TCP port.
+type engine struct {
+	conn   net.Conn
+	port   byte
+	mycall string
+
+	wmu sync.Mutex // Serializes writes to conn.
+	ymu sync.Mutex // Serializes outstanding frames queries.
+
+	registered  chan bool
+	outstanding chan int
+
+	mu       sync.Mutex
+	closed   bool
+	conns    map[string]*Conn // Established connections by remote call.
+	dialing  map[string]chan error
+	listener *listener
+}
+
+func openEngine(addr string, radioPort int, mycall string) (*engine, error) {
+	if radioPort < 0 || radioPort > 255 {
+		return nil, fmt.Errorf("Invalid radio port %d", radioPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &engine{
+		conn:        conn,
+		port:        byte(radioPort),
+		mycall:      strings.ToUpper(mycall),
+		registered:  make(chan bool, 1),
+		outstanding: make(chan int, 1),
+		conns:       make(map[string]*Conn),
+		dialing:     make(map[string]chan error),
+	}
+	go e.readLoop()
+	return e, nil
+}
+
+// open opens a new engine, and logs in if the client has credentials.
+func (c Client) open(addr string, radioPort int, mycall string) (*engine, error) {
+	e, err := openEngine(addr, radioPort, mycall)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username == "" {
+		return e, nil
+	}
+	if err := e.login(c.Username, c.Password); err != nil {
+		e.close()
+		return nil, fmt.Errorf("AGWPE login failed: %s", err)
+	}
+	return e, nil
+}
+
+func (e *engine) send(f frame) error {
+	f.port = e.port
+
+	if debugEnabled() {
+		log.Printf("agwpe --> %s", f)
+	}
+
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+	return writeFrame(e.conn, f)
+}
+
+// login sends the username and password. AGWPE does not reply on success, but closes the
+// connection if the login is rejected.
+func (e *engine) login(username, password string) error {
+	const fieldLen = 255
+	if len(username) > fieldLen || len(password) > fieldLen {
+		return fmt.Errorf("Username or password too long")
+	}
+	data := make([]byte, 2*fieldLen)
+	copy(data, username)
+	copy(data[fieldLen:], password)
+	return e.send(frame{kind: kindLogin, data: data})
+}
+
+func (e *engine) register() error {
+	if err := e.send(frame{kind: kindRegister, from: e.mycall}); err != nil {
+		return err
+	}
+
+	select {
+	case ok := <-e.registered:
+		if !ok {
+			return ErrRegisterFailed
+		}
+		return nil
+	case <-time.After(registerTimeout):
+		return fmt.Errorf("Timeout waiting for callsign registration")
+	}
+}
+
+func (e *engine) connect(target string, digis []string) (*Conn, error) {
+	target = strings.ToUpper(target)
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, ErrEngineClosed
+	}
+	if _, ok := e.dialing[target]; ok {
+		e.mu.Unlock()
+		return nil, ErrConnectInProgress
+	}
+	result := make(chan error, 1)
+	e.dialing[target] = result
+	e.mu.Unlock()
+
+	f := frame{kind: kindConnect, from: e.mycall, to: target}
+	if len(digis) > 0 {
+		f.kind = kindConnectVia
+		f.data = append(f.data, byte(len(digis)))
+		for _, digi := range digis {
+			f.data = append(f.data, formatCall(digi)...)
+		}
+	}
+
+	err := e.send(f)
+	if err == nil {
+		err = <-result
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.dialing, target)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := e.conns[target]
+	if !ok {
+		return nil, ErrEngineClosed
+	}
+	return c, nil
+}
+
+// outstandingFrames returns the number of frames not yet acknowledged by the remote station.
+func (e *engine) outstandingFrames(remote string) (int, error) {
+	e.ymu.Lock()
+	defer e.ymu.Unlock()
+
+	if err := e.send(frame{kind: kindOutstanding, from: e.mycall, to: remote}); err != nil {
+		return 0, err
+	}
+	select {
+	case n := <-e.outstanding:
+		return n, nil
+	case <-time.After(outstandingTimeout):
+		return 0, fmt.Errorf("Timeout waiting for outstanding frames count")
+	}
+}
+
+func (e *engine) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.conn.Close()
+}
+
+func (e *engine) readLoop() {
+	for {
+		f, err := readFrame(e.conn)
+		if err != nil {
+			e.shutdown(err)
+			return
+		}
+
+		if debugEnabled() {
+			log.Printf("agwpe <-- %s %q", f, f.data)
+		}
+
+		switch f.kind {
+		case kindRegister:
+			select {
+			case e.registered <- len(f.data) > 0 && f.data[0] == 1:
+			default:
+			}
+		case kindOutstanding:
+			if len(f.data) >= 4 {
+				n := int(f.data[0]) | int(f.data[1])<<8 | int(f.data[2])<<16 | int(f.data[3])<<24
+				select {
+				case e.outstanding <- n:
+				default:
+				}
+			}
+		case kindConnect:
+			e.handleConnected(e.remoteCall(f))
+		case kindData:
+			e.mu.Lock()
+			c := e.conns[e.remoteCall(f)]
+			e.mu.Unlock()
+			if c != nil {
+				c.rx.Write(f.data)
+			}
+		case kindDisconnect:
+			e.handleDisconnected(e.remoteCall(f), string(f.data))
+		}
+	}
+}
+
+// remoteCall returns the remote station's call for the given frame.
+//
+// Implementations differ in which of the call fields hold the remote station.
+func (e *engine) remoteCall(f frame) string {
+	if f.from == e.mycall {
+		return f.to
+	}
+	return f.from
+}
+
+func (e *engine) handleConnected(remote string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.conns[remote]; ok {
+		return // Already connected
+	}
+
+	c := &Conn{
+		engine:       e,
+		remote:       remote,
+		rx:           newBuffer(),
+		disconnected: make(chan struct{}),
+	}
+
+	if result, ok := e.dialing[remote]; ok {
+		e.conns[remote] = c
+		select {
+		case result <- nil:
+		default:
+		}
+		return
+	}
+
+	if e.listener == nil {
+		go e.send(frame{kind: kindDisconnect, from: e.mycall, to: remote})
+		return
+	}
+
+	select {
+	case e.listener.incoming <- c:
+		e.conns[remote] = c
+	default:
+		// The listener is not keeping up. Reject the connection.
+		go e.send(frame{kind: kindDisconnect, from: e.mycall, to: remote})
+	}
+}
+
+func (e *engine) handleDisconnected(remote, msg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if result, ok := e.dialing[remote]; ok {
+		if _, connected := e.conns[remote]; !connected {
+			err := ErrConnectFailed
+			if msg = strings.TrimSpace(msg); msg != "" {
+				err = fmt.Errorf("%s: %s", ErrConnectFailed, msg)
+			}
+			select {
+			case result <- err:
+			default:
+			}
+			return
+		}
+	}
+
+	if c, ok := e.conns[remote]; ok {
+		delete(e.conns, remote)
+		c.end()
+	}
+}
+
+func (e *engine) shutdown(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.closed && debugEnabled() {
+		log.Printf("agwpe: connection lost: %s", err)
+	}
+	e.closed = true
+	e.conn.Close()
+
+	for remote, c := range e.conns {
+		delete(e.conns, remote)
+		c.end()
+	}
+	for _, result := range e.dialing {
+		select {
+		case result <- ErrEngineClosed:
+		default:
+		}
+	}
+	if e.listener != nil {
+		e.listener.end()
+	}
+}
+
+// Conn is an AX.25 connection established through AGWPE.
+type Conn struct {
+	engine     *engine
+	remote     string
+	ownsEngine bool // True if the engine should be closed with this conn.
+
+	rx *buffer
+
+	closeOnce    sync.Once
+	endOnce      sync.Once
+	disconnected chan struct{}
+}
+
+func (c *Conn) end() {
+	c.endOnce.Do(func() {
+		c.rx.Close()
+		close(c.disconnected)
+	})
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.rx.Read(p) }
+
+func (c *Conn) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		select {
+		case <-c.disconnected:
+			return n, io.ErrClosedPipe
+		default:
+		}
+
+		if err := c.waitOutstanding(maxOutstanding); err != nil {
+			return n, err
+		}
+
+		chunk := p
+		if len(chunk) > maxPacketLen {
+			chunk = chunk[:maxPacketLen]
+		}
+		err := c.engine.send(frame{kind: kindData, pid: pidNoL3, from: c.engine.mycall, to: c.remote, data: chunk})
+		if err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// waitOutstanding blocks until less than max frames are waiting to be acknowledged by the remote station.
+func (c *Conn) waitOutstanding(max int) error {
+	for {
+		n, err := c.engine.outstandingFrames(c.remote)
+		if err != nil || n < max {
+			return err
+		}
+		select {
+		case <-c.disconnected:
+			return io.ErrClosedPipe
+		case <-time.After(outstandingPoll):
+		}
+	}
+}
+
+// Flush blocks until all written data has been acknowledged by the remote station.
+func (c *Conn) Flush() error { return c.waitOutstanding(1) }
+
+// Close waits for outstanding data to be acknowledged (with a timeout) and disconnects.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		select {
+		case <-c.disconnected:
+		default:
+			done := make(chan struct{})
+			go func() { c.Flush(); close(done) }()
+			select {
+			case <-done:
+			case <-time.After(flushTimeout):
+			}
+
+			c.engine.send(frame{kind: kindDisconnect, from: c.engine.mycall, to: c.remote})
+			select {
+			case <-c.disconnected:
+			case <-time.After(disconnectTimeout):
+			}
+		}
+
+		c.engine.mu.Lock()
+		if c.engine.conns[c.remote] == c {
+			delete(c.engine.conns, c.remote)
+		}
+		c.engine.mu.Unlock()
+		c.end()
+
+		if c.ownsEngine {
+			c.engine.close()
+		}
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return Addr(c.engine.mycall) }
+func (c *Conn) RemoteAddr() net.Addr { return Addr(c.remote) }
+
+func (c *Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Addr is an AX.25 address (callsign) on the AGWPE transport.
+type Addr string
+
+func (a Addr) Network() string { return "agwpe" }
+func (a Addr) String() string  { return string(a) }
+
+type listener struct {
+	engine   *engine
+	incoming chan *Conn
+
+	endOnce sync.Once
+	done    chan struct{}
+}
+
+func (l *listener) end() { l.endOnce.Do(func() { close(l.done) }) }
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.incoming:
+		return c, nil
+	case <-l.done:
+		return nil, ErrEngineClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.end()
+	return l.engine.close()
+}
+
+func (l *listener) Addr() net.Addr { return Addr(l.engine.mycall) }
+
+// buffer is a non-blocking-write, blocking-read byte buffer.
+type buffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *buffer) Write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	b.cond.Broadcast()
+}
+
+func (b *buffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *buffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package agwpe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Frame kinds used by this package (see the AGWPE TCP/IP API documentation).
+const (
+	kindLogin       = 'P' // Login (username and password).
+	kindRegister    = 'X' // Register callsign.
+	kindConnect     = 'C' // Connect, or connection established.
+	kindConnectVia  = 'v' // Connect via digipeaters.
+	kindData        = 'D' // Connected data.
+	kindDisconnect  = 'd' // Disconnect, or disconnected.
+	kindOutstanding = 'Y' // Number of outstanding frames for a connection.
+	kindVersion     = 'R' // Version info.
+)
+
+const (
+	headerLen = 36
+	callLen   = 10
+	pidNoL3   = 0xF0 // AX.25 PID: No layer 3 protocol.
+
+	maxDataLen = 64 * 1024 // Sanity check for incoming frames.
+)
+
+type frame struct {
+	port byte
+	kind byte
+	pid  byte
+	from string
+	to   string
+	data []byte
+}
+
+func (f frame) String() string {
+	return fmt.Sprintf("%c port=%d %s>%s len=%d", f.kind, f.port, f.from, f.to, len(f.data))
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		port: hdr[0],
+		kind: hdr[4],
+		pid:  hdr[6],
+		from: parseCall(hdr[8:18]),
+		to:   parseCall(hdr[18:28]),
+	}
+
+	n := binary.LittleEndian.Uint32(hdr[28:32])
+	if n > maxDataLen {
+		return f, fmt.Errorf("Invalid frame length %d", n)
+	}
+	f.data = make([]byte, n)
+	_, err := io.ReadFull(r, f.data)
+	return f, err
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	buf := make([]byte, headerLen, headerLen+len(f.data))
+	buf[0] = f.port
+	buf[4] = f.kind
+	buf[6] = f.pid
+	copy(buf[8:18], formatCall(f.from))
+	copy(buf[18:28], formatCall(f.to))
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(len(f.data)))
+	buf = append(buf, f.data...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func parseCall(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(string(b)))
+}
+
+func formatCall(call string) []byte {
+	b := make([]byte, callLen)
+	copy(b, strings.ToUpper(call))
+	return b
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package kiss
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AX.25 control field values (modulo 8).
+const (
+	ctrlSABM = 0x2F
+	ctrlUA   = 0x63
+	ctrlDISC = 0x43
+	ctrlDM   = 0x0F
+	ctrlFRMR = 0x87
+	ctrlUI   = 0x03
+
+	ctrlRR  = 0x01
+	ctrlRNR = 0x05
+	ctrlREJ = 0x09
+
+	ctrlPF = 0x10 // Poll/final bit.
+
+	pidNoL3 = 0xF0
+)
+
+var errInvalidFrame = errors.New("Invalid AX.25 frame")
+
+// Addr is an AX.25 address (callsign and SSID).
+type Addr struct {
+	Call string
+	SSID byte
+}
+
+// ParseAddr parses an address on the form CALL[-SSID].
+func ParseAddr(str string) (Addr, error) {
+	parts := strings.SplitN(strings.ToUpper(strings.TrimSpace(str)), "-", 2)
+	a := Addr{Call: parts[0]}
+	if len(a.Call) == 0 || len(a.Call) > 6 {
+		return a, fmt.Errorf("Invalid callsign '%s'", str)
+	}
+	if len(parts) == 2 {
+		ssid, err := strconv.Atoi(parts[1])
+		if err != nil || ssid < 0 || ssid > 15 {
+			return a, fmt.Errorf("Invalid SSID in '%s'", str)
+		}
+		a.SSID = byte(ssid)
+	}
+	return a, nil
+}
+
+func (a Addr) Network() string { return "kiss-tcp" }
+
+func (a Addr) String() string {
+	if a.SSID == 0 {
+		return a.Call
+	}
+	return fmt.Sprintf("%s-%d", a.Call, a.SSID)
+}
+
+func (a Addr) encode(cbit, last bool) []byte {
+	b := make([]byte, 7)
+	call := a.Call + "      "
+	for i := 0; i < 6; i++ {
+		b[i] = call[i] << 1
+	}
+	b[6] = 0x60 | a.SSID<<1
+	if cbit {
+		b[6] |= 0x80
+	}
+	if last {
+		b[6] |= 0x01
+	}
+	return b
+}
+
+func decodeAddr(b []byte) (a Addr, cbit, last bool) {
+	call := make([]byte, 6)
+	for i := 0; i < 6; i++ {
+		call[i] = b[i] >> 1
+	}
+	a.Call = strings.TrimSpace(string(call))
+	a.SSID = (b[6] >> 1) & 0x0F
+	return a, b[6]&0x80 != 0, b[6]&0x01 != 0
+}
+
+// frame is a decoded AX.25 frame.
+type frame struct {
+	dst, src Addr
+	digis    []Addr
+	command  bool // True if this is a command frame, false if response.
+	pending  bool // True if the frame has not yet been repeated by all digipeaters.
+	ctrl     byte
+	info     []byte
+}
+
+func (f frame) encode() []byte {
+	buf := make([]byte, 0, 16+7*len(f.digis)+len(f.info))
+	buf = append(buf, f.dst.encode(f.command, false)...)
+	buf = append(buf, f.src.encode(!f.command, len(f.digis) == 0)...)
+	for i, digi := range f.digis {
+		buf = append(buf, digi.encode(false, i == len(f.digis)-1)...)
+	}
+	buf = append(buf, f.ctrl)
+	if f.isI() || f.ctrl&^ctrlPF == ctrlUI {
+		buf = append(buf, pidNoL3)
+	}
+	return append(buf, f.info...)
+}
+
+func decodeFrame(b []byte) (frame, error) {
+	var f frame
+	if len(b) < 15 {
+		return f, errInvalidFrame
+	}
+
+	var dstC, srcC, last bool
+	f.dst, dstC, _ = decodeAddr(b[0:7])
+	f.src, srcC, last = decodeAddr(b[7:14])
+	f.command = dstC && !srcC
+	b = b[14:]
+
+	for !last {
+		if len(b) < 8 {
+			return f, errInvalidFrame
+		}
+		digi, repeated, isLast := decodeAddr(b[0:7])
+		f.digis = append(f.digis, digi)
+		f.pending, last = !repeated, isLast
+		b = b[7:]
+	}
+
+	f.ctrl = b[0]
+	b = b[1:]
+	if f.isI() || f.ctrl&^ctrlPF == ctrlUI {
+		if len(b) < 1 {
+			return f, errInvalidFrame
+		}
+		b = b[1:] // PID
+	}
+	f.info = b
+	return f, nil
+}
+
+func (f frame) isI() bool            { return f.ctrl&0x01 == 0 }
+func (f frame) isS() bool            { return f.ctrl&0x03 == 0x01 }
+func (f frame) pf() bool             { return f.ctrl&ctrlPF != 0 }
+func (f frame) nr() byte             { return f.ctrl >> 5 }
+func (f frame) ns() byte             { return (f.ctrl >> 1) & 0x07 }
+func (f frame) sType() byte          { return f.ctrl & 0x0F }
+func (f frame) uType() byte          { return f.ctrl &^ ctrlPF }
+func iCtrl(nr, ns byte, p bool) byte { return nr<<5 | pfBit(p) | ns<<1 }
+func sCtrl(typ, nr byte, pf bool) byte {
+	return nr<<5 | pfBit(pf) | typ
+}
+
+func pfBit(set bool) byte {
+	if set {
+		return ctrlPF
+	}
+	return 0
+}
@@ -0,0 +1,505 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package kiss
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// DefaultAddr is the default network address of the KISS TCP port (Direwolf's default).
+const DefaultAddr = "localhost:8001"
+
+var (
+	ErrConnectionRefused = errors.New("Connection refused by remote station")
+	ErrLinkTimeout       = errors.New("AX.25 link timeout")
+	ErrFrameReject       = errors.New("Frame rejected by remote station (FRMR)")
+)
+
+const (
+	paclen   = 128              // Max number of bytes per I frame.
+	window   = 4                // Max number of outstanding I frames (k).
+	t1       = 10 * time.Second // Retransmission timer.
+	n2       = 10               // Max number of retries.
+	maxQueue = window * paclen  // Max number of bytes queued for transmission before Write blocks.
+
+	dialTimeout = 30 * time.Second // Timeout of the KISS TCP connect.
+)
+
+func init() {
+	transport.RegisterDialer("kiss-tcp", dialer{})
+}
+
+func debugEnabled() bool { return os.Getenv("kiss_debug") != "" }
+
+type dialer struct{}
+
+func (dialer) DialURL(url *transport.URL) (net.Conn, error) {
+	addr := url.Host
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	var port int
+	if v := url.Params.Get("kiss_port"); v != "" {
+		var err error
+		if port, err = strconv.Atoi(v); err != nil || port < 0 || port > 15 {
+			return nil, fmt.Errorf("Invalid kiss_port parameter: %s", v)
+		}
+	}
+
+	return Dial(addr, byte(port), url.User.Username(), url.Target, url.Digis...)
+}
+
+type state int
+
+const (
+	stateConnecting state = iota
+	stateConnected
+	stateDisconnecting
+	stateDisconnected
+)
+
+// Conn is an AX.25 connection through a KISS TNC.
+type Conn struct {
+	tcp    io.Closer // The KISS TCP connection, if owned by this Conn (nil if shared with a Listener).
+	fw     frameWriter
+	local  Addr
+	remote Addr
+	digis  []Addr
+
+	rx *buffer
+
+	writes    chan []byte
+	flushReqs chan chan struct{}
+	closeReq  chan struct{}
+	frames    chan frame
+	connected chan struct{}
+	done      chan struct{}
+
+	closeOnce sync.Once
+
+	// Owned by the run loop
+	state      state
+	err        error
+	vs, va, vr byte
+	sent       [8][]byte
+	txq        []byte
+	retries    int
+	rejSent    bool
+	flushers   []chan struct{}
+}
+
+// Dial connects to the KISS TCP port at addr, and establishes an AX.25 connection from mycall to target on the given
+// KISS port, optionally via the given digipeaters.
+func Dial(addr string, port byte, mycall, target string, digis ...string) (net.Conn, error) {
+	local, err := ParseAddr(mycall)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := ParseAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	path := make([]Addr, len(digis))
+	for i, digi := range digis {
+		if path[i], err = ParseAddr(digi); err != nil {
+			return nil, err
+		}
+	}
+
+	tcp, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(frameWriter{tcp, port}, local, remote, path)
+	c.tcp = tcp
+	go c.readLoop(tcp)
+	go c.run()
+
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.done:
+		tcp.Close()
+		return nil, c.err
+	}
+}
+
+func newConn(fw frameWriter, local, remote Addr, digis []Addr) *Conn {
+	return &Conn{
+		fw:        fw,
+		local:     local,
+		remote:    remote,
+		digis:     digis,
+		rx:        newBuffer(),
+		writes:    make(chan []byte),
+		flushReqs: make(chan chan struct{}),
+		closeReq:  make(chan struct{}),
+		frames:    make(chan frame),
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (c *Conn) readLoop(tcp net.Conn) {
+	r := bufio.NewReader(tcp)
+	defer close(c.frames)
+	for {
+		_, raw, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		f, err := decodeFrame(raw)
+		if err != nil || f.pending || f.dst != c.local || f.src != c.remote {
+			continue
+		}
+
+		select {
+		case c.frames <- f:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) send(f frame) {
+	f.src, f.dst, f.digis = c.local, c.remote, c.digis
+	if debugEnabled() {
+		log.Printf("kiss --> %s>%s ctrl=%#02x len=%d", f.src, f.dst, f.ctrl, len(f.info))
+	}
+	if err := c.fw.writeFrame(f.encode()); err != nil {
+		c.fail(err)
+	}
+}
+
+func (c *Conn) fail(err error) {
+	if c.state == stateDisconnected {
+		return
+	}
+	c.state, c.err = stateDisconnected, err
+}
+
+func (c *Conn) run() {
+	timer := time.NewTimer(t1)
+	defer timer.Stop()
+	restartT1 := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(t1)
+	}
+	stopT1 := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+
+	if c.state == stateConnecting {
+		c.send(frame{command: true, ctrl: ctrlSABM | ctrlPF})
+	}
+
+	for c.state != stateDisconnected {
+		var writes chan []byte
+		if c.state == stateConnected && len(c.txq) < maxQueue {
+			writes = c.writes
+		}
+
+		select {
+		case p := <-writes:
+			c.txq = append(c.txq, p...)
+		case ch := <-c.flushReqs:
+			c.flushers = append(c.flushers, ch)
+		case <-c.closeReq:
+			if c.state == stateConnected || c.state == stateConnecting {
+				c.state, c.retries = stateDisconnecting, 0
+				c.send(frame{command: true, ctrl: ctrlDISC | ctrlPF})
+				restartT1()
+			}
+		case f, ok := <-c.frames:
+			if !ok {
+				c.fail(io.ErrUnexpectedEOF)
+				break
+			}
+			if progress := c.handle(f); progress {
+				restartT1()
+			}
+		case <-timer.C:
+			if c.retries++; c.retries > n2 {
+				c.fail(ErrLinkTimeout)
+				break
+			}
+			switch c.state {
+			case stateConnecting:
+				c.send(frame{command: true, ctrl: ctrlSABM | ctrlPF})
+			case stateDisconnecting:
+				c.send(frame{command: true, ctrl: ctrlDISC | ctrlPF})
+			case stateConnected:
+				c.retransmit()
+			}
+			restartT1()
+		}
+
+		if c.state == stateConnected {
+			if c.pump() {
+				restartT1()
+			}
+			if c.va == c.vs && len(c.txq) == 0 {
+				stopT1()
+				for _, ch := range c.flushers {
+					close(ch)
+				}
+				c.flushers = nil
+			}
+		}
+	}
+
+	c.rx.Close()
+	close(c.done)
+}
+
+// handle processes an incoming frame, and returns true if the frame made progress (i.e. T1 should be restarted).
+func (c *Conn) handle(f frame) (progress bool) {
+	if debugEnabled() {
+		log.Printf("kiss <-- %s>%s ctrl=%#02x len=%d", f.src, f.dst, f.ctrl, len(f.info))
+	}
+
+	switch {
+	case f.isI():
+		if c.state != stateConnected {
+			return false
+		}
+		progress = c.ack(f.nr())
+		if f.ns() == c.vr {
+			c.rx.Write(f.info)
+			c.vr = (c.vr + 1) % 8
+			c.rejSent = false
+			c.send(frame{ctrl: sCtrl(ctrlRR, c.vr, f.pf())})
+		} else if !c.rejSent {
+			c.rejSent = true
+			c.send(frame{ctrl: sCtrl(ctrlREJ, c.vr, f.pf())})
+		} else if f.pf() {
+			c.send(frame{ctrl: sCtrl(ctrlRR, c.vr, true)})
+		}
+	case f.isS():
+		if c.state != stateConnected {
+			return false
+		}
+		progress = c.ack(f.nr())
+		switch {
+		case f.command && f.pf():
+			c.send(frame{ctrl: sCtrl(ctrlRR, c.vr, true)})
+		case f.sType() == ctrlREJ:
+			c.retransmit()
+			progress = true
+		}
+	default:
+		switch f.uType() {
+		case ctrlUA:
+			switch c.state {
+			case stateConnecting:
+				c.state, c.retries = stateConnected, 0
+				close(c.connected)
+			case stateDisconnecting:
+				c.state = stateDisconnected
+			}
+			return true
+		case ctrlDM:
+			if c.state == stateConnecting {
+				c.fail(ErrConnectionRefused)
+			} else {
+				c.state = stateDisconnected
+			}
+		case ctrlDISC:
+			c.send(frame{ctrl: ctrlUA | pfBit(f.pf())})
+			c.state = stateDisconnected
+		case ctrlSABM:
+			// Remote reset the link
+			c.send(frame{ctrl: ctrlUA | pfBit(f.pf())})
+			if c.state == stateConnected {
+				c.vs, c.va, c.vr = 0, 0, 0
+				c.sent = [8][]byte{}
+			}
+		case ctrlFRMR:
+			c.fail(ErrFrameReject)
+		}
+	}
+	return progress
+}
+
+// ack marks all I frames up to (but not including) nr as acknowledged, returning true if any were.
+func (c *Conn) ack(nr byte) bool {
+	// Ignore invalid N(R) (outside the window of outstanding frames)
+	if (nr-c.va)%8 > (c.vs-c.va)%8 {
+		return false
+	}
+
+	acked := false
+	for c.va != nr {
+		c.sent[c.va] = nil
+		c.va = (c.va + 1) % 8
+		acked = true
+	}
+	if acked {
+		c.retries = 0
+	}
+	return acked
+}
+
+// pump sends queued data as long as the window allows, returning true if anything was sent.
+func (c *Conn) pump() bool {
+	sent := false
+	for (c.vs-c.va)%8 < window && len(c.txq) > 0 {
+		n := len(c.txq)
+		if n > paclen {
+			n = paclen
+		}
+		info := append([]byte(nil), c.txq[:n]...)
+		c.txq = c.txq[n:]
+
+		c.sent[c.vs] = info
+		c.send(frame{command: true, ctrl: iCtrl(c.vr, c.vs, false), info: info})
+		c.vs = (c.vs + 1) % 8
+		sent = true
+	}
+	return sent
+}
+
+// retransmit resends all unacknowledged I frames, polling for a response with the last one.
+func (c *Conn) retransmit() {
+	for ns := c.va; ns != c.vs; ns = (ns + 1) % 8 {
+		last := (ns+1)%8 == c.vs
+		c.send(frame{command: true, ctrl: iCtrl(c.vr, ns, last), info: c.sent[ns]})
+	}
+	if c.va == c.vs {
+		// Nothing outstanding, poll the remote to check that the link is still up
+		c.send(frame{command: true, ctrl: sCtrl(ctrlRR, c.vr, true)})
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.rx.Read(p) }
+
+func (c *Conn) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		chunk := p[n:]
+		if len(chunk) > maxQueue {
+			chunk = chunk[:maxQueue]
+		}
+		select {
+		case c.writes <- append([]byte(nil), chunk...):
+			n += len(chunk)
+		case <-c.done:
+			return n, io.ErrClosedPipe
+		}
+	}
+	return len(p), nil
+}
+
+// Flush blocks until all written data has been acknowledged by the remote station.
+func (c *Conn) Flush() error {
+	ch := make(chan struct{})
+	select {
+	case c.flushReqs <- ch:
+	case <-c.done:
+		return io.ErrClosedPipe
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-c.done:
+		return io.ErrClosedPipe
+	}
+}
+
+// Close waits for written data to be acknowledged and disconnects the link.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		done := make(chan struct{})
+		go func() { c.Flush(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(n2 * t1):
+		}
+
+		select {
+		case c.closeReq <- struct{}{}:
+		case <-c.done:
+		}
+		select {
+		case <-c.done:
+		case <-time.After((n2 + 1) * t1):
+		}
+		if c.tcp != nil {
+			c.tcp.Close()
+		}
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// buffer is a non-blocking-write, blocking-read byte buffer.
+type buffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newBuffer() *buffer {
+	b := &buffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *buffer) Write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	b.cond.Broadcast()
+}
+
+func (b *buffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *buffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
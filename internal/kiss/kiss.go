@@ -0,0 +1,100 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package kiss provides AX.25 connected mode over a KISS TNC reachable over TCP (e.g. Direwolf's KISS port).
+//
+// Importing this package registers the "kiss-tcp" scheme with the transport package. The URL host is the
+// network address of the KISS TCP port (e.g. localhost:8001) and the KISS port is selected with the URL
+// parameter ?kiss_port= (default 0).
+package kiss
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// KISS special characters.
+const (
+	fend  = 0xC0
+	fesc  = 0xDB
+	tfend = 0xDC
+	tfesc = 0xDD
+)
+
+const cmdDataFrame = 0x00
+
+// encodeFrame returns the KISS encoding of the given AX.25 frame for the given KISS port.
+func encodeFrame(port byte, frame []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(fend)
+	buf.WriteByte(port<<4 | cmdDataFrame)
+	for _, b := range frame {
+		switch b {
+		case fend:
+			buf.Write([]byte{fesc, tfend})
+		case fesc:
+			buf.Write([]byte{fesc, tfesc})
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	buf.WriteByte(fend)
+	return buf.Bytes()
+}
+
+// readFrame reads the next KISS data frame, returning the KISS port and the AX.25 frame.
+//
+// Non-data frames (and empty frames) are skipped.
+func readFrame(r *bufio.Reader) (port byte, frame []byte, err error) {
+	for {
+		// Find start of frame
+		if _, err := r.ReadBytes(fend); err != nil {
+			return 0, nil, err
+		}
+
+		raw, err := r.ReadBytes(fend)
+		if err != nil {
+			return 0, nil, err
+		}
+		r.UnreadByte() // The closing FEND might be the next frame's opening FEND
+
+		raw = raw[:len(raw)-1]
+		if len(raw) < 2 {
+			continue // Empty frame (back-to-back FENDs)
+		}
+		if raw[0]&0x0F != cmdDataFrame {
+			continue
+		}
+
+		frame = make([]byte, 0, len(raw)-1)
+		for i := 1; i < len(raw); i++ {
+			b := raw[i]
+			if b == fesc && i+1 < len(raw) {
+				i++
+				switch raw[i] {
+				case tfend:
+					b = fend
+				case tfesc:
+					b = fesc
+				default:
+					b = raw[i]
+				}
+			}
+			frame = append(frame, b)
+		}
+		return raw[0] >> 4, frame, nil
+	}
+}
+
+// frameWriter writes AX.25 frames to a KISS TNC.
+type frameWriter struct {
+	w    io.Writer
+	port byte
+}
+
+func (fw frameWriter) writeFrame(frame []byte) error {
+	_, err := fw.w.Write(encodeFrame(fw.port, frame))
+	return err
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package kiss
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// The maximum number of accepted connections waiting for Accept. Further connect requests are rejected (DM).
+const acceptBacklog = 4
+
+// Delays between attempts to re-establish a dropped KISS TCP connection.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+var errNotConnected = errors.New("KISS TCP connection is down")
+
+// Listener accepts incoming AX.25 connections through a KISS TNC.
+//
+// All connections accepted by the listener share the KISS TCP connection, which is re-established (with
+// backoff) if it drops. Connections active when it drops are lost.
+type Listener struct {
+	addr  string
+	port  byte
+	local Addr
+	tcp   *sharedConn
+
+	mu    sync.Mutex
+	conns map[Addr]*Conn
+
+	incoming  chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen connects to the KISS TCP port at addr, and listens for incoming AX.25 connections to mycall on the given
+// KISS port.
+func Listen(addr string, port byte, mycall string) (*Listener, error) {
+	local, err := ParseAddr(mycall)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		addr:     addr,
+		port:     port,
+		local:    local,
+		tcp:      &sharedConn{conn: tcp},
+		conns:    make(map[Addr]*Conn),
+		incoming: make(chan *Conn, acceptBacklog),
+		closed:   make(chan struct{}),
+	}
+	go l.run(tcp)
+	return l, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.incoming:
+		return c, nil
+	case <-l.closed:
+		return nil, errors.New("Listener closed")
+	}
+}
+
+// Close stops the listener, and closes the KISS TCP connection.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.tcp.set(nil)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.local }
+
+func (l *Listener) run(tcp net.Conn) {
+	for tcp != nil {
+		l.readLoop(tcp)
+		l.dropConns()
+		tcp = l.reconnect()
+	}
+}
+
+// reconnect re-establishes the KISS TCP connection, returning nil if the listener was closed.
+func (l *Listener) reconnect() net.Conn {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-l.closed:
+			return nil
+		default:
+		}
+		log.Printf("KISS TCP connection to %s lost, reconnecting in %s...", l.addr, delay)
+		select {
+		case <-l.closed:
+			return nil
+		case <-time.After(delay):
+		}
+
+		tcp, err := net.DialTimeout("tcp", l.addr, dialTimeout)
+		if err != nil {
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+		if !l.tcp.set(tcp) {
+			return nil // Closed while connecting
+		}
+		log.Printf("KISS TCP connection to %s re-established", l.addr)
+		return tcp
+	}
+}
+
+func (l *Listener) readLoop(tcp net.Conn) {
+	r := bufio.NewReader(tcp)
+	for {
+		port, raw, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if port != l.port {
+			continue
+		}
+
+		f, err := decodeFrame(raw)
+		if err != nil || f.pending || f.dst != l.local {
+			continue
+		}
+		l.dispatch(f)
+	}
+}
+
+// dispatch passes the frame to the connection with the sending station, accepting a new connection if
+// the frame is a connect request (SABM).
+func (l *Listener) dispatch(f frame) {
+	l.mu.Lock()
+	c, ok := l.conns[f.src]
+	l.mu.Unlock()
+	if ok {
+		select {
+		case c.frames <- f:
+		case <-c.done:
+		}
+		return
+	}
+
+	// Unknown station
+	fw := frameWriter{l.tcp, l.port}
+	switch {
+	case f.isI() || f.isS():
+	case f.uType() == ctrlSABM:
+		path := make([]Addr, len(f.digis))
+		for i, digi := range f.digis {
+			path[len(path)-1-i] = digi // Reply through the same digipeaters, reversed
+		}
+		c := newConn(fw, l.local, f.src, path)
+		if len(l.incoming) == cap(l.incoming) {
+			c.send(frame{ctrl: ctrlDM | pfBit(f.pf())})
+			return
+		}
+		c.state = stateConnected
+		close(c.connected)
+		c.send(frame{ctrl: ctrlUA | pfBit(f.pf())})
+		go c.run()
+
+		l.mu.Lock()
+		l.conns[f.src] = c
+		l.mu.Unlock()
+		go func() {
+			<-c.done
+			l.mu.Lock()
+			if l.conns[c.remote] == c {
+				delete(l.conns, c.remote)
+			}
+			l.mu.Unlock()
+		}()
+		l.incoming <- c
+		return
+	case f.uType() == ctrlDM || f.uType() == ctrlUI || f.uType() == ctrlUA:
+		return
+	}
+	// Not connected
+	if f.command {
+		c := newConn(fw, l.local, f.src, nil)
+		c.send(frame{ctrl: ctrlDM | pfBit(f.pf())})
+	}
+}
+
+// dropConns fails all active connections (the KISS TCP connection dropped).
+func (l *Listener) dropConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for remote, c := range l.conns {
+		close(c.frames)
+		delete(l.conns, remote)
+	}
+}
+
+// sharedConn is the KISS TCP connection of a Listener, written to by all its connections.
+type sharedConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// set replaces (and closes) the current connection, returning false if the shared connection is closed.
+//
+// Setting nil closes the shared connection.
+func (s *sharedConn) set(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return false
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.closed = conn, conn == nil
+	return true
+}
+
+func (s *sharedConn) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return 0, errNotConnected
+	}
+	return s.conn.Write(p)
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package telnets implements the Winlink telnet transport over TLS.
+//
+// The login procedure is the same as for plain telnet (callsign and password prompts), but the
+// TCP connection is wrapped in TLS so that the password is never sent in the clear.
+package telnets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+const (
+	dialTimeout  = 30 * time.Second
+	loginTimeout = 30 * time.Second
+	maxPromptLen = 4096 // Max number of bytes read while waiting for a login prompt.
+)
+
+func init() {
+	transport.RegisterDialer("telnets", dialer{})
+}
+
+type dialer struct{}
+
+// DialURL dials the telnets URL.
+//
+// The URL parameter ?insecure=true disables certificate verification (e.g. for P2P endpoints with
+// self-signed certificates).
+func (dialer) DialURL(url *transport.URL) (net.Conn, error) {
+	var insecure bool
+	if v := url.Params.Get("insecure"); v != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("Invalid insecure parameter: %s", v)
+		}
+	}
+	password, _ := url.User.Password()
+	return Dial(url.Host, url.User.Username(), password, insecure)
+}
+
+// Conn is a logged in telnets connection.
+type Conn struct {
+	*tls.Conn
+	banner string
+}
+
+// Banner returns the text sent by the server before the login prompt (e.g. the server software and
+// version), if any.
+func (c *Conn) Banner() string { return c.banner }
+
+// Dial connects to the telnet server at addr over TLS, and logs in with the given callsign and password.
+//
+// The server certificate is verified unless insecure is true.
+func Dial(addr, mycall, password string, insecure bool) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tcp, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(tcp, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := conn.Handshake(); err != nil {
+		tcp.Close()
+		if isCertificateError(err) {
+			return nil, fmt.Errorf("TLS certificate verification failed for %s: %s (set insecure=true to skip verification)", addr, err)
+		}
+		return nil, fmt.Errorf("TLS handshake failed: %s", err)
+	}
+
+	banner, err := login(conn, mycall, password)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Login failed: %s", err)
+	}
+	return &Conn{conn, banner}, nil
+}
+
+func isCertificateError(err error) bool {
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+		return true
+	default:
+		return false
+	}
+}
+
+// login logs in with the given callsign and password, and returns the banner sent before the callsign prompt.
+func login(conn net.Conn, mycall, password string) (banner string, err error) {
+	conn.SetDeadline(time.Now().Add(loginTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	banner, err = waitPrompt(conn, "callsign")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\r", mycall); err != nil {
+		return "", err
+	}
+	if _, err := waitPrompt(conn, "password"); err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(conn, "%s\r", password)
+	return banner, err
+}
+
+// waitPrompt reads from conn until a line ending with the given prompt (followed by ':') is received.
+//
+// The non-empty lines received before the prompt are returned, joined by a single space.
+// The conn is read one byte at a time, so that nothing following the prompt is consumed.
+func waitPrompt(conn net.Conn, prompt string) (preceding string, err error) {
+	var lines []string
+	var line []byte
+	b := make([]byte, 1)
+	for n := 0; n < maxPromptLen; n++ {
+		if _, err := conn.Read(b); err != nil {
+			return "", fmt.Errorf("waiting for %s prompt: %s", prompt, err)
+		}
+		switch b[0] {
+		case '\r', '\n':
+			if str := strings.TrimSpace(string(line)); str != "" {
+				lines = append(lines, str)
+			}
+			line = line[:0]
+			continue
+		}
+		line = append(line, b[0])
+
+		str := strings.ToLower(strings.TrimSpace(string(line)))
+		if strings.HasSuffix(str, ":") && strings.Contains(str, prompt) {
+			return strings.Join(lines, " "), nil
+		}
+	}
+	return "", fmt.Errorf("%s prompt not received", prompt)
+}
@@ -0,0 +1,690 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package vara provides a client for the VARA HF and VARA FM modems' TCP interface.
+//
+// The modem exposes two TCP ports: the command port (e.g. 8300) and the data port (command port + 1).
+package vara
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+var (
+	ErrModemClosed          = errors.New("Modem closed")
+	ErrConnectInProgress    = errors.New("A connect is in progress")
+	ErrActiveListenerExists = errors.New("An active listener is already registered with this modem")
+	ErrCommandTimeout       = errors.New("Timeout waiting for modem response")
+	ErrRejected             = errors.New("Command rejected by modem")
+	ErrNoSNR                = errors.New("No SNR reported")
+)
+
+const cmdTimeout = 10 * time.Second
+
+// The number of unsolicited messages buffered for AsyncMessages. Further messages are dropped until they are read.
+const asyncBuffer = 16
+
+// Modem is a connection to a VARA modem.
+type Modem struct {
+	scheme string // The transport scheme this modem is registered as (e.g. "varahf" or "varafm").
+	mycall string
+
+	cmdConn  net.Conn
+	dataConn net.Conn
+
+	cmdMu     sync.Mutex  // Serializes commands.
+	responses chan string // Synchronous command responses (OK, WRONG, VERSION ...).
+	async     chan string // Unsolicited state and fault messages (see AsyncMessages).
+
+	mu       sync.Mutex
+	closed   bool
+	busy     bool
+	buffer   int
+	snr      *float64 // The last SNR (dB) reported during the active (or most recent) session, if any.
+	ptt      transport.PTTController
+	dialing  chan dialResult // Non-nil while a dial is in progress.
+	listener *listener       // The active listener, if any.
+	session  *Conn           // The active session, if any.
+	heard    map[string]time.Time
+}
+
+type dialResult struct {
+	conn *Conn
+	err  error
+}
+
+// Open connects to the VARA modem's command and data port and sets mycall.
+//
+// The scheme is the name of the transport this modem is registered with (e.g. "varahf").
+func Open(scheme, addr, mycall string) (*Modem, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cmdPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port: %s", err)
+	}
+
+	cmdConn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	dataConn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(cmdPort+1)), 30*time.Second)
+	if err != nil {
+		cmdConn.Close()
+		return nil, err
+	}
+
+	m := newModem(scheme, mycall, cmdConn, dataConn)
+	if err := m.cmd("MYCALL " + mycall); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("Unable to set mycall: %s", err)
+	}
+	return m, nil
+}
+
+// newModem returns a Modem communicating over the given command and data connections.
+func newModem(scheme, mycall string, cmdConn, dataConn net.Conn) *Modem {
+	m := &Modem{
+		scheme:    scheme,
+		mycall:    mycall,
+		cmdConn:   cmdConn,
+		dataConn:  dataConn,
+		responses: make(chan string, 1),
+		async:     make(chan string, asyncBuffer),
+		heard:     make(map[string]time.Time),
+	}
+	go m.cmdLoop()
+	go m.dataLoop()
+	return m
+}
+
+func debugEnabled() bool { return os.Getenv("vara_debug") != "" }
+
+// Close closes the connection to the modem. Any active session or listener is closed.
+func (m *Modem) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	session, ln := m.session, m.listener
+	m.mu.Unlock()
+
+	if session != nil {
+		session.end()
+	}
+	if ln != nil {
+		ln.end()
+	}
+
+	m.dataConn.Close()
+	return m.cmdConn.Close()
+}
+
+// SetPTT sets the PTT controller used to key the transmitter when the modem requests it.
+func (m *Modem) SetPTT(ptt transport.PTTController) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ptt = ptt
+}
+
+// SetBandwidth sets the bandwidth used by VARA HF (500, 2300 or 2750).
+func (m *Modem) SetBandwidth(bw int) error {
+	switch bw {
+	case 500, 2300, 2750:
+	default:
+		return fmt.Errorf("Unsupported bandwidth %d (valid values are 500, 2300 and 2750)", bw)
+	}
+	return m.cmd(fmt.Sprintf("BW%d", bw))
+}
+
+// SetWide selects the wide or narrow mode of VARA FM.
+func (m *Modem) SetWide(wide bool) error {
+	if wide {
+		return m.cmd("FM WIDE")
+	}
+	return m.cmd("FM NARROW")
+}
+
+// Busy returns true if the modem reports that the channel is busy.
+//
+// Implements transport.BusyChannelChecker.
+func (m *Modem) Busy() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.busy
+}
+
+// Idle returns true if the modem is not connected or connecting.
+func (m *Modem) Idle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.session == nil && m.dialing == nil
+}
+
+// SNR returns the last signal-to-noise ratio (dB) reported by the modem during the active session, or during the
+// most recent session while idle.
+//
+// Returns ErrNoSNR if none has been reported since the last session was established.
+func (m *Modem) SNR() (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snr == nil {
+		return 0, ErrNoSNR
+	}
+	return *m.snr, nil
+}
+
+// AsyncMessages returns the unsolicited state and fault messages from the modem (e.g. CONNECTED, DISCONNECTED and
+// MISSING SOUNDCARD). Busy, PTT, buffer and SNR reports are not included.
+//
+// The channel is closed when the modem is closed.
+func (m *Modem) AsyncMessages() <-chan string { return m.async }
+
+// Heard returns the stations heard (connected to or from) by this modem.
+func (m *Modem) Heard() map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	heard := make(map[string]time.Time, len(m.heard))
+	for k, v := range m.heard {
+		heard[k] = v
+	}
+	return heard
+}
+
+// Ping checks that the modem is alive.
+func (m *Modem) Ping() error {
+	_, err := m.Version()
+	return err
+}
+
+// Version returns the version string reported by the modem.
+func (m *Modem) Version() (string, error) {
+	resp, err := m.request("VERSION")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resp, "VERSION") {
+		return "", fmt.Errorf("Unexpected response: %s", resp)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(resp, "VERSION")), nil
+}
+
+// Abort aborts any pending connect or active session immediately (dirty disconnect).
+func (m *Modem) Abort() error { return m.cmd("ABORT") }
+
+// DialURL dials the target station given by url, waiting until the modem gives up after its retries are exhausted.
+//
+// Implements transport.Dialer.
+func (m *Modem) DialURL(url *transport.URL) (net.Conn, error) {
+	return m.DialURLContext(context.Background(), url)
+}
+
+// DialURLContext dials the target station given by url.
+//
+// The connect is aborted if ctx is done before the connection is established.
+func (m *Modem) DialURLContext(ctx context.Context, url *transport.URL) (net.Conn, error) {
+	if url.Scheme != m.scheme {
+		return nil, fmt.Errorf("Unsupported scheme '%s'", url.Scheme)
+	}
+
+	m.mu.Lock()
+	switch {
+	case m.closed:
+		m.mu.Unlock()
+		return nil, ErrModemClosed
+	case m.dialing != nil || m.session != nil:
+		m.mu.Unlock()
+		return nil, ErrConnectInProgress
+	}
+	dialing := make(chan dialResult, 1)
+	m.dialing = dialing
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		if m.dialing == dialing {
+			m.dialing = nil
+		}
+		m.mu.Unlock()
+	}()
+
+	mycall := m.mycall
+	if url.User != nil && url.User.Username() != "" && !strings.EqualFold(url.User.Username(), m.mycall) {
+		// Register the alternate callsign (e.g. radio-only -T) as well
+		mycall = url.User.Username()
+		if err := m.cmd(fmt.Sprintf("MYCALL %s %s", m.mycall, mycall)); err != nil {
+			return nil, fmt.Errorf("Unable to set mycall: %s", err)
+		}
+		defer m.cmd("MYCALL " + m.mycall)
+	}
+
+	cmd := fmt.Sprintf("CONNECT %s %s", mycall, url.Target)
+	if len(url.Digis) > 0 {
+		cmd += " VIA " + strings.Join(url.Digis, " ")
+	}
+	if err := m.cmd(cmd); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-dialing:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res.conn, nil
+	case <-ctx.Done():
+	}
+
+	m.Abort()
+	// The connect might have succeeded before the modem got the abort
+	select {
+	case res := <-dialing:
+		if res.conn != nil {
+			res.conn.end()
+		}
+	default:
+	}
+	return nil, ctx.Err()
+}
+
+// Listen puts the modem in listen mode and returns a listener for incoming connections.
+func (m *Modem) Listen() (net.Listener, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrModemClosed
+	}
+	if m.listener != nil {
+		m.mu.Unlock()
+		return nil, ErrActiveListenerExists
+	}
+	ln := &listener{modem: m, incoming: make(chan *Conn, 1), done: make(chan struct{})}
+	m.listener = ln
+	m.mu.Unlock()
+
+	if err := m.cmd("LISTEN ON"); err != nil {
+		m.mu.Lock()
+		m.listener = nil
+		m.mu.Unlock()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// newSession creates the session conn for the CONNECTED response given as fields.
+//
+// The caller must hold m.mu.
+func (m *Modem) newSession(remoteCall string, fields []string) *Conn {
+	pr, pw := io.Pipe()
+	c := &Conn{
+		modem:      m,
+		remoteCall: remoteCall,
+		pr:         pr,
+		pw:         pw,
+		done:       make(chan struct{}),
+	}
+	if len(fields) > 3 {
+		c.bandwidth = fields[3]
+	}
+
+	m.session = c
+	m.snr = nil
+	m.heard[remoteCall] = time.Now()
+	return c
+}
+
+// send writes the given command to the modem without waiting for a response.
+func (m *Modem) send(cmd string) error {
+	if debugEnabled() {
+		log.Printf("[vara] --> %s", cmd)
+	}
+	_, err := fmt.Fprintf(m.cmdConn, "%s\r", cmd)
+	return err
+}
+
+// request sends the given command and returns the modem's response.
+func (m *Modem) request(cmd string) (string, error) {
+	m.cmdMu.Lock()
+	defer m.cmdMu.Unlock()
+
+	// Discard any stale response
+	select {
+	case <-m.responses:
+	default:
+	}
+
+	if err := m.send(cmd); err != nil {
+		return "", err
+	}
+	select {
+	case resp, ok := <-m.responses:
+		if !ok {
+			return "", ErrModemClosed
+		}
+		return resp, nil
+	case <-time.After(cmdTimeout):
+		return "", ErrCommandTimeout
+	}
+}
+
+// cmd sends the given command and waits for the modem to accept it.
+func (m *Modem) cmd(cmd string) error {
+	resp, err := m.request(cmd)
+	switch {
+	case err != nil:
+		return err
+	case resp == "WRONG":
+		return ErrRejected
+	default:
+		return nil
+	}
+}
+
+// cmdLoop reads and handles messages from the modem's command port.
+func (m *Modem) cmdLoop() {
+	defer close(m.async)
+	defer close(m.responses)
+
+	rd := bufio.NewReader(m.cmdConn)
+	for {
+		line, err := rd.ReadString('\r')
+		if err != nil {
+			m.Close()
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if debugEnabled() {
+			log.Printf("[vara] <-- %s", line)
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "OK", "WRONG", "VERSION":
+			select {
+			case m.responses <- line:
+			default: // Nobody is waiting for it
+			}
+		case "BUSY":
+			m.mu.Lock()
+			m.busy = len(fields) > 1 && fields[1] == "ON"
+			m.mu.Unlock()
+		case "PTT":
+			m.mu.Lock()
+			ptt := m.ptt
+			m.mu.Unlock()
+			if ptt != nil {
+				if err := ptt.SetPTT(len(fields) > 1 && fields[1] == "ON"); err != nil {
+					log.Printf("Unable to set PTT: %s", err)
+				}
+			}
+		case "BUFFER":
+			if len(fields) > 1 {
+				n, _ := strconv.Atoi(fields[1])
+				m.mu.Lock()
+				m.buffer = n
+				m.mu.Unlock()
+			}
+		case "SN":
+			if len(fields) > 1 {
+				if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+					m.mu.Lock()
+					m.snr = &v
+					m.mu.Unlock()
+				}
+			}
+		case "CONNECTED":
+			m.handleConnected(fields, line)
+			m.asyncMessage(line)
+		case "DISCONNECTED":
+			m.handleDisconnected(line)
+			m.asyncMessage(line)
+		case "IAMALIVE": // Keep-alive
+		default: // E.g. MISSING SOUNDCARD and LINK REGISTERED
+			m.asyncMessage(line)
+		}
+	}
+}
+
+// asyncMessage forwards the message to AsyncMessages, or drops it if the buffer is full.
+func (m *Modem) asyncMessage(line string) {
+	select {
+	case m.async <- line:
+	default:
+	}
+}
+
+func (m *Modem) handleConnected(fields []string, line string) {
+	if len(fields) < 3 {
+		log.Printf("Unexpected message from VARA modem: %s", line)
+		return
+	}
+
+	m.mu.Lock()
+	dialing, ln := m.dialing, m.listener
+	if dialing != nil {
+		// Outbound: CONNECTED <mycall> <remote> [bandwidth]
+		m.dialing = nil
+		dialing <- dialResult{conn: m.newSession(fields[2], fields)}
+		m.mu.Unlock()
+		return
+	}
+	if ln == nil {
+		m.mu.Unlock()
+		go m.Abort() // We didn't ask for this
+		return
+	}
+
+	// Inbound: CONNECTED <remote> <mycall> [bandwidth]
+	c := m.newSession(fields[1], fields)
+	m.mu.Unlock()
+
+	// Never block the command loop waiting for Accept. The modem serves one session at a time, so the
+	// buffer is only full if a previous session is still waiting to be accepted.
+	select {
+	case <-ln.done:
+	default:
+		select {
+		case ln.incoming <- c:
+			return
+		default:
+		}
+	}
+	c.end()
+	go m.Abort()
+}
+
+func (m *Modem) handleDisconnected(line string) {
+	m.mu.Lock()
+	dialing, session := m.dialing, m.session
+	m.dialing, m.session = nil, nil
+	m.mu.Unlock()
+
+	if dialing != nil {
+		dialing <- dialResult{err: fmt.Errorf("Connect failed: %s", line)}
+	}
+	if session != nil {
+		session.end()
+	}
+}
+
+// dataLoop forwards data from the modem's data port to the active session.
+func (m *Modem) dataLoop() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := m.dataConn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		session := m.session
+		m.mu.Unlock()
+		if session == nil {
+			continue // Discard
+		}
+		session.pw.Write(buf[:n])
+	}
+}
+
+// Addr is a VARA network address (callsign).
+type Addr struct {
+	network string
+	call    string
+}
+
+func (a Addr) Network() string { return a.network }
+func (a Addr) String() string  { return a.call }
+
+// Conn is a VARA session.
+type Conn struct {
+	modem      *Modem
+	remoteCall string
+	bandwidth  string
+
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	once sync.Once
+	done chan struct{}
+}
+
+// end marks the session as ended, causing pending and future reads to return io.EOF.
+func (c *Conn) end() {
+	c.once.Do(func() {
+		c.pw.CloseWithError(io.EOF)
+		close(c.done)
+	})
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.pr.Read(p) }
+
+func (c *Conn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+		return c.modem.dataConn.Write(p)
+	}
+}
+
+// TxBufferLen returns the number of bytes not yet transmitted by the modem.
+//
+// Implements transport.TxBuffer.
+func (c *Conn) TxBufferLen() int {
+	c.modem.mu.Lock()
+	defer c.modem.mu.Unlock()
+	return c.modem.buffer
+}
+
+// Flush blocks until the modem's transmit buffer is empty, or the session ends.
+//
+// Implements transport.Flusher.
+func (c *Conn) Flush() error {
+	for c.TxBufferLen() > 0 {
+		select {
+		case <-c.done:
+			return io.EOF
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Close flushes the transmit buffer and disconnects gracefully, falling back to abort if the remote does not respond.
+func (c *Conn) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+
+	c.Flush()
+	if err := c.modem.cmd("DISCONNECT"); err != nil {
+		return err
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-time.After(time.Minute):
+		c.modem.Abort()
+		c.end()
+		return errors.New("Disconnect timeout, aborted")
+	}
+}
+
+// Bandwidth returns the bandwidth reported by the modem when the session was established.
+func (c *Conn) Bandwidth() string { return c.bandwidth }
+
+// RemoteCall returns the remote station's callsign.
+func (c *Conn) RemoteCall() string { return c.remoteCall }
+
+func (c *Conn) LocalAddr() net.Addr  { return Addr{c.modem.scheme, c.modem.mycall} }
+func (c *Conn) RemoteAddr() net.Addr { return Addr{c.modem.scheme, c.remoteCall} }
+
+// Deadlines are not supported.
+func (c *Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return nil }
+
+type listener struct {
+	modem    *Modem
+	incoming chan *Conn
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (ln *listener) end() { ln.once.Do(func() { close(ln.done) }) }
+
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.incoming:
+		return c, nil
+	case <-ln.done:
+		return nil, ErrModemClosed
+	}
+}
+
+func (ln *listener) Close() error {
+	ln.end()
+	ln.modem.mu.Lock()
+	if ln.modem.listener == ln {
+		ln.modem.listener = nil
+	}
+	closed := ln.modem.closed
+	ln.modem.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	select {
+	case c := <-ln.incoming: // Never accepted
+		c.end()
+		ln.modem.Abort()
+	default:
+	}
+	return ln.modem.cmd("LISTEN OFF")
+}
+
+func (ln *listener) Addr() net.Addr { return Addr{ln.modem.scheme, ln.modem.mycall} }
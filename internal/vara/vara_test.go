@@ -0,0 +1,356 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package vara
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// fakeModem is the modem end of the command and data connections given to a Modem under test.
+type fakeModem struct {
+	cmd  net.Conn
+	data net.Conn
+	cmds chan string // The commands received, in order.
+}
+
+func newTestModem() (*Modem, *fakeModem) {
+	cmdClient, cmdModem := net.Pipe()
+	dataClient, dataModem := net.Pipe()
+	f := &fakeModem{cmd: cmdModem, data: dataModem, cmds: make(chan string, 100)}
+	go f.serve()
+	return newModem("varahf", "N0CALL", cmdClient, dataClient), f
+}
+
+// serve replies to every command like the modem would.
+func (f *fakeModem) serve() {
+	rd := bufio.NewReader(f.cmd)
+	for {
+		line, err := rd.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSuffix(line, "\r")
+		f.cmds <- cmd
+		switch cmd {
+		case "VERSION":
+			f.send("VERSION 4.7.3")
+		case "FM WIDE":
+			f.send("WRONG")
+		default:
+			f.send("OK")
+		}
+	}
+}
+
+// send sends an (unsolicited) message to the client.
+func (f *fakeModem) send(line string) { fmt.Fprintf(f.cmd, "%s\r", line) }
+
+// expect fails the test unless the next command received is want.
+func (f *fakeModem) expect(t *testing.T, want string) {
+	select {
+	case got := <-f.cmds:
+		if got != want {
+			t.Fatalf("Got command %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for command %q", want)
+	}
+}
+
+type fakePTT struct {
+	mu sync.Mutex
+	on bool
+}
+
+func (p *fakePTT) SetPTT(on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.on = on
+	return nil
+}
+
+func TestModemMessages(t *testing.T) {
+	tests := []struct {
+		lines  []string
+		busy   bool
+		buffer int
+		ptt    bool
+	}{
+		{[]string{"BUSY ON"}, true, 0, false},
+		{[]string{"BUSY ON", "BUSY OFF"}, false, 0, false},
+		{[]string{"BUFFER 1024"}, false, 1024, false},
+		{[]string{"BUFFER 1024", "BUFFER 0"}, false, 0, false},
+		{[]string{"PTT ON"}, false, 0, true},
+		{[]string{"PTT ON", "PTT OFF"}, false, 0, false},
+		{[]string{"IAMALIVE", "CONNECTED", "REGISTERED N0CALL"}, false, 0, false}, // Ignored
+	}
+	for _, tt := range tests {
+		m, f := newTestModem()
+		ptt := &fakePTT{}
+		m.SetPTT(ptt)
+		for _, line := range tt.lines {
+			f.send(line)
+		}
+
+		// The messages are handled in order, so they are all handled once the response is received.
+		if v, err := m.Version(); err != nil || v != "4.7.3" {
+			t.Fatalf("%q: Version() = %q, %v", tt.lines, v, err)
+		}
+		m.mu.Lock()
+		busy, buffer := m.busy, m.buffer
+		m.mu.Unlock()
+		if busy != tt.busy || m.Busy() != tt.busy {
+			t.Errorf("%q: got busy %t, want %t", tt.lines, busy, tt.busy)
+		}
+		if buffer != tt.buffer {
+			t.Errorf("%q: got buffer %d, want %d", tt.lines, buffer, tt.buffer)
+		}
+		if ptt.on != tt.ptt {
+			t.Errorf("%q: got PTT %t, want %t", tt.lines, ptt.on, tt.ptt)
+		}
+		m.Close()
+	}
+}
+
+func TestModemSNR(t *testing.T) {
+	tests := []struct {
+		lines []string
+		snr   float64
+		err   error
+	}{
+		{nil, 0, ErrNoSNR},
+		{[]string{"SN 7.5"}, 7.5, nil},
+		{[]string{"SN 7.5", "SN -3.2"}, -3.2, nil},
+		{[]string{"SN 7.5", "SN garbage"}, 7.5, nil},
+		{[]string{"SN 7.5", "DISCONNECTED"}, 7.5, nil}, // Kept after the session
+	}
+	for _, tt := range tests {
+		m, f := newTestModem()
+		for _, line := range tt.lines {
+			f.send(line)
+		}
+		if _, err := m.Version(); err != nil {
+			t.Fatalf("%q: Version() = %v", tt.lines, err)
+		}
+		if snr, err := m.SNR(); snr != tt.snr || err != tt.err {
+			t.Errorf("%q: SNR() = %v, %v, want %v, %v", tt.lines, snr, err, tt.snr, tt.err)
+		}
+		m.Close()
+	}
+}
+
+func TestModemAsyncMessages(t *testing.T) {
+	m, f := newTestModem()
+	for _, line := range []string{"MISSING SOUNDCARD", "BUSY ON", "PTT OFF", "SN 3.0", "IAMALIVE", "LINK REGISTERED"} {
+		f.send(line)
+	}
+	if _, err := m.Version(); err != nil {
+		t.Fatalf("Version() = %v", err)
+	}
+	m.Close()
+
+	var got []string
+	for msg := range m.AsyncMessages() {
+		got = append(got, msg)
+	}
+	if want := []string{"MISSING SOUNDCARD", "LINK REGISTERED"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestModemCommands(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	if err := m.SetBandwidth(2300); err != nil {
+		t.Errorf("SetBandwidth(2300): unexpected error: %s", err)
+	}
+	f.expect(t, "BW2300")
+
+	if err := m.SetBandwidth(1000); err == nil {
+		t.Error("SetBandwidth(1000): expected error")
+	}
+
+	if err := m.SetWide(true); err != ErrRejected {
+		t.Errorf("SetWide(true): got error %v, want %v", err, ErrRejected)
+	}
+	f.expect(t, "FM WIDE")
+
+	if err := m.Abort(); err != nil {
+		t.Errorf("Abort(): unexpected error: %s", err)
+	}
+	f.expect(t, "ABORT")
+}
+
+func TestModemDial(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	url, _ := transport.ParseURL("varahf:///LA1B")
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := m.DialURL(url)
+		done <- result{conn, err}
+	}()
+
+	f.expect(t, "CONNECT N0CALL LA1B")
+	if m.Idle() {
+		t.Error("Idle() while dialing")
+	}
+	f.send("CONNECTED N0CALL LA1B 2300")
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("DialURL: unexpected error: %s", res.err)
+	}
+	conn := res.conn.(*Conn)
+	if conn.RemoteCall() != "LA1B" || conn.Bandwidth() != "2300" {
+		t.Errorf("Got remote call %q and bandwidth %q", conn.RemoteCall(), conn.Bandwidth())
+	}
+	if _, ok := m.Heard()["LA1B"]; !ok {
+		t.Error("LA1B not heard")
+	}
+
+	go f.data.Write([]byte("[WL2K-5.0-B2FWIHJM$]\r"))
+	rd := bufio.NewReader(conn)
+	if line, err := rd.ReadString('\r'); err != nil || line != "[WL2K-5.0-B2FWIHJM$]\r" {
+		t.Errorf("Read: got %q, %v", line, err)
+	}
+
+	go conn.Write([]byte("FF\r"))
+	if line, err := bufio.NewReader(f.data).ReadString('\r'); err != nil || line != "FF\r" {
+		t.Errorf("Got data %q, %v", line, err)
+	}
+
+	f.send("DISCONNECTED")
+	if _, err := rd.ReadByte(); err != io.EOF {
+		t.Errorf("Read after DISCONNECTED: got error %v, want %v", err, io.EOF)
+	}
+	if _, err := conn.Write([]byte("FF\r")); err == nil {
+		t.Error("Write after DISCONNECTED: expected error")
+	}
+	if !m.Idle() {
+		t.Error("Not idle after DISCONNECTED")
+	}
+}
+
+func TestModemDialFailed(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	url, _ := transport.ParseURL("varahf:///LA1B")
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.DialURL(url)
+		done <- err
+	}()
+
+	f.expect(t, "CONNECT N0CALL LA1B")
+	f.send("DISCONNECTED")
+	if err := <-done; err == nil {
+		t.Error("DialURL: expected error")
+	}
+	if !m.Idle() {
+		t.Error("Not idle after failed connect")
+	}
+
+	if _, err := m.DialURL(&transport.URL{Scheme: "varafm", Target: "LA1B"}); err == nil {
+		t.Error("DialURL: expected error for other scheme")
+	}
+}
+
+func TestModemDialContext(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	url, _ := transport.ParseURL("varahf:///LA1B")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.DialURLContext(ctx, url); err != context.DeadlineExceeded {
+		t.Errorf("DialURLContext: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	f.expect(t, "CONNECT N0CALL LA1B")
+	f.expect(t, "ABORT")
+	if !m.Idle() {
+		t.Error("Not idle after connect timeout")
+	}
+}
+
+func TestModemAccept(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	ln, err := m.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.expect(t, "LISTEN ON")
+	if _, err := m.Listen(); err != ErrActiveListenerExists {
+		t.Errorf("Listen: got error %v, want %v", err, ErrActiveListenerExists)
+	}
+
+	f.send("CONNECTED LA1B N0CALL 500")
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call := conn.(*Conn).RemoteCall(); call != "LA1B" {
+		t.Errorf("Got remote call %q, want %q", call, "LA1B")
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %s", err)
+	}
+	f.expect(t, "LISTEN OFF")
+	if _, err := ln.Accept(); err != ErrModemClosed {
+		t.Errorf("Accept after Close: got error %v, want %v", err, ErrModemClosed)
+	}
+}
+
+func TestModemIncomingNotAccepted(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	ln, err := m.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.expect(t, "LISTEN ON")
+
+	// Nobody is calling Accept, which must not stall the responses to later commands.
+	f.send("CONNECTED LA1B N0CALL 500")
+	if _, err := m.Version(); err != nil {
+		t.Fatalf("Version: unexpected error: %s", err)
+	}
+	f.expect(t, "VERSION")
+
+	// The unaccepted session is aborted when the listener is closed.
+	if err := ln.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %s", err)
+	}
+	f.expect(t, "ABORT")
+	f.expect(t, "LISTEN OFF")
+}
+
+func TestModemIncomingWithoutListener(t *testing.T) {
+	m, f := newTestModem()
+	defer m.Close()
+
+	f.send("CONNECTED LA1B N0CALL 500")
+	f.expect(t, "ABORT")
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The interval between link quality readings during a session.
+const linkSampleInterval = 2 * time.Second
+
+// LinkReading summarizes the SNR readings of a session.
+type LinkReading struct {
+	Min  float64 `json:"min"`
+	Avg  float64 `json:"avg"`
+	Last float64 `json:"last"`
+
+	n   int
+	sum float64
+}
+
+func (r *LinkReading) add(v float64) {
+	if r.n == 0 || v < r.Min {
+		r.Min = v
+	}
+	r.n++
+	r.sum += v
+	r.Avg, r.Last = r.sum/float64(r.n), v
+}
+
+// LinkQuality is the live link quality of the session in progress, as sent to the Web GUI.
+type LinkQuality struct {
+	SNR float64 `json:"snr"` // dB.
+}
+
+// linkSource returns the SNR reporter of the modem used by the connection, if any.
+//
+// Only the VARA modems report SNR.
+func linkSource(conn net.Conn) snrReporter {
+	var modem interface{} = conn
+	switch conn.RemoteAddr().Network() {
+	case MethodVaraHF:
+		modem = varaHFTNC
+	case MethodVaraFM:
+		modem = varaFMTNC
+	}
+	snr, _ := modem.(snrReporter)
+	return snr
+}
+
+// linkMonitor samples the SNR during a session, and forwards the live readings to the Web GUI.
+type linkMonitor struct {
+	mu  sync.Mutex
+	snr *LinkReading
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// monitorLink starts sampling the link quality of the connection. Returns nil if the modem reports nothing.
+func monitorLink(conn net.Conn) *linkMonitor {
+	snr := linkSource(conn)
+	if snr == nil {
+		return nil
+	}
+	m := &linkMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(linkSampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+				m.sample(snr)
+			}
+		}
+	}()
+	return m
+}
+
+func (m *linkMonitor) sample(snr snrReporter) {
+	v, err := snr.SNR()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	if m.snr == nil {
+		m.snr = new(LinkReading)
+	}
+	m.snr.add(v)
+	m.mu.Unlock()
+	websocketHub.WriteLinkQuality(LinkQuality{SNR: v})
+}
+
+// Stop stops sampling, and returns the SNR readings (nil if none).
+func (m *linkMonitor) Stop() *LinkReading {
+	if m == nil {
+		return nil
+	}
+	close(m.stop)
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snr
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// snrSequence is an snrReporter returning the given readings in turn (NaN meaning no reading).
+type snrSequence []float64
+
+func (s *snrSequence) SNR() (float64, error) {
+	v := (*s)[0]
+	*s = (*s)[1:]
+	if math.IsNaN(v) {
+		return 0, errors.New("No SNR reported")
+	}
+	return v, nil
+}
+
+func TestLinkMonitorSample(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		readings snrSequence
+		want     *LinkReading
+	}{
+		{snrSequence{nan}, nil},
+		{snrSequence{4}, &LinkReading{Min: 4, Avg: 4, Last: 4, n: 1, sum: 4}},
+		{snrSequence{4, nan, -2, 7}, &LinkReading{Min: -2, Avg: 3, Last: 7, n: 3, sum: 9}},
+	}
+	for _, tt := range tests {
+		m := &linkMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+		close(m.done) // Sampled by the test rather than the ticker.
+		n := len(tt.readings)
+		for i := 0; i < n; i++ {
+			m.sample(&tt.readings)
+		}
+		if got := m.Stop(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Got %+v, want %+v", got, tt.want)
+		}
+	}
+}
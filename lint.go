@@ -0,0 +1,125 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/la5nta/wl2k-go/transport"
+	"github.com/la5nta/wl2k-go/transport/ardop"
+)
+
+func lintHandle(args []string) {
+	if len(args) == 0 {
+		for alias := range config.ConnectAliases {
+			args = append(args, alias)
+		}
+		sort.Strings(args)
+	}
+	if len(args) == 0 {
+		fmt.Println("No connect aliases to check.")
+		return
+	}
+
+	var failed int
+	for _, connectStr := range args {
+		url, errs := lintConnectStr(connectStr)
+		if len(errs) == 0 {
+			fmt.Printf("PASS  %s (%s)\n", connectStr, url)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s\n", connectStr)
+		for _, err := range errs {
+			fmt.Printf("      - %s\n", err)
+		}
+	}
+	fmt.Printf("\n%d of %d connect string(s) failed.\n", failed, len(args))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// lintConnectStr validates connectStr without dialing or touching the TNC or rig.
+//
+// The checks are: the connect string resolves (see ResolveConnectURL), the scheme is known, the config
+// required by the transport is present, the rig reference resolves and URL parameters parse.
+// The resolved URL is returned with all problems found.
+func lintConnectStr(connectStr string) (urlStr string, errs []error) {
+	if str, err := resolveAlias(config.ConnectAliases, connectStr); err == nil && isRMSConnectStr(str) {
+		return str + ", channels resolved at connect time", nil
+	}
+
+	url, err := ResolveConnectURL(connectStr)
+	if err != nil {
+		return "", []error{err}
+	}
+
+	switch url.Scheme {
+	case MethodTelnet, MethodTelnets:
+		if url.Host == "" {
+			errs = append(errs, fmt.Errorf("Missing host"))
+		}
+	case MethodAX25, MethodSerialTNC, MethodAGWPE, MethodKISSTCP:
+		if url.Host == "" {
+			errs = append(errs, fmt.Errorf("Missing %s port/address in URL and config", url.Scheme))
+		}
+	case MethodArdop, MethodWinmor, MethodVaraHF, MethodVaraFM, MethodPactor:
+		if _, err := tncStatus(url); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Unknown transport '%s'", url.Scheme))
+	}
+
+	if name := url.Params.Get("rig"); name != "" && url.Params.Get("freq") == "" {
+		if _, ok := config.HamlibRigs[name]; !ok {
+			errs = append(errs, fmt.Errorf("Rig '%s' is not defined in hamlib_rigs", name))
+		}
+	}
+	if freq := url.Params.Get("freq"); freq != "" {
+		errs = append(errs, lintFreq(url, freq)...)
+	}
+
+	if str := url.Params.Get("bw"); str != "" && url.Scheme == MethodArdop {
+		if _, err := ardop.BandwidthFromString(str); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid ARQ bandwidth '%s': %s", str, err))
+		}
+	}
+	if _, err := busyTimeout(url); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := busyClearWindow(url); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := connectTimeout(url); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := connectRetries(url); err != nil {
+		errs = append(errs, err)
+	}
+	return url.String(), errs
+}
+
+// lintFreq checks that the freq parameter parses, and that the transport's rig reference resolves to a
+// configured rig.
+func lintFreq(url *transport.URL, freq string) (errs []error) {
+	if _, err := ParseFrequency(freq); err != nil {
+		errs = append(errs, err)
+	}
+
+	rigName, _, err := qsyRig(url)
+	switch rig, ok := config.HamlibRigs[rigName]; {
+	case rigName == "":
+		errs = append(errs, fmt.Errorf("Unable to QSY: %s", err))
+	case !ok:
+		errs = append(errs, fmt.Errorf("Rig '%s' is not defined in hamlib_rigs", rigName))
+	case rig.Address == "":
+		errs = append(errs, fmt.Errorf("Missing address-field for rig '%s'", rigName))
+	}
+	return errs
+}
@@ -5,49 +5,121 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/la5nta/wl2k-go/transport"
 	"github.com/la5nta/wl2k-go/transport/ax25"
 	"github.com/la5nta/wl2k-go/transport/telnet"
+
+	"github.com/la5nta/pat/internal/kiss"
 )
 
 func Unlisten(param string) {
-	methods := strings.FieldsFunc(param, SplitFunc)
-	for _, method := range methods {
-		ok, err := listenHub.Disable(method)
-		if err != nil {
-			log.Printf("Unable to close %s listener: %s", method, err)
-		} else if !ok {
-			log.Printf("No active %s listener, ignoring.\n", method)
-		}
-	}
+	listenHub.Stop(strings.FieldsFunc(param, SplitFunc)...)
 }
 
 func Listen(listenStr string) {
-	methods := strings.FieldsFunc(listenStr, SplitFunc)
-	for _, method := range methods {
-		switch strings.ToLower(method) {
-		case MethodWinmor:
-			listenHub.Enable(WINMORListener{})
-		case MethodArdop:
-			listenHub.Enable(ARDOPListener{})
-		case MethodTelnet:
-			listenHub.Enable(TelnetListener{})
-		case MethodAX25:
-			listenHub.Enable(&AX25Listener{})
-		case MethodSerialTNC:
-			log.Printf("%s listen not implemented, ignoring.", method)
-		default:
-			log.Printf("'%s' is not a valid listen method", method)
-			return
-		}
+	if err := listenHub.Start(strings.FieldsFunc(listenStr, SplitFunc)...); err != nil {
+		log.Println(err)
+		return
 	}
 	log.Printf("Listening for incoming traffic on %s...", listenStr)
 }
 
+// errListenNotImplemented is returned by newTransportListener for transports without listen support.
+var errListenNotImplemented = errors.New("listen not implemented")
+
+// newTransportListener returns the transport listener of the given listen method (e.g. ardop, ardop:name or telnet).
+func newTransportListener(method string) (TransportListener, error) {
+	if strings.HasPrefix(strings.ToLower(method), MethodArdop+":") {
+		return ARDOPListener{Instance: method[len(MethodArdop)+1:]}, nil
+	}
+
+	switch strings.ToLower(method) {
+	case MethodWinmor:
+		return WINMORListener{}, nil
+	case MethodArdop:
+		return ARDOPListener{}, nil
+	case MethodVaraHF, "vara":
+		return VaraHFListener{}, nil
+	case MethodVaraFM:
+		return VaraFMListener{}, nil
+	case MethodTelnet:
+		return TelnetListener{}, nil
+	case MethodAX25:
+		return &AX25Listener{}, nil
+	case MethodAGWPE:
+		return AGWPEListener{}, nil
+	case MethodKISSTCP, "kisstcp":
+		return KISSTCPListener{}, nil
+	case MethodSerialTNC:
+		return nil, errListenNotImplemented
+	default:
+		return nil, fmt.Errorf("'%s' is not a valid listen method", method)
+	}
+}
+
+// listenSoundCard returns the sound card used by the TNC of the given listener (see ArdopConfig.SoundCard),
+// or the empty string if not known.
+func listenSoundCard(name string) string {
+	url := listenURL(name)
+	switch url.Scheme {
+	case MethodWinmor:
+		return config.Winmor.SoundCard
+	case MethodArdop:
+		return ardopConfigOrDefault(url).SoundCard
+	case MethodVaraHF:
+		return config.VaraHF.SoundCard
+	case MethodVaraFM:
+		return config.VaraFM.SoundCard
+	case MethodAX25:
+		return config.AX25.SoundCard
+	case MethodAGWPE:
+		return config.AGWPE.SoundCard
+	case MethodKISSTCP:
+		return config.KISSTCP.SoundCard
+	default:
+		return ""
+	}
+}
+
+// checkSoundCards returns an error if any of the given listeners use the same sound card.
+func checkSoundCards(names []string) error {
+	users := make(map[string]string) // Listener name by sound card.
+	for _, name := range names {
+		card := listenSoundCard(name)
+		if card == "" {
+			continue
+		}
+		if other, ok := users[card]; ok && other != name {
+			return fmt.Errorf("Unable to listen on both %s and %s: both use sound card '%s'", other, name, card)
+		}
+		users[card] = name
+	}
+	return nil
+}
+
+// listenURL returns a URL selecting the transport (and TNC) used by the given listen method.
+func listenURL(method string) *transport.URL {
+	if strings.HasPrefix(strings.ToLower(method), MethodArdop+":") {
+		return &transport.URL{Scheme: MethodArdop, Params: url.Values{"tnc": {method[len(MethodArdop)+1:]}}}
+	}
+	method = strings.ToLower(method)
+	switch method {
+	case "vara":
+		method = MethodVaraHF
+	case "kisstcp":
+		method = MethodKISSTCP
+	}
+	return &transport.URL{Scheme: method, Params: url.Values{}}
+}
+
 type AX25Listener struct{ stopBeacon chan<- struct{} }
 
 func (l *AX25Listener) Init() (net.Listener, error) {
@@ -96,14 +168,73 @@ func (l *AX25Listener) beaconLoop(dur time.Duration) chan<- struct{} {
 func (l *AX25Listener) CurrentFreq() (Frequency, bool) { return 0, false }
 func (l *AX25Listener) Name() string                   { return MethodAX25 }
 
-type ARDOPListener struct{}
+type AGWPEListener struct{}
+
+func (l AGWPEListener) Name() string { return MethodAGWPE }
+func (l AGWPEListener) Init() (net.Listener, error) {
+	mycall := fOptions.MyCall
+	if config.AGWPE.Callsign != "" {
+		mycall = config.AGWPE.Callsign
+	}
+	return agwpeClient().Listen(config.AGWPE.Addr, config.AGWPE.RadioPort, mycall)
+}
+
+func (l AGWPEListener) CurrentFreq() (Frequency, bool) {
+	if rig, ok := rigs[config.AGWPE.Rig]; ok {
+		f, _ := rig.GetFreq()
+		return Frequency(f), ok
+	}
+	return 0, false
+}
+
+type KISSTCPListener struct{}
+
+func (l KISSTCPListener) Name() string { return MethodKISSTCP }
+func (l KISSTCPListener) Init() (net.Listener, error) {
+	addr := config.KISSTCP.Host
+	if addr == "" {
+		addr = kiss.DefaultAddr
+	}
+	if config.KISSTCP.Port < 0 || config.KISSTCP.Port > 15 {
+		return nil, fmt.Errorf("Invalid KISS port: %d", config.KISSTCP.Port)
+	}
+	return kiss.Listen(addr, byte(config.KISSTCP.Port), fOptions.MyCall)
+}
+
+func (l KISSTCPListener) CurrentFreq() (Frequency, bool) {
+	if rig, ok := rigs[config.KISSTCP.Rig]; ok {
+		f, _ := rig.GetFreq()
+		return Frequency(f), ok
+	}
+	return 0, false
+}
+
+// ARDOPListener listens on an ARDOP TNC. The empty Instance is the default TNC (see config.ArdopInstances).
+type ARDOPListener struct{ Instance string }
+
+func (l ARDOPListener) Name() string {
+	if l.Instance == "" {
+		return MethodArdop
+	}
+	return MethodArdop + ":" + l.Instance
+}
 
-func (l ARDOPListener) Name() string { return MethodArdop }
 func (l ARDOPListener) Init() (net.Listener, error) {
-	if err := initArdopTNC(); err != nil {
+	conf, err := ardopConfig(l.Instance)
+	if err != nil {
 		return nil, err
 	}
-	ln, err := adTNC.Listen()
+	tnc, err := openArdopTNC(conf)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.ListenBandwidth.IsZero() {
+		if err := tnc.SetARQBandwidth(conf.ListenBandwidth); err != nil {
+			return nil, fmt.Errorf("Unable to set listen bandwidth for ardop TNC: %s", err)
+		}
+		log.Printf("ARDOP listen bandwidth set to %s", conf.ListenBandwidth)
+	}
+	ln, err := tnc.Listen()
 	if err != nil {
 		return nil, err
 	}
@@ -111,7 +242,8 @@ func (l ARDOPListener) Init() (net.Listener, error) {
 }
 
 func (l ARDOPListener) CurrentFreq() (Frequency, bool) {
-	if rig, ok := rigs[config.Ardop.Rig]; ok {
+	conf, _ := ardopConfig(l.Instance)
+	if rig, ok := rigs[conf.Rig]; ok {
 		f, _ := rig.GetFreq()
 		return Frequency(f), ok
 	}
@@ -119,10 +251,54 @@ func (l ARDOPListener) CurrentFreq() (Frequency, bool) {
 }
 
 func (l ARDOPListener) BeaconStart() error {
-	return adTNC.BeaconEvery(time.Duration(config.Ardop.BeaconInterval) * time.Second)
+	conf, _ := ardopConfig(l.Instance)
+	if conf.BeaconInterval <= 0 {
+		return nil
+	}
+	tnc, err := openArdopTNC(conf)
+	if err != nil {
+		return err
+	}
+	return startArdopBeacon(l.Name(), tnc, time.Duration(conf.BeaconInterval)*time.Second)
 }
 
-func (l ARDOPListener) BeaconStop() { adTNC.BeaconEvery(0) }
+func (l ARDOPListener) BeaconStop() { stopArdopBeacon(l.Name()) }
+
+type VaraHFListener struct{}
+
+func (l VaraHFListener) Name() string { return MethodVaraHF }
+func (l VaraHFListener) Init() (net.Listener, error) {
+	if err := initVaraHFTNC(); err != nil {
+		return nil, err
+	}
+	return varaHFTNC.Listen()
+}
+
+func (l VaraHFListener) CurrentFreq() (Frequency, bool) {
+	if rig, ok := rigs[config.VaraHF.Rig]; ok {
+		f, _ := rig.GetFreq()
+		return Frequency(f), ok
+	}
+	return 0, false
+}
+
+type VaraFMListener struct{}
+
+func (l VaraFMListener) Name() string { return MethodVaraFM }
+func (l VaraFMListener) Init() (net.Listener, error) {
+	if err := initVaraFMTNC(); err != nil {
+		return nil, err
+	}
+	return varaFMTNC.Listen()
+}
+
+func (l VaraFMListener) CurrentFreq() (Frequency, bool) {
+	if rig, ok := rigs[config.VaraFM.Rig]; ok {
+		f, _ := rig.GetFreq()
+		return Frequency(f), ok
+	}
+	return 0, false
+}
 
 type WINMORListener struct{}
 
@@ -5,10 +5,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 type TransportListener interface {
@@ -30,6 +33,8 @@ type Listener struct {
 	isClosed bool
 	err      error
 	ln       net.Listener
+	pauses   int           // Number of outbound connects holding the listener paused.
+	resumed  chan struct{} // Closed when the listener is resumed (nil if not paused).
 }
 
 func NewListener(t TransportListener) *Listener { return &Listener{t: t} }
@@ -47,6 +52,11 @@ func (l *Listener) Close() error {
 		return l.err
 	}
 	l.isClosed = true
+	if l.resumed != nil {
+		close(l.resumed)
+		l.resumed = nil
+		return nil // Already closed by Pause
+	}
 
 	// If l.err is not nil, then the last attempt to open the listener failed and we don't have anything to close
 	if l.err != nil {
@@ -63,6 +73,11 @@ func (l *Listener) listenLoop() {
 			l.mu.Unlock()
 			break
 		}
+		if resumed := l.resumed; resumed != nil {
+			l.mu.Unlock()
+			<-resumed
+			continue
+		}
 
 		// Try to init the TNC
 		l.ln, l.err = l.t.Init()
@@ -89,7 +104,7 @@ func (l *Listener) listenLoop() {
 		}
 
 		// Run the accept loop until an error occures
-		if err := l.acceptLoop(); err != nil {
+		if err := l.acceptLoop(); err != nil && !l.isPaused() {
 			log.Printf("Accept %s failed: %s", l.t.Name(), err)
 		}
 
@@ -99,10 +114,57 @@ func (l *Listener) listenLoop() {
 	}
 }
 
+// Pause closes the transport listener (freeing the TNC) until Resume is called.
+//
+// Pause and Resume calls are counted, so the listener is resumed when all pauses are resumed.
+func (l *Listener) Pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.isClosed {
+		return
+	}
+	if l.pauses++; l.pauses > 1 {
+		return
+	}
+	l.resumed = make(chan struct{})
+	if l.err == nil && l.ln != nil {
+		l.ln.Close()
+	}
+}
+
+// Resume re-opens the transport listener closed by Pause.
+func (l *Listener) Resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pauses == 0 {
+		return
+	}
+	if l.pauses--; l.pauses > 0 || l.resumed == nil {
+		return
+	}
+	close(l.resumed)
+	l.resumed = nil
+}
+
+func (l *Listener) isPaused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.resumed != nil
+}
+
 type RemoteCaller interface {
 	RemoteCall() string
 }
 
+// InboundEvent describes an inbound session of a listener (see ListenerHub.Subscribe).
+type InboundEvent struct {
+	Listener string    `json:"listener"`
+	Remote   string    `json:"remote"` // The remote station's callsign.
+	What     string    `json:"what"`   // accept or disconnect.
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error,omitempty"` // The exchange error, if any (disconnect only).
+}
+
 func (l *Listener) acceptLoop() error {
 	for {
 		conn, err := l.ln.Accept()
@@ -119,24 +181,146 @@ func (l *Listener) acceptLoop() error {
 
 		eventLog.LogConn("accept", freq, conn, nil)
 		log.Printf("Got connect (%s:%s)", l.t.Name(), remoteCall)
+		l.hub.publish(InboundEvent{Listener: l.t.Name(), Remote: remoteCall, What: "accept", Time: time.Now()})
 
-		err = exchange(conn, remoteCall, true)
+		// Keep outbound connects off the TNC and rig during the session
+		release, err := tncGuard.acquire(context.Background(), listenURL(l.t.Name()), sessionInbound, 0)
+		if err != nil {
+			log.Printf("Warning: %s", err)
+			release = func() {}
+		}
+		err = withoutBeacon(l.t, func() error { return exchange(conn, remoteCall, true) })
+		release()
+		e := InboundEvent{Listener: l.t.Name(), Remote: remoteCall, What: "disconnect", Time: time.Now()}
 		if err != nil {
 			log.Printf("Exchange failed: %s", err)
+			e.Error = err.Error()
 		} else {
 			log.Println("Disconnected.")
 		}
+		l.hub.publish(e)
 	}
 }
 
+// withoutBeacon calls fn with the beacon of the transport listener (if any) stopped, so that ID frames and
+// beacons are suppressed during a session.
+func withoutBeacon(t interface{}, fn func() error) error {
+	b, ok := t.(Beaconer)
+	if !ok {
+		return fn()
+	}
+	b.BeaconStop()
+	defer func() {
+		if err := b.BeaconStart(); err != nil {
+			log.Printf("Unable to restart beacon: %s", err)
+		}
+	}()
+	return fn()
+}
+
 type ListenerHub struct {
 	mu        sync.Mutex
 	listeners map[string]*Listener
+	subs      map[chan InboundEvent]struct{}
 }
 
 func NewListenerHub() *ListenerHub {
 	return &ListenerHub{
 		listeners: map[string]*Listener{},
+		subs:      map[chan InboundEvent]struct{}{},
+	}
+}
+
+// The capacity of the channels returned by Subscribe. Events are dropped while a channel is full.
+const inboundEventBuffer = 16
+
+// Subscribe returns a channel of the inbound session events of all listeners, until cancel is called.
+func (h *ListenerHub) Subscribe() (events <-chan InboundEvent, cancel func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan InboundEvent, inboundEventBuffer)
+	h.subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (h *ListenerHub) publish(e InboundEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Start enables the listeners of the given listen methods (e.g. ardop, ardop:name or telnet).
+//
+// Nothing is started if any of the methods is invalid, or if two of the listeners (including the active ones)
+// use the same sound card. Each listener initializes its TNC the same way as
+// connects do, and re-establishes it in the background if it fails.
+func (h *ListenerHub) Start(methods ...string) error {
+	var ts []TransportListener
+	for _, method := range methods {
+		t, err := newTransportListener(method)
+		switch {
+		case err == errListenNotImplemented:
+			log.Printf("%s listen not implemented, ignoring.", method)
+			continue
+		case err != nil:
+			return err
+		}
+		ts = append(ts, t)
+	}
+
+	// Listeners run in parallel, but RF listeners can't share a sound card
+	var names []string
+	h.mu.Lock()
+	for name := range h.listeners {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+	for _, t := range ts {
+		names = append(names, t.Name())
+	}
+	if err := checkSoundCards(names); err != nil {
+		return err
+	}
+
+	for _, t := range ts {
+		h.Enable(t)
+	}
+	return nil
+}
+
+// Stop disables the listeners of the given listen methods.
+//
+// The listeners' TNCs are closed (releasing the sound device), unless in use by a connect.
+func (h *ListenerHub) Stop(methods ...string) {
+	for _, method := range methods {
+		name := method
+		if t, err := newTransportListener(method); err == nil {
+			name = t.Name()
+		}
+		ok, err := h.Disable(name)
+		switch {
+		case err != nil:
+			log.Printf("Unable to close %s listener: %s", name, err)
+		case !ok:
+			log.Printf("No active %s listener, ignoring.\n", name)
+		default:
+			log.Printf("Stopped %s listener", name)
+			tncIdle.closeIfUnused(listenURL(name))
+		}
 	}
 }
 
@@ -161,6 +345,7 @@ func (h *ListenerHub) Enable(t TransportListener) {
 		websocketHub.UpdateStatus()
 	}()
 	l := NewListener(t)
+	l.hub = h
 	if _, ok := h.listeners[t.Name()]; ok {
 		return
 	}
@@ -182,6 +367,46 @@ func (h *ListenerHub) Disable(name string) (bool, error) {
 	return true, l.Close()
 }
 
+// PauseTNC pauses the active listeners sharing the TNC or rig used by the given URL (see sessionKeys), so that
+// they can be used for an outbound connect. The listeners are resumed when the returned func is called.
+func (h *ListenerHub) PauseTNC(url *transport.URL) (resume func()) {
+	keys := sessionKeys(url)
+	if len(keys) == 0 {
+		return func() {}
+	}
+
+	h.mu.Lock()
+	var paused []*Listener
+	for name, l := range h.listeners {
+		if !sharesKey(keys, sessionKeys(listenURL(name))) {
+			continue
+		}
+		log.Printf("Pausing %s listener during outbound connect", name)
+		l.Pause()
+		paused = append(paused, l)
+	}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for _, l := range paused {
+				log.Printf("Resuming %s listener", l.t.Name())
+				l.Resume()
+			}
+		})
+	}
+}
+
+func sharesKey(a, b []string) bool {
+	for _, key := range a {
+		if containsStr(b, key) {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *ListenerHub) Close() {
 	h.mu.Lock()
 	defer func() {
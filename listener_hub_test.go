@@ -0,0 +1,40 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeBeaconer records the beacon calls, and the calls made while the beacon is stopped.
+type fakeBeaconer struct{ calls []string }
+
+func (b *fakeBeaconer) BeaconStop()        { b.calls = append(b.calls, "stop") }
+func (b *fakeBeaconer) BeaconStart() error { b.calls = append(b.calls, "start"); return nil }
+
+func TestWithoutBeacon(t *testing.T) {
+	errExchange := errors.New("exchange failed")
+	for _, want := range []error{nil, errExchange} {
+		b := &fakeBeaconer{}
+		err := withoutBeacon(b, func() error {
+			b.calls = append(b.calls, "session")
+			return want
+		})
+		if err != want {
+			t.Errorf("Got error %v, want %v", err, want)
+		}
+		if want := []string{"stop", "session", "start"}; !reflect.DeepEqual(b.calls, want) {
+			t.Errorf("Got calls %q, want %q", b.calls, want)
+		}
+	}
+
+	// Transports without a beacon
+	var called bool
+	if err := withoutBeacon(struct{}{}, func() error { called = true; return nil }); err != nil || !called {
+		t.Errorf("Got %v, %t, want the session to run", err, called)
+	}
+}
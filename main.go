@@ -8,10 +8,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
@@ -37,9 +39,14 @@ const (
 	MethodWinmor    = "winmor"
 	MethodArdop     = "ardop"
 	MethodTelnet    = "telnet"
+	MethodTelnets   = "telnets"
 	MethodAX25      = "ax25"
 	MethodSerialTNC = "serial-tnc"
+	MethodAGWPE     = "agwpe"
+	MethodKISSTCP   = "kiss-tcp"
 	MethodPactor    = "pactor"
+	MethodVaraHF    = "varahf"
+	MethodVaraFM    = "varafm"
 )
 
 var commands = []Command{
@@ -48,9 +55,24 @@ var commands = []Command{
 		Desc:       "Connect to a remote station.",
 		HandleFunc: connectHandle,
 		Usage:      UsageConnect,
+		Options: map[string]string{
+			"--parallel, -p": "Dial all connect strings concurrently and use the first to succeed.",
+			"--attempts, -n": "Number of attempts before giving up (single connect string only).",
+			"--backoff":      "Initial delay between attempts, doubled for each failed attempt. Default is 30s.",
+			"--dry-run":      "Resolve and validate the connect string(s) without initializing the TNC, touching the rig or transmitting.",
+			"--show-url":     "Print the resolved connect URL (after alias expansion and defaults) before dialing.",
+			"--from-file":    "Connect to each connect string (or alias) listed in the file (- for stdin) in turn, continuing past failures.",
+		},
 		Example:    ExampleConnect,
 		MayConnect: true,
 	},
+	{
+		Str:        "lint",
+		Desc:       "Validate connect strings without dialing.",
+		Usage:      "[alias|url...]",
+		Example:    ExampleLint,
+		HandleFunc: lintHandle,
+	},
 	{
 		Str:   "interactive",
 		Desc:  "Run interactive mode.",
@@ -157,12 +179,14 @@ var fOptions struct {
 	RadioOnly  bool
 
 	Robust       bool
+	Progress     bool
 	MyCall       string
 	Listen       string
 	MailboxPath  string
 	ConfigPath   string
 	LogPath      string
 	EventLogPath string
+	JSONLogPath  string
 }
 
 func optionsSet() *pflag.FlagSet {
@@ -171,15 +195,18 @@ func optionsSet() *pflag.FlagSet {
 	defaultMBox, _ := mailbox.DefaultMailboxPath()
 
 	set.StringVar(&fOptions.MyCall, `mycall`, ``, `Your callsign (winlink user).`)
-	set.StringVarP(&fOptions.Listen, "listen", "l", "", "Comma-separated list of methods to listen on (e.g. winmor,ardop,telnet,ax25).")
+	set.StringVarP(&fOptions.Listen, "listen", "l", "", "Comma-separated list of methods to listen on (e.g. winmor,ardop,varahf,telnet,ax25,agwpe,kiss-tcp).")
 	set.StringVar(&fOptions.MailboxPath, "mbox", defaultMBox, "Path to mailbox directory")
 	set.StringVar(&fOptions.ConfigPath, "config", fOptions.ConfigPath, "Path to config file")
 	set.StringVar(&fOptions.LogPath, "log", fOptions.LogPath, "Path to log file. The file is truncated on each startup.")
 	set.StringVar(&fOptions.EventLogPath, "event-log", fOptions.EventLogPath, "Path to event log file.")
+	set.StringVar(&fOptions.JSONLogPath, "json", "", "Write each connect as a JSON object to the given file (stdout if no file is given).")
+	set.Lookup("json").NoOptDefVal = "-"
 	set.BoolVarP(&fOptions.SendOnly, `send-only`, "s", false, `Download inbound messages later, send only.`)
 	set.BoolVarP(&fOptions.RadioOnly, `radio-only`, "", false, `Radio Only mode (Winlink Hybrid RMS only).`)
 	set.BoolVarP(&fOptions.Robust, `robust`, "r", false, `Use robust modes only. (Useful to improve s/n-ratio at remote winmor station)`)
 	set.BoolVar(&fOptions.IgnoreBusy, "ignore-busy", false, "Don't wait for clear channel before connecting to a node.")
+	set.BoolVar(&fOptions.Progress, "progress", false, "Print transfer progress periodically during exchanges.")
 
 	return set
 }
@@ -249,6 +276,31 @@ func main() {
 	if err != nil {
 		log.Fatal("Unable to open event log file:", err)
 	}
+	for i, c := range config.EventLog.Sinks {
+		sink, err := newEventSink(c)
+		if err != nil {
+			log.Printf("Unable to open event log sink #%d (%s): %s", i, c.Type, err)
+			continue
+		}
+		eventLog.AddSink(fmt.Sprintf("%s#%d", c.Type, i), sink)
+	}
+	eventLog.AddSink("reach_cache", reachCache)
+	if config.Metrics.Listen != "" {
+		eventLog.AddSink("metrics", connectMetrics)
+	}
+	if fOptions.JSONLogPath == "" {
+		fOptions.JSONLogPath = config.JSONLog
+	}
+	if fOptions.JSONLogPath != "" {
+		if fOptions.JSONLogPath != "-" {
+			if err := reachCache.Load(fOptions.JSONLogPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Unable to read connect history from JSON log file: %s", err)
+			}
+		}
+		if err := eventLog.SetJSONSink(fOptions.JSONLogPath); err != nil {
+			log.Fatal("Unable to open JSON log file:", err)
+		}
+	}
 
 	// Read command line options from config if unset
 	if fOptions.MyCall == "" && config.MyCall == "" {
@@ -283,6 +335,10 @@ func main() {
 
 	if cmd.MayConnect {
 		rigs = loadHamlibRigs()
+		connectOrder, err = NewConnectOrder(config.ConnectOrder, config.ConnectOrderPreferLast, rand.New(rand.NewSource(time.Now().UnixNano())))
+		if err != nil {
+			log.Fatal(err)
+		}
 		exchangeChan = exchangeLoop()
 
 		go func() {
@@ -298,6 +354,9 @@ func main() {
 	}
 
 	if cmd.LongLived {
+		if config.Metrics.Listen != "" {
+			go serveMetrics(config.Metrics.Listen)
+		}
 		if fOptions.Listen != "" {
 			Listen(fOptions.Listen)
 		}
@@ -369,11 +428,62 @@ func httpHandle(args []string) {
 }
 
 func connectHandle(args []string) {
-	if args[0] == "" {
+	var parallel bool
+	var attempts int
+	var backoff time.Duration
+	var dryRun bool
+	var fromFile string
+
+	set := pflag.NewFlagSet("connect", pflag.ExitOnError)
+	set.BoolVarP(&parallel, "parallel", "p", false, "")
+	set.IntVarP(&attempts, "attempts", "n", 1, "")
+	set.DurationVar(&backoff, "backoff", 30*time.Second, "")
+	set.BoolVar(&dryRun, "dry-run", false, "")
+	set.BoolVar(&showConnectURL, "show-url", false, "")
+	set.StringVar(&fromFile, "from-file", "", "")
+	set.Parse(args)
+
+	targets := set.Args()
+	if fromFile != "" {
+		var err error
+		if targets, err = readConnectTargets(fromFile); err != nil {
+			log.Fatalf("Unable to read connect targets: %s", err)
+		}
+	}
+
+	if len(targets) == 0 {
 		fmt.Println("Missing argument, try 'connect help'.")
 	}
-	if success := Connect(args[0]); !success {
-		os.Exit(1)
+
+	if dryRun {
+		var failed bool
+		for _, connectStr := range targets {
+			if err := dryRunConnect(connectStr); err != nil {
+				log.Println(err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if fromFile != "" {
+		if err := connectBatch(targets); err != nil {
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	var err error
+	if attempts > 1 && set.NArg() == 1 {
+		err = connectWithRetry(context.Background(), set.Arg(0), attempts, backoff)
+	} else {
+		_, err = connectAny(parallel, set.Args()...)
+	}
+	if err != nil {
+		os.Exit(connectExitCode(err))
 	}
 }
 
@@ -397,14 +507,19 @@ func helpHandle(args []string) {
 func cleanup() {
 	listenHub.Close()
 
+	// Close TNCs pending idle close now, so the idle timers don't fire while shutting down
+	tncIdle.closeNow()
+
+	closeAX25BusyChecker()
+
 	if wmTNC != nil {
 		if err := wmTNC.Close(); err != nil {
 			log.Fatalf("Failure to close winmor TNC: %s", err)
 		}
 	}
 
-	if adTNC != nil {
-		if err := adTNC.Close(); err != nil {
+	for _, tnc := range allArdopTNCs() {
+		if err := tnc.Close(); err != nil {
 			log.Fatalf("Failure to close ardop TNC: %s", err)
 		}
 	}
@@ -415,6 +530,18 @@ func cleanup() {
 		}
 	}
 
+	if varaHFTNC != nil {
+		if err := varaHFTNC.Close(); err != nil {
+			log.Fatalf("Failure to close VARA HF modem: %s", err)
+		}
+	}
+
+	if varaFMTNC != nil {
+		if err := varaFMTNC.Close(); err != nil {
+			log.Fatalf("Failure to close VARA FM modem: %s", err)
+		}
+	}
+
 	eventLog.Close()
 }
 
@@ -471,6 +598,9 @@ func loadHamlibRigs() map[string]hamlib.VFO {
 		}
 
 		rigs[name] = vfo
+		if cfg.Network == "tcp" {
+			rigModes[name] = rigctldMode{cfg.Address}
+		}
 	}
 	return rigs
 }
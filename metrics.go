@@ -0,0 +1,170 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// The upper bounds (seconds) of the session duration histogram buckets.
+var sessionDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// connectMetrics holds the connect metrics exposed in the Prometheus text format (see config.Metrics), fed by
+// the connect_result events of the event log. As an event sink, it is updated off the connect path.
+var connectMetrics = &metrics{
+	attempts:  make(map[metricLabels]int64),
+	bytes:     make(map[bytesLabels]int64),
+	durations: make(map[metricLabels]*histogram),
+}
+
+type metricLabels struct {
+	scheme  string
+	success bool
+}
+
+type bytesLabels struct {
+	scheme    string
+	direction string // in or out.
+}
+
+type histogram struct {
+	counts []int64 // Per bucket (see sessionDurationBuckets), not cumulative.
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range sessionDurationBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type metrics struct {
+	mu        sync.Mutex
+	attempts  map[metricLabels]int64
+	bytes     map[bytesLabels]int64
+	durations map[metricLabels]*histogram
+}
+
+// Write implements EventSink, counting each connect_result event.
+func (m *metrics) Write(e Event) error {
+	if e["what"] != "connect_result" {
+		return nil
+	}
+	scheme, _ := e["scheme"].(string)
+	success, _ := e["success"].(bool)
+	bytesIn, _ := e["bytes_in"].(int64)
+	bytesOut, _ := e["bytes_out"].(int64)
+	duration, _ := e["duration"].(float64)
+	labels := metricLabels{scheme, success}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[labels]++
+	m.bytes[bytesLabels{scheme, "in"}] += bytesIn
+	m.bytes[bytesLabels{scheme, "out"}] += bytesOut
+	if duration > 0 {
+		h, ok := m.durations[labels]
+		if !ok {
+			h = &histogram{counts: make([]int64, len(sessionDurationBuckets))}
+			m.durations[labels] = h
+		}
+		h.observe(duration)
+	}
+	return nil
+}
+
+func (m *metrics) Close() error { return nil }
+
+// writeTo writes the metrics in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pat_connect_attempts_total Number of connects (dial and exchange).")
+	fmt.Fprintln(w, "# TYPE pat_connect_attempts_total counter")
+	for _, l := range sortedLabels(m.attempts) {
+		fmt.Fprintf(w, "pat_connect_attempts_total{scheme=%q,success=\"%t\"} %d\n", l.scheme, l.success, m.attempts[l])
+	}
+
+	fmt.Fprintln(w, "# HELP pat_connect_bytes_total Bytes transferred by connects.")
+	fmt.Fprintln(w, "# TYPE pat_connect_bytes_total counter")
+	bytes := make([]bytesLabels, 0, len(m.bytes))
+	for l := range m.bytes {
+		bytes = append(bytes, l)
+	}
+	sort.Slice(bytes, func(i, j int) bool {
+		if bytes[i].scheme != bytes[j].scheme {
+			return bytes[i].scheme < bytes[j].scheme
+		}
+		return bytes[i].direction < bytes[j].direction
+	})
+	for _, l := range bytes {
+		fmt.Fprintf(w, "pat_connect_bytes_total{scheme=%q,direction=%q} %d\n", l.scheme, l.direction, m.bytes[l])
+	}
+
+	fmt.Fprintln(w, "# HELP pat_session_duration_seconds Duration of the sessions, from connected until disconnect.")
+	fmt.Fprintln(w, "# TYPE pat_session_duration_seconds histogram")
+	for _, l := range sortedLabels(m.durations) {
+		h := m.durations[l]
+		var cumulative int64
+		for i, le := range sessionDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "pat_session_duration_seconds_bucket{scheme=%q,success=\"%t\",le=%q} %d\n",
+				l.scheme, l.success, strconv.FormatFloat(le, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "pat_session_duration_seconds_bucket{scheme=%q,success=\"%t\",le=\"+Inf\"} %d\n", l.scheme, l.success, h.count)
+		fmt.Fprintf(w, "pat_session_duration_seconds_sum{scheme=%q,success=\"%t\"} %g\n", l.scheme, l.success, h.sum)
+		fmt.Fprintf(w, "pat_session_duration_seconds_count{scheme=%q,success=\"%t\"} %d\n", l.scheme, l.success, h.count)
+	}
+}
+
+// sortedLabels returns the labels (keys) of the given map (by metricLabels), sorted.
+func sortedLabels(m interface{}) []metricLabels {
+	var labels []metricLabels
+	switch m := m.(type) {
+	case map[metricLabels]int64:
+		for l := range m {
+			labels = append(labels, l)
+		}
+	case map[metricLabels]*histogram:
+		for l := range m {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].scheme != labels[j].scheme {
+			return labels[i].scheme < labels[j].scheme
+		}
+		return !labels[i].success && labels[j].success
+	})
+	return labels
+}
+
+func metricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	connectMetrics.writeTo(w)
+}
+
+// serveMetrics serves the metrics at /metrics on the given address (host:port).
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	log.Printf("Serving metrics on http://%s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Unable to serve metrics: %s", err)
+	}
+}
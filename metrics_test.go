@@ -0,0 +1,78 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	defer func(m *metrics) { connectMetrics = m }(connectMetrics)
+	connectMetrics = &metrics{
+		attempts:  make(map[metricLabels]int64),
+		bytes:     make(map[bytesLabels]int64),
+		durations: make(map[metricLabels]*histogram),
+	}
+
+	events := []Event{
+		{"what": "connect_result", "scheme": "telnet", "success": true, "duration": 25.0, "bytes_in": int64(1200), "bytes_out": int64(300)},
+		{"what": "connect_result", "scheme": "ardop", "success": true, "duration": 400.0, "bytes_in": int64(5000), "bytes_out": int64(100)},
+		{"what": "connect_result", "scheme": "ardop", "success": false, "duration": 0.0, "bytes_in": int64(0), "bytes_out": int64(0)},
+		{"what": "connect_result", "scheme": "ardop", "success": true, "duration": 7200.0, "bytes_in": int64(1000), "bytes_out": int64(0)},
+		{"what": "connect", "scheme": "ardop", "success": true}, // Ignored
+	}
+	for _, e := range events {
+		if err := connectMetrics.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `# HELP pat_connect_attempts_total Number of connects (dial and exchange).
+# TYPE pat_connect_attempts_total counter
+pat_connect_attempts_total{scheme="ardop",success="false"} 1
+pat_connect_attempts_total{scheme="ardop",success="true"} 2
+pat_connect_attempts_total{scheme="telnet",success="true"} 1
+# HELP pat_connect_bytes_total Bytes transferred by connects.
+# TYPE pat_connect_bytes_total counter
+pat_connect_bytes_total{scheme="ardop",direction="in"} 6000
+pat_connect_bytes_total{scheme="ardop",direction="out"} 100
+pat_connect_bytes_total{scheme="telnet",direction="in"} 1200
+pat_connect_bytes_total{scheme="telnet",direction="out"} 300
+# HELP pat_session_duration_seconds Duration of the sessions, from connected until disconnect.
+# TYPE pat_session_duration_seconds histogram
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="10"} 0
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="30"} 0
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="60"} 0
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="120"} 0
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="300"} 0
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="600"} 1
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="1800"} 1
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="3600"} 1
+pat_session_duration_seconds_bucket{scheme="ardop",success="true",le="+Inf"} 2
+pat_session_duration_seconds_sum{scheme="ardop",success="true"} 7600
+pat_session_duration_seconds_count{scheme="ardop",success="true"} 2
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="10"} 0
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="30"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="60"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="120"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="300"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="600"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="1800"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="3600"} 1
+pat_session_duration_seconds_bucket{scheme="telnet",success="true",le="+Inf"} 1
+pat_session_duration_seconds_sum{scheme="telnet",success="true"} 25
+pat_session_duration_seconds_count{scheme="telnet",success="true"} 1
+`
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Got Content-Type %q", ct)
+	}
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Got metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// The interval between transfer progress reports during an exchange.
+const progressInterval = 2 * time.Second
+
+// ProgressFunc is called periodically during an exchange (see reportProgress).
+type ProgressFunc func(TransferProgress)
+
+// countingConn is a net.Conn counting the bytes read and written (see ByteCounter).
+type countingConn struct {
+	in, out int64 // Accessed atomically. Kept first for 64-bit alignment.
+	net.Conn
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.in, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.out, int64(n))
+	return n, err
+}
+
+func (c *countingConn) BytesReceived() int64 { return atomic.LoadInt64(&c.in) }
+func (c *countingConn) BytesSent() int64     { return atomic.LoadInt64(&c.out) }
+
+// progressFuncs returns the progress callbacks for the next exchange: console output if enabled
+// with --progress, and the Web GUI if any clients are connected.
+func progressFuncs() []ProgressFunc {
+	var fs []ProgressFunc
+	if fOptions.Progress {
+		fs = append(fs, logProgress)
+	}
+	if len(websocketHub.ClientAddrs()) > 0 {
+		fs = append(fs, websocketHub.WriteTransferProgress)
+	}
+	return fs
+}
+
+// withProgress returns conn wrapped in a countingConn if it does not count bytes itself, and starts
+// reporting its progress to the given funcs until stop is called.
+func withProgress(conn net.Conn, fs []ProgressFunc) (c net.Conn, stop func()) {
+	counter, ok := conn.(ByteCounter)
+	if !ok {
+		cc := &countingConn{Conn: conn}
+		counter, conn = cc, cc
+	}
+	return conn, reportProgress(counter, progressInterval, fs)
+}
+
+// reportProgress calls the given funcs with the bytes transferred every interval, until stop is called.
+//
+// The reported rate is the throughput of the last interval.
+func reportProgress(c ByteCounter, interval time.Duration, fs []ProgressFunc) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		start := time.Now()
+		in0, out0 := c.BytesReceived(), c.BytesSent()
+		last := start
+		var lastTotal int64
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				p := TransferProgress{
+					BytesReceived: c.BytesReceived() - in0,
+					BytesSent:     c.BytesSent() - out0,
+					Elapsed:       now.Sub(start).Seconds(),
+				}
+				total := p.BytesReceived + p.BytesSent
+				p.BytesPerSecond = float64(total-lastTotal) / now.Sub(last).Seconds()
+				last, lastTotal = now, total
+				for _, f := range fs {
+					f(p)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func logProgress(p TransferProgress) {
+	log.Printf("Transferred %d bytes in, %d bytes out (%.0f B/s)", p.BytesReceived, p.BytesSent, p.BytesPerSecond)
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pd0mz/go-maidenhead"
+)
+
+// The maximum duration of the propagation command (see config.PropagationCmd).
+const propagationCmdTimeout = 10 * time.Second
+
+// PropagationPredictor ranks HF bands by expected propagation.
+type PropagationPredictor interface {
+	// RankBands returns the bands (as in bands, e.g. 40m) in order of expected propagation at the given time
+	// from the given locator, best first. Bands not returned are expected to be closed.
+	RankBands(t time.Time, locator string) ([]string, error)
+}
+
+// propagationPredictor returns the predictor used to order connect strings, or nil if disabled
+// (see config.PropagationOrder).
+func propagationPredictor() PropagationPredictor {
+	switch {
+	case !config.PropagationOrder:
+		return nil
+	case config.PropagationCmd != "":
+		return cmdPredictor(config.PropagationCmd)
+	default:
+		return dayNightPredictor{}
+	}
+}
+
+// dayNightPredictor is a simple time-of-day heuristic: the higher bands during the (local solar) day,
+// the lower bands at night and a mix around sunrise and sunset.
+type dayNightPredictor struct{}
+
+func (dayNightPredictor) RankBands(t time.Time, locator string) ([]string, error) {
+	hour := float64(t.UTC().Hour()) + float64(t.UTC().Minute())/60
+	if p, err := maidenhead.ParseLocator(locator); err == nil {
+		hour += p.Longitude / 15 // Local solar time
+	}
+	switch hour := int(hour+24) % 24; {
+	case hour >= 8 && hour < 17:
+		return []string{"20m", "17m", "30m", "15m", "40m", "12m", "10m", "60m"}, nil
+	case hour >= 20 || hour < 5:
+		return []string{"80m", "40m", "60m", "160m", "30m"}, nil
+	default: // Around sunrise and sunset
+		return []string{"40m", "30m", "60m", "20m", "80m"}, nil
+	}
+}
+
+// cmdPredictor runs an external command to rank the bands.
+//
+// The command gets the time (RFC 3339) and locator in the environment variables PAT_UTC_TIME and PAT_LOCATOR,
+// and prints the bands best first, one per line.
+type cmdPredictor string
+
+func (c cmdPredictor) RankBands(t time.Time, locator string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), propagationCmdTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", string(c))
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", string(c))
+	}
+	cmd.Env = append(os.Environ(),
+		"PAT_UTC_TIME="+t.UTC().Format(time.RFC3339),
+		"PAT_LOCATOR="+locator,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Propagation command failed: %s", err)
+	}
+
+	var ranked []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if band := strings.ToLower(strings.TrimSpace(s.Text())); band != "" {
+			ranked = append(ranked, band)
+		}
+	}
+	return ranked, nil
+}
+
+// propagationOrder orders the HF connect strings with a frequency by the predictor's band ranking. The
+// remaining connect strings keep their position, and HF connect strings on bands not ranked are tried last.
+func propagationOrder(p PropagationPredictor, ordered []string) []string {
+	ranked, err := p.RankBands(time.Now(), config.Locator)
+	if err != nil {
+		log.Println(err)
+		return ordered
+	}
+	rank := make(map[string]int, len(ranked))
+	for i, band := range ranked {
+		if _, ok := rank[band]; !ok {
+			rank[band] = i
+		}
+	}
+
+	// The positions of the HF connect strings, and their rank
+	var slots []int
+	ranks := make(map[string]int)
+	for i, str := range ordered {
+		band, ok := connectStrBand(str)
+		if !ok {
+			continue
+		}
+		slots = append(slots, i)
+		if r, ok := rank[band]; ok {
+			ranks[str] = r
+		} else {
+			ranks[str] = len(ranked) // Closed
+		}
+	}
+	if len(slots) < 2 {
+		return ordered
+	}
+
+	hf := make([]string, len(slots))
+	for i, slot := range slots {
+		hf[i] = ordered[slot]
+	}
+	sort.SliceStable(hf, func(i, j int) bool { return ranks[hf[i]] < ranks[hf[j]] })
+
+	result := append([]string(nil), ordered...)
+	for i, slot := range slots {
+		result[slot] = hf[i]
+	}
+	log.Printf("Propagation order (%s): %s", strings.Join(ranked, ", "), strings.Join(hf, ", "))
+	return result
+}
+
+// connectStrBand returns the band (see bands) of the connect string's frequency, if it is an HF connect
+// string with a frequency.
+func connectStrBand(connectStr string) (string, bool) {
+	url, err := ResolveConnectURL(connectStr)
+	if err != nil || !isHFTransport(url.Scheme) {
+		return "", false
+	}
+	f, err := ParseFrequency(url.Params.Get("freq"))
+	if err != nil {
+		return "", false
+	}
+	for name, band := range bands {
+		if band.Contains(f) {
+			return name, true
+		}
+	}
+	return "", false
+}
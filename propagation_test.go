@@ -0,0 +1,40 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type rankedBands []string
+
+func (r rankedBands) RankBands(time.Time, string) ([]string, error) { return r, nil }
+
+func TestPropagationOrder(t *testing.T) {
+	const (
+		m80    = "ardop:///LA1B?freq=3590"
+		m40    = "ardop:///LA1B?freq=7064"
+		m20    = "ardop:///LA1B?freq=14094"
+		telnet = "telnet:///LA1B"
+	)
+	tests := []struct {
+		ranked rankedBands
+		in     []string
+		want   []string
+	}{
+		{rankedBands{"40m", "80m"}, []string{m80, m40}, []string{m40, m80}},
+		{rankedBands{"40m", "80m"}, []string{telnet, m80, m40}, []string{telnet, m40, m80}}, // Others keep their position
+		{rankedBands{"40m", "80m"}, []string{m20, telnet, m80}, []string{m80, telnet, m20}}, // Closed bands last
+		{rankedBands{"20m"}, []string{m80, m40}, []string{m80, m40}},                        // Equal rank keeps order
+		{rankedBands{"40m"}, []string{telnet, m80}, []string{telnet, m80}},                  // Single HF connect string
+	}
+	for _, tt := range tests {
+		if got := propagationOrder(tt.ranked, tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("propagationOrder(%q, %q) = %q, want %q", tt.ranked, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// QSYFunc is a QSY hook, called with the transport and the frequency the rig is changed to.
+type QSYFunc func(method string, freq Frequency) error
+
+// QSYHooks are called around the frequency changes done by connect (see qsy), e.g. to disable an
+// amplifier before QSY or to tune an antenna tuner while the rig settles.
+type QSYHooks struct {
+	mu      sync.RWMutex
+	preQSY  []QSYFunc
+	postQSY []QSYFunc
+	postQSX []QSYFunc
+}
+
+// qsyHooks holds the QSY hooks used by qsy. No hooks are registered by default.
+var qsyHooks = new(QSYHooks)
+
+// OnPreQSY registers fn to be called before QSY. An error aborts the QSY (and the connect).
+func (h *QSYHooks) OnPreQSY(fn QSYFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.preQSY = append(h.preQSY, fn)
+}
+
+// OnPostQSY registers fn to be called after QSY. The rig's settle delay includes the time spent in the hooks.
+func (h *QSYHooks) OnPostQSY(fn QSYFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.postQSY = append(h.postQSY, fn)
+}
+
+// OnPostQSX registers fn to be called after changing back to the previous frequency.
+func (h *QSYHooks) OnPostQSX(fn QSYFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.postQSX = append(h.postQSX, fn)
+}
+
+func (h *QSYHooks) runPreQSY(method string, freq Frequency) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.preQSY {
+		if err := fn(method, freq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *QSYHooks) runPostQSY(method string, freq Frequency) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.postQSY {
+		if err := fn(method, freq); err != nil {
+			log.Printf("Post-QSY hook failed: %s", err)
+		}
+	}
+}
+
+func (h *QSYHooks) runPostQSX(method string, freq Frequency) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.postQSX {
+		if err := fn(method, freq); err != nil {
+			log.Printf("Post-QSX hook failed: %s", err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reachCache remembers the last connect outcome of each target and frequency, fed by the connect_result events
+// of the event log. Outcomes older than config.ReachCache.TTL are ignored when ordering connect strings (see
+// orderConnectStrs).
+var reachCache = &reachability{outcomes: make(map[string]reachOutcome)}
+
+type reachOutcome struct {
+	connectStr string
+	scheme     string
+	success    bool
+	time       time.Time
+}
+
+type reachability struct {
+	mu       sync.Mutex
+	outcomes map[string]reachOutcome // By reachKey.
+}
+
+// reachKey returns the key of the target and frequency of the connect string (e.g. ardop:LA1B-10@7064000).
+func reachKey(connectStr string) (string, bool) {
+	url, err := ResolveConnectURL(connectStr)
+	if err != nil || url.Target == "" {
+		return "", false
+	}
+	key := url.Scheme + ":" + strings.ToUpper(url.Target)
+	if f, err := ParseFrequency(url.Params.Get("freq")); err == nil {
+		key += fmt.Sprintf("@%d", f)
+	}
+	return key, true
+}
+
+func (r *reachability) ttl() time.Duration { return time.Duration(config.ReachCache.TTL) * time.Second }
+
+// Write implements EventSink, recording the outcome of each connect_result event.
+func (r *reachability) Write(e Event) error {
+	if e["what"] != "connect_result" {
+		return nil
+	}
+	connectStr, _ := e["connect_str"].(string)
+	scheme, _ := e["scheme"].(string)
+	success, _ := e["success"].(bool)
+	key, ok := reachKey(connectStr)
+	if !ok {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes[key] = reachOutcome{connectStr, scheme, success, time.Now()}
+	return nil
+}
+
+// History returns the last connect record of each target and frequency.
+func (r *reachability) History() []connectRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	history := make([]connectRecord, 0, len(r.outcomes))
+	for _, o := range r.outcomes {
+		history = append(history, connectRecord{Time: o.time, ConnectStr: o.connectStr, Scheme: o.scheme, Success: o.success})
+	}
+	return history
+}
+
+func (r *reachability) Close() error { return nil }
+
+// Load reads the connect history from a JSON log file (see EventLogger.SetJSONSink), keeping the last outcome
+// of each target and frequency.
+func (r *reachability) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dec := json.NewDecoder(f)
+	for {
+		var rec connectRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		key, ok := reachKey(rec.ConnectStr)
+		if !ok {
+			continue
+		}
+		if o, ok := r.outcomes[key]; !ok || rec.Time.After(o.time) {
+			r.outcomes[key] = reachOutcome{rec.ConnectStr, rec.Scheme, rec.Success, rec.Time}
+		}
+	}
+}
+
+// outcome returns the unexpired outcome of the connect string, if any. Must be called with r.mu held.
+func (r *reachability) outcome(connectStr string) (reachOutcome, bool) {
+	key, ok := reachKey(connectStr)
+	if !ok {
+		return reachOutcome{}, false
+	}
+	o, ok := r.outcomes[key]
+	if !ok || time.Since(o.time) > r.ttl() {
+		return reachOutcome{}, false
+	}
+	return o, true
+}
+
+// Outcomes returns the unexpired outcome of each of the connect strings that has one.
+func (r *reachability) Outcomes(connectStrs []string) map[string]reachOutcome {
+	outcomes := make(map[string]reachOutcome)
+	if r.ttl() <= 0 {
+		return outcomes
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, str := range connectStrs {
+		if o, ok := r.outcome(str); ok {
+			outcomes[str] = o
+		}
+	}
+	return outcomes
+}
+
+// Print prints the unexpired outcomes.
+func (r *reachability) Print() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.outcomes))
+	for key, o := range r.outcomes {
+		if time.Since(o.time) <= r.ttl() {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Println("Reach cache is empty")
+		return
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		o := r.outcomes[key]
+		status := "failed"
+		if o.success {
+			status = "reached"
+		}
+		expires := (r.ttl() - time.Since(o.time)).Round(time.Second)
+		fmt.Printf("  %-30s %-8s %s (expires in %s)\n", key, status, o.time.Format("15:04:05"), expires)
+	}
+}
+
+// Clear forgets all outcomes.
+func (r *reachability) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = make(map[string]reachOutcome)
+}
@@ -153,6 +153,12 @@ function updateProgress(p) {
 	}
 }
 
+function updateLinkQuality(q) {
+	var st = $('#status_text');
+	st.find('.link-quality').remove();
+	st.append(' <span class="link-quality">(SNR ' + q.snr + ' dB)</span>');
+}
+
 function initStatusPopover() {
 	statusPopoverDiv = $('#status_popover_content');
 	showGUIStatus($('#websocket_error'), true);
@@ -441,6 +447,13 @@ function updateStatus(data)
 		st.append("<i>Listening " + data.active_listeners + "</i>");
 	}
 
+	// A TNC is busy with another session
+	$('#connect_btn').prop('disabled', data.busy_tncs.length > 0);
+
+	for(var rig in data.rig_owners){
+		st.append(" <i>(rig " + rig + ": " + data.rig_owners[rig] + ")</i>");
+	}
+
 	var n = data.http_clients.length;
 	statusPopoverDiv.find('#webserver_info').find('.panel-body').html(n + (n == 1 ? ' client ' : ' clients ') + 'connected.');
 }
@@ -561,6 +574,12 @@ function initConsole()
 			if(msg.PromptAbort) {
 				$('#promptModal').modal('hide');
 			}
+			if(msg.LinkQuality) {
+				updateLinkQuality(msg.LinkQuality);
+			}
+			if(msg.TNCEvent && msg.TNCEvent.kind == 'fault') {
+				alert(msg.TNCEvent.tnc + ' fault: ' + msg.TNCEvent.message);
+			}
 		};
 		ws.onclose   = function(evt) {
 			showGUIStatus(statusPopoverDiv.find('#websocket_error'), true)
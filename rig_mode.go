@@ -0,0 +1,89 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rigModes holds the rigs (by name) that can set the mode, see loadHamlibRigs.
+var rigModes = make(map[string]modeRig)
+
+// modeRig is implemented by rigs that can set the mode (e.g. USB or PKTUSB).
+type modeRig interface {
+	// GetMode returns the rig's mode and passband (Hz).
+	GetMode() (mode string, passband int, err error)
+
+	// SetMode sets the rig's mode and passband (Hz). Passband 0 is the rig's default passband for the mode.
+	SetMode(mode string, passband int) error
+}
+
+// The timeout of each rigctld command.
+const rigctldTimeout = 5 * time.Second
+
+// rigctldMode controls the mode of a rig through rigctld (hamlib's rig control daemon) at addr (host:port).
+//
+// The hamlib VFO only controls frequency and PTT, so the mode is set over a separate rigctld connection.
+// Like rigctld itself, it operates on the rig's current VFO.
+type rigctldMode struct{ addr string }
+
+func (r rigctldMode) GetMode() (mode string, passband int, err error) {
+	lines, err := r.cmd("m", 2)
+	if err != nil {
+		return "", 0, err
+	}
+	passband, err = strconv.Atoi(lines[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("rigctld: invalid passband '%s'", lines[1])
+	}
+	return lines[0], passband, nil
+}
+
+func (r rigctldMode) SetMode(mode string, passband int) error {
+	if mode == "" || strings.ContainsAny(mode, " \r\n") {
+		return fmt.Errorf("invalid mode '%s'", mode)
+	}
+	_, err := r.cmd(fmt.Sprintf("M %s %d", strings.ToUpper(mode), passband), 0)
+	return err
+}
+
+// cmd sends the command to rigctld, and returns the n lines of the reply.
+//
+// Commands without a reply value (n == 0) are answered with a return code (RPRT 0 on success).
+func (r rigctldMode) cmd(cmd string, n int) ([]string, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, rigctldTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(rigctldTimeout))
+
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(conn)
+	var lines []string
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "RPRT 0" && n == 0:
+			return nil, nil
+		case strings.HasPrefix(line, "RPRT "):
+			return nil, fmt.Errorf("rigctld: command '%s' failed (%s)", cmd, line)
+		}
+		if lines = append(lines, line); len(lines) == n {
+			return lines, nil
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRigctld is a rigctld server supporting the get and set mode commands.
+type fakeRigctld struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	mode     string
+	passband int
+	cmds     []string
+}
+
+func newFakeRigctld(t *testing.T, mode string, passband int) *fakeRigctld {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRigctld{ln: ln, mode: mode, passband: passband}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRigctld) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		cmd := strings.TrimSpace(line)
+		r.cmds = append(r.cmds, cmd)
+		var mode string
+		var passband int
+		switch {
+		case cmd == "m":
+			fmt.Fprintf(conn, "%s\n%d\n", r.mode, r.passband)
+		case strings.HasPrefix(cmd, "M "):
+			if _, err := fmt.Sscanf(cmd, "M %s %d", &mode, &passband); err != nil || mode == "AM" {
+				fmt.Fprint(conn, "RPRT -1\n") // Rejected
+				break
+			}
+			r.mode, r.passband = mode, passband
+			fmt.Fprint(conn, "RPRT 0\n")
+		default:
+			fmt.Fprint(conn, "RPRT -4\n")
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *fakeRigctld) state() (mode string, passband int, cmds []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mode, r.passband, append([]string(nil), r.cmds...)
+}
+
+func TestRigctldMode(t *testing.T) {
+	fake := newFakeRigctld(t, "LSB", 2400)
+	defer fake.ln.Close()
+	rig := rigctldMode{fake.ln.Addr().String()}
+
+	if mode, passband, err := rig.GetMode(); err != nil || mode != "LSB" || passband != 2400 {
+		t.Errorf("GetMode() = %q, %d, %v", mode, passband, err)
+	}
+	if err := rig.SetMode("pktusb", 0); err != nil {
+		t.Errorf("SetMode(pktusb): unexpected error: %s", err)
+	}
+	if mode, passband, _ := fake.state(); mode != "PKTUSB" || passband != 0 {
+		t.Errorf("Got mode %s %d, want PKTUSB 0", mode, passband)
+	}
+	if err := rig.SetMode("AM", 0); err == nil {
+		t.Error("SetMode(AM): expected error")
+	}
+	if err := rig.SetMode("USB\nM AM", 0); err == nil {
+		t.Error("SetMode with newline: expected error")
+	}
+	if _, _, err := (rigctldMode{"127.0.0.1:1"}).GetMode(); err == nil {
+		t.Error("GetMode without rigctld: expected error")
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	defer func(m map[string]modeRig) { rigModes = m }(rigModes)
+	fake := newFakeRigctld(t, "LSB", 2400)
+	defer fake.ln.Close()
+	rigModes = map[string]modeRig{"ft991": rigctldMode{fake.ln.Addr().String()}}
+
+	restore, ok := setMode(MethodArdop, "ft991", "USB")
+	if mode, _, _ := fake.state(); !ok || mode != "USB" {
+		t.Errorf("setMode(USB): got mode %s, ok %t", mode, ok)
+	}
+	restore()
+	if mode, passband, _ := fake.state(); mode != "LSB" || passband != 2400 {
+		t.Errorf("Got mode %s %d after restore, want LSB 2400", mode, passband)
+	}
+
+	// Already in the mode
+	_, _, before := fake.state()
+	if _, ok := setMode(MethodArdop, "ft991", "lsb"); !ok {
+		t.Error("setMode(lsb): got ok false")
+	}
+	if _, _, after := fake.state(); len(after) != len(before)+1 {
+		t.Errorf("setMode(lsb): got commands %q, want only a get", after[len(before):])
+	}
+
+	// Rejected or not supported: frequency only QSY
+	if _, ok := setMode(MethodArdop, "ft991", "AM"); ok {
+		t.Error("setMode(AM): got ok true")
+	}
+	if _, ok := setMode(MethodArdop, "ic7300", "USB"); ok {
+		t.Error("setMode for rig without mode control: got ok true")
+	}
+	if mode, _, _ := fake.state(); mode != "LSB" {
+		t.Errorf("Got mode %s, want LSB", mode)
+	}
+}
@@ -48,31 +48,12 @@ func rmsListHandle(args []string) {
 	byDistance := set.BoolP("sort-distance", "s", false, "")
 	set.Parse(args)
 
-	appDir, err := mailbox.DefaultAppDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-	fileName := "rmslist"
-	isDefaultServiceCode := len(config.ServiceCodes) == 1 && config.ServiceCodes[0] == "PUBLIC"
-	if !isDefaultServiceCode {
-		fileName += "-" + strings.Join(config.ServiceCodes, "-")
-	}
-	filePath := path.Join(appDir, fileName+".json") // Should be moved to a tmp-folder, along with logfile.
-
 	var query string
 	if len(set.Args()) > 0 {
 		query = strings.ToUpper(set.Args()[0])
 	}
 
-	file, err := cmsapi.GetGatewayStatusCached(filePath, *forceDownload, config.ServiceCodes...)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	var status cmsapi.GatewayStatus
-
-	err = json.NewDecoder(file).Decode(&status)
+	status, err := loadRMSList(*forceDownload)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -149,6 +130,31 @@ func rmsListHandle(args []string) {
 	}
 }
 
+// loadRMSList returns the gateway status list for the configured service codes, downloading it if not cached.
+func loadRMSList(forceDownload bool) (cmsapi.GatewayStatus, error) {
+	var status cmsapi.GatewayStatus
+
+	appDir, err := mailbox.DefaultAppDir()
+	if err != nil {
+		return status, err
+	}
+	fileName := "rmslist"
+	isDefaultServiceCode := len(config.ServiceCodes) == 1 && config.ServiceCodes[0] == "PUBLIC"
+	if !isDefaultServiceCode {
+		fileName += "-" + strings.Join(config.ServiceCodes, "-")
+	}
+	filePath := path.Join(appDir, fileName+".json") // Should be moved to a tmp-folder, along with logfile.
+
+	file, err := cmsapi.GetGatewayStatusCached(filePath, forceDownload, config.ServiceCodes...)
+	if err != nil {
+		return status, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&status)
+	return status, err
+}
+
 func toURL(gc cmsapi.GatewayChannel, targetcall string) *url.URL {
 	freq := Frequency(gc.Frequency).Dial(gc.SupportedModes)
 
@@ -156,14 +162,20 @@ func toURL(gc cmsapi.GatewayChannel, targetcall string) *url.URL {
 	return url
 }
 
-var transports = []string{"winmor", "packet", "pactor", "ardop"}
+var transports = []string{"winmor", "packet", "pactor", "ardop", "vara"}
 
 func toTransport(gc cmsapi.GatewayChannel) string {
 	modes := strings.ToLower(gc.SupportedModes)
 	for _, transport := range transports {
-		if strings.Contains(modes, transport) {
-			return transport
+		if !strings.Contains(modes, transport) {
+			continue
+		}
+		if transport == "vara" && strings.HasSuffix(modes, "fm") {
+			return MethodVaraFM
+		} else if transport == "vara" {
+			return MethodVaraHF
 		}
+		return transport
 	}
 	return ""
 }
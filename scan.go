@@ -0,0 +1,197 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport/ardop"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+// scanner cycles a listener across the frequencies of config.ScanList.
+var scanner = &scan{}
+
+// How often the scanner checks whether a session using the rig has ended.
+const scanBusyPoll = time.Second
+
+type scan struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start starts the scan. The listener of config.ScanTransport is started (unless already active), and moved
+// between the frequencies of config.ScanList in turn.
+func (s *scan) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return errors.New("Scan already running")
+	}
+
+	entries := append([]cfg.ScanEntry(nil), config.ScanList...)
+	if len(entries) == 0 {
+		return errors.New("Nothing to scan: scan_list is empty")
+	}
+	for _, e := range entries {
+		if _, err := ParseFrequency(e.Freq); err != nil {
+			return fmt.Errorf("Invalid scan_list entry: %s", err)
+		}
+		if e.Dwell <= 0 {
+			return fmt.Errorf("Invalid scan_list entry %s: dwell must be positive", e.Freq)
+		}
+		if e.Bandwidth != "" {
+			if _, err := ardop.BandwidthFromString(e.Bandwidth); err != nil {
+				return fmt.Errorf("Invalid scan_list entry %s: invalid bandwidth '%s': %s", e.Freq, e.Bandwidth, err)
+			}
+		}
+	}
+
+	method := config.ScanTransport
+	if method == "" {
+		method = MethodArdop
+	}
+	if _, _, err := qsyRig(listenURL(method)); err != nil {
+		return fmt.Errorf("Unable to scan with %s: %s", method, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.cancel, s.done = cancel, done
+	go func() {
+		defer close(done)
+		s.run(ctx, method, entries)
+
+		s.mu.Lock()
+		if s.done == done {
+			s.cancel, s.done = nil, nil
+		}
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop stops the scan (returning the rig to the frequency it had before the scan), returning false if no scan
+// is running.
+func (s *scan) Stop() bool {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	<-done
+	return true
+}
+
+func (s *scan) run(ctx context.Context, method string, entries []cfg.ScanEntry) {
+	t, err := newTransportListener(method)
+	if err != nil {
+		log.Printf("Scan failed: %s", err)
+		return
+	}
+	if !isListening(func(l TransportListener) bool { return l.Name() == t.Name() }) {
+		if err := listenHub.Start(method); err != nil {
+			log.Printf("Scan failed: %s", err)
+			return
+		}
+		defer listenHub.Stop(method)
+	}
+
+	var restoreFreq func(transmitted bool)
+	revertBW := func() {}
+	defer func() {
+		revertBW()
+		if restoreFreq == nil {
+			return
+		}
+		// ctx is done, so this fails rather than waits if a session is using the rig
+		if err := s.hop(ctx, method, func() { restoreFreq(false) }); err != nil {
+			log.Println("Scan: Rig in use, not restoring the frequency")
+		}
+	}()
+
+	for i := 0; ctx.Err() == nil; i = (i + 1) % len(entries) {
+		e := entries[i]
+		var moved bool
+		err := s.hop(ctx, method, func() {
+			revertBW()
+			revertBW = func() {}
+
+			url := listenURL(method)
+			revert, err := qsy(url, e.Freq, "")
+			if err != nil {
+				log.Printf("Scan: QSY to %s failed: %s", e.Freq, err)
+				return
+			}
+			if restoreFreq == nil {
+				restoreFreq = revert
+			}
+			moved = true
+
+			if e.Bandwidth != "" {
+				url.Params.Set("bw", e.Bandwidth)
+				if revertBW, err = setARQBandwidth(url); err != nil {
+					log.Printf("Scan: %s", err)
+					revertBW = func() {}
+				}
+			}
+		})
+		if err != nil {
+			return
+		}
+
+		dwell := time.Duration(e.Dwell) * time.Second
+		if moved {
+			log.Printf("Scan: listening on %s (%d of %d) for %s", e.Freq, i+1, len(entries), dwell)
+			eventLog.Log("scan_hop", map[string]interface{}{
+				"transport": method,
+				"freq":      e.Freq,
+				"dwell":     e.Dwell,
+			})
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(dwell):
+		}
+	}
+}
+
+// hop calls fn holding the rig and TNC of the scan's listener (see tncGuard), waiting for any session using
+// them to end first. The rig is never moved while a session is active.
+//
+// Returns ctx.Err() if ctx is done before the session ended.
+func (s *scan) hop(ctx context.Context, method string, fn func()) error {
+	url := listenURL(method)
+	for {
+		release, err := tncGuard.tryAcquire(url)
+		if err == nil {
+			defer release()
+			fn()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scanBusyPoll):
+		}
+	}
+}
+
+// preemptScan stops the scan (if any) ahead of a manual connect.
+func preemptScan() {
+	if scanner.Stop() {
+		log.Println("Scan stopped (preempted by manual connect)")
+	}
+}
@@ -5,9 +5,13 @@
 package main
 
 import (
-	"github.com/gorhill/cronexpr"
+	"context"
 	"log"
+	"math/rand"
+	"strings"
 	"time"
+
+	"github.com/gorhill/cronexpr"
 )
 
 type Job struct {
@@ -28,15 +32,58 @@ func scheduleLoop() {
 	}
 
 	go func() {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 		for range time.Tick(time.Second) {
 			for _, j := range jobs {
 				if time.Now().Before(j.next) {
 					continue
 				}
-				log.Printf("Executing scheduled command '%s'...", j.cmd)
-				execCmd(j.cmd)
+				if cmd, param := parseCommand(j.cmd); cmd == "connect" {
+					scheduledConnect(rnd, strings.Fields(param))
+				} else {
+					log.Printf("Executing scheduled command '%s'...", j.cmd)
+					execCmd(j.cmd)
+				}
 				j.next = j.expr.Next(time.Now())
 			}
 		}
 	}()
 }
+
+// scheduledConnect runs a scheduled connect (see connectAny), after a random delay of up to
+// config.ScheduleJitter and the connect cool-down.
+//
+// The run is skipped if a session is already active.
+func scheduledConnect(rnd *rand.Rand, connectStr []string) {
+	str := strings.Join(connectStr, " ")
+	if len(connectStr) == 0 {
+		log.Println("Skipping scheduled connect: nothing to connect to")
+		return
+	}
+	if config.ScheduleJitter > 0 {
+		jitter := time.Duration(rnd.Int63n(int64(config.ScheduleJitter) * int64(time.Second)))
+		log.Printf("Scheduled connect '%s' starts in %s...", str, jitter.Round(time.Second))
+		time.Sleep(jitter)
+	}
+	connectCooldown.wait(context.Background(), 0)
+	if sessionActive() {
+		log.Printf("Skipping scheduled connect '%s': session already active", str)
+		eventLog.Log("schedule", map[string]interface{}{"connect_str": str, "skipped": true})
+		return
+	}
+
+	log.Printf("Starting scheduled connect '%s'...", str)
+	winner, err := connectAny(false, connectStr...)
+	e := map[string]interface{}{"connect_str": str, "success": err == nil}
+	if err != nil {
+		log.Printf("Scheduled connect '%s' failed: %s", str, err)
+		e["error"] = err.Error()
+	} else {
+		log.Printf("Scheduled connect '%s' completed (%s)", str, winner)
+		e["winner"] = winner
+	}
+	eventLog.Log("schedule", e)
+}
+
+// sessionActive returns true if a connect is in progress or a session (inbound or outbound) is active.
+func sessionActive() bool { return exchangeConn != nil || pendingDials.pending() > 0 }
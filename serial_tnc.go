@@ -0,0 +1,74 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// setSerialTNCParams sets the serial line settings of config.SerialTNC on the serial-tnc URL, unless
+// given by the URL parameters (?databits=, ?parity=, ?stopbits= and ?flow=), and validates them.
+//
+// An error naming the offending parameter is returned if a setting (or a combination of settings) is invalid.
+func setSerialTNCParams(url *transport.URL) error {
+	conf := config.SerialTNC
+	defaults := map[string]string{
+		"databits": "",
+		"parity":   conf.Parity,
+		"stopbits": conf.StopBits,
+		"flow":     conf.FlowControl,
+	}
+	if conf.DataBits > 0 {
+		defaults["databits"] = strconv.Itoa(conf.DataBits)
+	}
+	for key, v := range defaults {
+		if v != "" && url.Params.Get(key) == "" {
+			url.Params.Set(key, v)
+		}
+	}
+
+	var dataBits int
+	if v := url.Params.Get("databits"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 5 || n > 8 {
+			return fmt.Errorf("Invalid serial-tnc databits '%s': must be 5, 6, 7 or 8", v)
+		}
+		dataBits = n
+	}
+
+	if v := url.Params.Get("parity"); v != "" {
+		switch strings.ToLower(v) {
+		case "none", "odd", "even", "mark", "space":
+		default:
+			return fmt.Errorf("Invalid serial-tnc parity '%s': must be none, odd, even, mark or space", v)
+		}
+	}
+
+	switch v := url.Params.Get("stopbits"); v {
+	case "", "1", "2":
+	case "1.5":
+		if dataBits != 5 {
+			return fmt.Errorf("Invalid serial-tnc stopbits '%s': 1.5 stop bits requires 5 databits", v)
+		}
+	default:
+		return fmt.Errorf("Invalid serial-tnc stopbits '%s': must be 1, 1.5 or 2", v)
+	}
+
+	if v := url.Params.Get("flow"); v != "" {
+		switch strings.ToLower(v) {
+		case "none", "rtscts", "xonxoff":
+		default:
+			return fmt.Errorf("Invalid serial-tnc flow '%s': must be none, rtscts or xonxoff", v)
+		}
+		if strings.EqualFold(v, "xonxoff") && dataBits > 0 && dataBits < 7 {
+			return fmt.Errorf("Invalid serial-tnc flow '%s': XON/XOFF requires at least 7 databits", v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identical faults from the same TNC are logged at most once per faultRateLimit.
+const faultRateLimit = time.Minute
+
+// The number of recent faults kept per TNC, for attaching to the connect record (see faultsSince).
+const maxRecentFaults = 20
+
+// TNCEvent is an asynchronous event (fault or state change) reported by a TNC.
+type TNCEvent struct {
+	TNC        string    `json:"tnc"`  // The TNC (see exclusiveTNCKey), e.g. ardop:127.0.0.1:8515.
+	Kind       string    `json:"kind"` // fault or state.
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time"`
+	Suppressed int       `json:"suppressed,omitempty"` // Identical faults suppressed since this fault was last reported.
+}
+
+// asyncMessenger is implemented by TNCs exposing their asynchronous host messages (e.g. the VARA modems).
+//
+// The channel is closed when the TNC is closed.
+type asyncMessenger interface {
+	AsyncMessages() <-chan string
+}
+
+var tncEvents = &tncEventLog{
+	lastFault: make(map[string]time.Time),
+	repeats:   make(map[string]int),
+	recent:    make(map[string][]TNCEvent),
+}
+
+type tncEventLog struct {
+	mu        sync.Mutex
+	lastFault map[string]time.Time  // Time each fault (by TNC and message) was last reported.
+	repeats   map[string]int        // The number of times each fault was suppressed since last reported.
+	recent    map[string][]TNCEvent // The most recent faults of each TNC.
+}
+
+// watchTNCEvents forwards the asynchronous messages of the TNC (if supported) to the log, the web GUI and the
+// event log, until the TNC is closed.
+func watchTNCEvents(key string, tnc interface{}) {
+	m, ok := tnc.(asyncMessenger)
+	if !ok {
+		debugf("%s: asynchronous TNC messages not supported", key)
+		return
+	}
+	go func() {
+		for msg := range m.AsyncMessages() {
+			if e, ok := parseTNCEvent(key, msg); ok {
+				tncEvents.handle(e)
+			}
+		}
+	}()
+}
+
+// parseTNCEvent parses a asynchronous TNC message, returning false if it is neither a fault nor a state change.
+func parseTNCEvent(key, msg string) (TNCEvent, bool) {
+	e := TNCEvent{TNC: key, Time: time.Now()}
+	msg = strings.TrimSpace(msg)
+	parts := strings.SplitN(msg, " ", 2)
+	switch strings.ToUpper(parts[0]) {
+	case "FAULT":
+		e.Kind = "fault"
+	case "NEWSTATE", "STATE":
+		e.Kind = "state"
+	case "MISSING": // VARA: MISSING SOUNDCARD
+		e.Kind, e.Message = "fault", msg
+		return e, true
+	case "CONNECTED", "DISCONNECTED", "LINK", "REGISTERED": // VARA
+		e.Kind, e.Message = "state", msg
+		return e, true
+	default:
+		return e, false
+	}
+	if len(parts) > 1 {
+		e.Message = strings.TrimSpace(parts[1])
+	}
+	return e, true
+}
+
+func (l *tncEventLog) handle(e TNCEvent) {
+	if e.Kind == "fault" {
+		l.mu.Lock()
+		id := e.TNC + "\x00" + e.Message
+		if time.Since(l.lastFault[id]) < faultRateLimit {
+			l.repeats[id]++
+			l.mu.Unlock()
+			return
+		}
+		l.lastFault[id] = e.Time
+		e.Suppressed, l.repeats[id] = l.repeats[id], 0
+		recent := append(l.recent[e.TNC], e)
+		if len(recent) > maxRecentFaults {
+			recent = recent[len(recent)-maxRecentFaults:]
+		}
+		l.recent[e.TNC] = recent
+		l.mu.Unlock()
+
+		if e.Suppressed > 0 {
+			log.Printf("%s fault: %s (repeated %d times)", e.TNC, e.Message, e.Suppressed)
+		} else {
+			log.Printf("%s fault: %s", e.TNC, e.Message)
+		}
+	} else {
+		debugf("%s state: %s", e.TNC, e.Message)
+	}
+
+	websocketHub.WriteTNCEvent(e)
+	eventLog.Log("tnc_"+e.Kind, map[string]interface{}{
+		"tnc":        e.TNC,
+		"message":    e.Message,
+		"suppressed": e.Suppressed,
+	})
+}
+
+// faultsSince returns the faults reported by the given TNC since t.
+func (l *tncEventLog) faultsSince(key string, t time.Time) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var faults []string
+	for _, e := range l.recent[key] {
+		if !e.Time.Before(t) {
+			faults = append(faults, e.Message)
+		}
+	}
+	return faults
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestParseTNCEvent(t *testing.T) {
+	tests := []struct {
+		msg      string
+		wantKind string
+		wantMsg  string
+		wantOK   bool
+	}{
+		{"FAULT Sound card failure", "fault", "Sound card failure", true},
+		{"NEWSTATE IRS", "state", "IRS", true},
+		{"MISSING SOUNDCARD", "fault", "MISSING SOUNDCARD", true},
+		{"CONNECTED N0CALL LA5NTA 2300", "state", "CONNECTED N0CALL LA5NTA 2300", true},
+		{"DISCONNECTED", "state", "DISCONNECTED", true},
+		{"LINK REGISTERED", "state", "LINK REGISTERED", true},
+		{"BUSY ON", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		e, ok := parseTNCEvent("varahf", tt.msg)
+		if ok != tt.wantOK || e.Kind != tt.wantKind || e.Message != tt.wantMsg {
+			t.Errorf("%q: got %q, %q, %t, want %q, %q, %t", tt.msg, e.Kind, e.Message, ok, tt.wantKind, tt.wantMsg, tt.wantOK)
+		}
+		if e.TNC != "varahf" {
+			t.Errorf("%q: got TNC %q", tt.msg, e.TNC)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// tncGuard ensures that each TNC (and rig) is used by one session at a time, be it outbound (e.g. a connect
+// from the web GUI and a scheduled connect) or inbound (accepted by a listener).
+var tncGuard = &sessionGuard{held: make(map[string]sessionOwner), released: make(chan struct{})}
+
+// Owners of a guarded TNC.
+const (
+	sessionOutbound = "outbound"
+	sessionInbound  = "inbound"
+)
+
+// sessionOwner is the session holding a guarded TNC or rig.
+type sessionOwner struct {
+	direction string // sessionInbound or sessionOutbound.
+	transport string // The transport scheme (e.g. ardop).
+}
+
+// tncBusyError is returned when a connect is attempted on a TNC or rig in use by another session.
+type tncBusyError struct {
+	key   string
+	owner sessionOwner
+}
+
+func (e tncBusyError) Error() string {
+	switch {
+	case e.owner.direction == sessionInbound:
+		return fmt.Sprintf("Transport busy with inbound session (%s)", e.key)
+	case strings.HasPrefix(e.key, rigKeyPrefix):
+		return fmt.Sprintf("Rig %s in use by %s", strings.TrimPrefix(e.key, rigKeyPrefix), e.owner.transport)
+	default:
+		return fmt.Sprintf("TNC busy with another session (%s)", e.key)
+	}
+}
+
+// The prefix of rig keys (see sessionKeys).
+const rigKeyPrefix = "rig:"
+
+type sessionGuard struct {
+	mu       sync.Mutex
+	held     map[string]sessionOwner // The owner of each key (see sessionKeys) in use.
+	released chan struct{}           // Closed (and replaced) when keys are released.
+}
+
+// sessionKeys returns the keys of the TNC (see exclusiveTNCKey) and rig used by the given URL.
+func sessionKeys(url *transport.URL) []string {
+	var keys []string
+	if key := exclusiveTNCKey(url); key != "" {
+		keys = append(keys, key)
+	}
+	if rigName, _, _ := qsyRig(url); rigName != "" {
+		keys = append(keys, rigKeyPrefix+rigName)
+	}
+	return keys
+}
+
+// tryAcquire marks the TNC and rig used by the given URL as in use by an outbound session, until the returned
+// release func is called.
+//
+// A tncBusyError is returned if the TNC or rig is already in use. Transports without an exclusive TNC or rig are
+// not guarded.
+func (g *sessionGuard) tryAcquire(url *transport.URL) (release func(), err error) {
+	return g.acquire(context.Background(), url, sessionOutbound, 0)
+}
+
+// acquire is like tryAcquire, but waits up to wait for inbound sessions using the TNC or rig to end.
+func (g *sessionGuard) acquire(ctx context.Context, url *transport.URL, direction string, wait time.Duration) (release func(), err error) {
+	keys := sessionKeys(url)
+	if len(keys) == 0 {
+		return func() {}, nil
+	}
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	g.mu.Lock()
+	for {
+		err := g.check(keys)
+		if err == nil {
+			break
+		}
+		if e := err.(tncBusyError); e.owner.direction != sessionInbound || timeout == nil {
+			g.mu.Unlock()
+			return nil, err
+		}
+		released := g.released
+		g.mu.Unlock()
+		select {
+		case <-released:
+		case <-timeout:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		g.mu.Lock()
+	}
+	for _, key := range keys {
+		g.held[key] = sessionOwner{direction, url.Scheme}
+	}
+	g.mu.Unlock()
+	websocketHub.UpdateStatus()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			for _, key := range keys {
+				delete(g.held, key)
+			}
+			close(g.released)
+			g.released = make(chan struct{})
+			g.mu.Unlock()
+			websocketHub.UpdateStatus()
+		})
+	}, nil
+}
+
+// check returns a tncBusyError if any of the keys are held. Must be called with g.mu held.
+func (g *sessionGuard) check(keys []string) error {
+	for _, key := range keys {
+		if owner, ok := g.held[key]; ok {
+			return tncBusyError{key, owner}
+		}
+	}
+	return nil
+}
+
+// checkRig returns a tncBusyError if the given rig is in use by a session of another transport.
+func (g *sessionGuard) checkRig(rigName, transport string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := rigKeyPrefix + rigName
+	if owner, ok := g.held[key]; ok && owner.transport != transport {
+		return tncBusyError{key, owner}
+	}
+	return nil
+}
+
+// RigOwners returns the transport of the session holding each rig in use.
+func (g *sessionGuard) RigOwners() map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	owners := make(map[string]string)
+	for key, owner := range g.held {
+		if strings.HasPrefix(key, rigKeyPrefix) {
+			owners[strings.TrimPrefix(key, rigKeyPrefix)] = owner.transport
+		}
+	}
+	return owners
+}
+
+// Held returns the (sorted) keys of the TNCs and rigs currently in use by a session.
+func (g *sessionGuard) Held() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	keys := make([]string, 0, len(g.held))
+	for key := range g.held {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+func TestSessionGuard(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Ardop.Addr = "localhost:8515"
+	config.Ardop.Rig = "ft991"
+	config.VaraHF.Rig = "ft991"
+	defer func(h *ListenerHub) { listenHub = h }(listenHub)
+	listenHub = NewListenerHub()
+
+	g := &sessionGuard{held: make(map[string]sessionOwner), released: make(chan struct{})}
+	ardopURL, _ := transport.ParseURL("ardop:///LA1B")
+	varaURL, _ := transport.ParseURL("varahf:///LA2B")
+	telnetURL, _ := transport.ParseURL("telnet:///LA1B")
+
+	release, err := g.tryAcquire(ardopURL)
+	if err != nil {
+		t.Fatalf("tryAcquire(%s): unexpected error: %s", ardopURL, err)
+	}
+	if got, want := g.Held(), []string{"ardop:localhost:8515", "rig:ft991"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Held() = %q, want %q", got, want)
+	}
+	if got, want := g.RigOwners(), map[string]string{"ft991": MethodArdop}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RigOwners() = %v, want %v", got, want)
+	}
+
+	// The TNC and the rig shared with varahf are busy
+	if _, err := g.tryAcquire(ardopURL); err != (tncBusyError{"ardop:localhost:8515", sessionOwner{sessionOutbound, MethodArdop}}) {
+		t.Errorf("tryAcquire(%s): got error %v", ardopURL, err)
+	}
+	if _, err := g.tryAcquire(varaURL); err != (tncBusyError{"rig:ft991", sessionOwner{sessionOutbound, MethodArdop}}) {
+		t.Errorf("tryAcquire(%s): got error %v", varaURL, err)
+	}
+	if err := g.checkRig("ft991", MethodVaraHF); err == nil {
+		t.Error("checkRig(ft991, varahf): expected error")
+	}
+	if err := g.checkRig("ft991", MethodArdop); err != nil {
+		t.Errorf("checkRig(ft991, ardop): unexpected error: %s", err)
+	}
+
+	// Transports without a TNC or rig are not guarded
+	releaseTelnet, err := g.tryAcquire(telnetURL)
+	if err != nil {
+		t.Errorf("tryAcquire(%s): unexpected error: %s", telnetURL, err)
+	}
+	releaseTelnet()
+
+	release()
+	release() // No-op
+	if held := g.Held(); len(held) != 0 {
+		t.Errorf("Held() after release = %q, want none", held)
+	}
+	release, err = g.tryAcquire(varaURL)
+	if err != nil {
+		t.Fatalf("tryAcquire(%s) after release: unexpected error: %s", varaURL, err)
+	}
+	release()
+}
+
+func TestSessionGuardWaitInbound(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Ardop.Addr = "localhost:8515"
+	defer func(h *ListenerHub) { listenHub = h }(listenHub)
+	listenHub = NewListenerHub()
+
+	g := &sessionGuard{held: make(map[string]sessionOwner), released: make(chan struct{})}
+	url, _ := transport.ParseURL("ardop:///LA1B")
+	releaseInbound, err := g.acquire(context.Background(), url, sessionInbound, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inbound sessions are not waited for without a wait duration
+	if _, err := g.acquire(context.Background(), url, sessionOutbound, 0); err == nil {
+		t.Error("acquire without wait: expected error")
+	}
+	if _, err := g.acquire(context.Background(), url, sessionOutbound, 10*time.Millisecond); err == nil {
+		t.Error("acquire after wait timeout: expected error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.acquire(ctx, url, sessionOutbound, time.Minute); err != context.Canceled {
+		t.Errorf("acquire with cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+
+	// Acquired once the inbound session ends
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releaseInbound()
+	}()
+	release, err := g.acquire(context.Background(), url, sessionOutbound, time.Minute)
+	if err != nil {
+		t.Fatalf("acquire: unexpected error: %s", err)
+	}
+	defer release()
+
+	// Outbound sessions are never waited for
+	if _, err := g.acquire(context.Background(), url, sessionOutbound, time.Minute); err == nil {
+		t.Error("acquire while in use by an outbound session: expected error")
+	}
+}
+
+func TestSessionGuardConcurrent(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Ardop.Addr = "localhost:8515"
+	defer func(h *ListenerHub) { listenHub = h }(listenHub)
+	listenHub = NewListenerHub()
+
+	g := &sessionGuard{held: make(map[string]sessionOwner), released: make(chan struct{})}
+	url, _ := transport.ParseURL("ardop:///LA1B")
+
+	// Concurrent connects on the same TNC, each holding the guard for a while
+	var active, maxActive, sessions int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				release, err := g.tryAcquire(url)
+				if _, busy := err.(tncBusyError); busy {
+					continue
+				} else if err != nil {
+					t.Errorf("tryAcquire: unexpected error: %s", err)
+					return
+				}
+				n := atomic.AddInt32(&active, 1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+						break
+					}
+				}
+				atomic.AddInt32(&sessions, 1)
+				runtime.Gosched()
+				atomic.AddInt32(&active, -1)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxActive != 1 {
+		t.Errorf("Got %d concurrent sessions on the same TNC, want 1", maxActive)
+	}
+	if sessions == 0 {
+		t.Error("No connect acquired the TNC")
+	}
+}
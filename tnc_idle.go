@@ -0,0 +1,223 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+	"github.com/la5nta/pat/internal/vara"
+)
+
+// tncIdle closes TNCs that have not been used for longer than their configured idle timeout.
+var tncIdle = &idleCloser{
+	users:  make(map[string]int),
+	timers: make(map[string]*idleTimer),
+}
+
+type idleCloser struct {
+	mu     sync.Mutex
+	users  map[string]int        // Number of users by TNC key.
+	timers map[string]*idleTimer // Pending idle timers by TNC key.
+}
+
+type idleTimer struct {
+	*time.Timer
+	closeFn func()
+}
+
+// acquire marks the TNC used by the given URL as in use, until the returned release func is called.
+//
+// When the TNC is no longer in use, it is closed after the idle timeout given by its config (if any).
+// Active listeners keep the TNC open.
+func (c *idleCloser) acquire(url *transport.URL) (release func()) {
+	key, timeout, closeFn := tncIdleConfig(url)
+	if key == "" {
+		return func() {}
+	}
+
+	c.mu.Lock()
+	c.users[key]++
+	if t, ok := c.timers[key]; ok {
+		t.Stop()
+		delete(c.timers, key)
+	}
+	c.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { c.release(key, timeout, closeFn) }) }
+}
+
+func (c *idleCloser) release(key string, timeout time.Duration, closeFn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.users[key]--; c.users[key] > 0 || timeout <= 0 {
+		return
+	}
+	delete(c.users, key)
+
+	t := &idleTimer{closeFn: closeFn}
+	t.Timer = time.AfterFunc(timeout, func() {
+		c.mu.Lock()
+		if c.timers[key] != t {
+			c.mu.Unlock()
+			return // Stopped or replaced
+		}
+		delete(c.timers, key)
+		c.mu.Unlock()
+
+		closeFn()
+	})
+	c.timers[key] = t
+}
+
+// touch (re)starts the idle timer of the TNC used by the given URL, unless it is in use.
+func (c *idleCloser) touch(url *transport.URL) { c.acquire(url)() }
+
+// closeIfUnused closes the TNC used by the given URL immediately, unless it is in use (or kept open by a listener).
+func (c *idleCloser) closeIfUnused(url *transport.URL) {
+	key, _, closeFn := tncIdleConfig(url)
+	if key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	if c.users[key] > 0 {
+		c.mu.Unlock()
+		return
+	}
+	if t, ok := c.timers[key]; ok {
+		t.Stop()
+		delete(c.timers, key)
+	}
+	c.mu.Unlock()
+
+	closeFn()
+}
+
+// closeNow stops all pending idle timers, and closes their TNCs immediately.
+//
+// TNCs that are in use are left open.
+func (c *idleCloser) closeNow() {
+	c.mu.Lock()
+	timers := c.timers
+	c.timers = make(map[string]*idleTimer)
+	c.mu.Unlock()
+
+	for _, t := range timers {
+		if t.Stop() {
+			t.closeFn()
+		}
+	}
+}
+
+// tncIdleConfig returns the key, idle timeout and close func of the TNC used by the given URL.
+//
+// The key is empty if the transport has no TNC that should be closed when idle.
+func tncIdleConfig(url *transport.URL) (key string, timeout time.Duration, closeFn func()) {
+	switch url.Scheme {
+	case MethodWinmor:
+		return MethodWinmor, time.Duration(config.Winmor.IdleTimeout) * time.Second, closeIdleWinmorTNC
+	case MethodArdop:
+		conf := ardopConfigOrDefault(url)
+		return MethodArdop + ":" + ardopKey(conf), time.Duration(conf.IdleTimeout) * time.Second, func() { closeIdleArdopTNC(conf) }
+	case MethodVaraHF:
+		return MethodVaraHF, time.Duration(config.VaraHF.IdleTimeout) * time.Second, func() { closeIdleVaraModem(&varaHFTNC, MethodVaraHF, "VARA HF") }
+	case MethodVaraFM:
+		return MethodVaraFM, time.Duration(config.VaraFM.IdleTimeout) * time.Second, func() { closeIdleVaraModem(&varaFMTNC, MethodVaraFM, "VARA FM") }
+	default:
+		return "", 0, nil
+	}
+}
+
+// unregisterDialer replaces the dialer registered for scheme with one that always fails, so that a closed
+// TNC is never used for dialing. The TNC's init func registers it again.
+func unregisterDialer(scheme string) { transport.RegisterDialer(scheme, closedDialer(scheme)) }
+
+type closedDialer string
+
+func (d closedDialer) DialURL(url *transport.URL) (net.Conn, error) {
+	return nil, fmt.Errorf("%s TNC is closed", string(d))
+}
+
+// isListening returns true if there is an active listener matching the given func.
+func isListening(match func(TransportListener) bool) bool {
+	for _, l := range listenHub.Active() {
+		if match(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func closeIdleWinmorTNC() {
+	if isListening(func(l TransportListener) bool { return l.Name() == MethodWinmor }) {
+		return
+	}
+
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	if wmTNC == nil {
+		return
+	}
+
+	log.Println("Closing idle WINMOR TNC")
+	wmTNC.Close()
+	wmTNC = nil
+	unregisterDialer(MethodWinmor)
+}
+
+func closeIdleArdopTNC(conf cfg.ArdopConfig) {
+	key := ardopKey(conf)
+	if isListening(func(l TransportListener) bool {
+		al, ok := l.(ARDOPListener)
+		if !ok {
+			return false
+		}
+		lconf, _ := ardopConfig(al.Instance)
+		return ardopKey(lconf) == key
+	}) {
+		return
+	}
+
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	tnc, ok := ardopTNCs[key]
+	if !ok {
+		return
+	}
+
+	log.Printf("Closing idle ARDOP TNC (%s)", key)
+	tnc.Close()
+	delete(ardopTNCs, key)
+	if key == ardopKey(config.Ardop) {
+		adTNC = nil
+		unregisterDialer(MethodArdop)
+	}
+}
+
+func closeIdleVaraModem(m **vara.Modem, scheme, name string) {
+	if isListening(func(l TransportListener) bool { return l.Name() == scheme }) {
+		return
+	}
+
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	if *m == nil {
+		return
+	}
+
+	log.Printf("Closing idle %s modem", name)
+	(*m).Close()
+	*m = nil
+	unregisterDialer(scheme)
+}
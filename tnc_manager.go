@@ -0,0 +1,160 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/internal/kiss"
+)
+
+// TNC is a TNC (or modem) used by a transport.
+//
+// Most TNCs also implement transport.BusyChannelChecker and PTT control (SetPTT).
+type TNC interface {
+	Close() error
+}
+
+// tncUnavailableError is returned by TNC init funcs when the TNC could not be opened (e.g. the TNC
+// is not running, or the sound card or serial device is missing or busy), as opposed to errors due
+// to bad config.
+type tncUnavailableError struct{ error }
+
+func isTNCUnavailable(err error) bool {
+	_, ok := err.(tncUnavailableError)
+	return ok
+}
+
+// TNCInitFunc initializes the TNC used by the given URL and returns it.
+//
+// If the TNC is already initialized and still responding, it should be reused. The returned TNC
+// is nil for transports that only needs to be prepared (e.g. by registering a dialer).
+type TNCInitFunc func(url *transport.URL) (TNC, error)
+
+// TNCManager maps transport schemes to the init func of the TNC used by the transport.
+type TNCManager struct {
+	mu    sync.RWMutex
+	inits map[string]TNCInitFunc
+}
+
+func NewTNCManager() *TNCManager {
+	return &TNCManager{inits: make(map[string]TNCInitFunc)}
+}
+
+// Register sets the init func of the TNC used by scheme, replacing any previously registered.
+func (m *TNCManager) Register(scheme string, fn TNCInitFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inits[scheme] = fn
+}
+
+// Has returns true if an init func is registered for scheme.
+func (m *TNCManager) Has(scheme string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.inits[scheme]
+	return ok
+}
+
+// Ensure initializes (or reuses) the TNC used by the given URL's transport.
+//
+// A nil TNC is returned for transports without a TNC.
+func (m *TNCManager) Ensure(url *transport.URL) (TNC, error) {
+	m.mu.RLock()
+	fn, ok := m.inits[url.Scheme]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	tnc, err := fn(url)
+	if err != nil {
+		return nil, err
+	}
+	return tnc, nil
+}
+
+// tncs holds the TNC init funcs of all transports using a TNC or modem.
+var tncs = NewTNCManager()
+
+func init() {
+	tncs.Register(MethodArdop, func(url *transport.URL) (TNC, error) {
+		conf, err := ardopConfigForURL(url)
+		if err != nil {
+			return nil, err
+		}
+		tnc, err := openArdopTNC(conf)
+		if err != nil {
+			return nil, err
+		}
+		return tnc, nil
+	})
+	tncs.Register(MethodWinmor, func(*transport.URL) (TNC, error) {
+		if err := initWinmorTNC(); err != nil {
+			return nil, err
+		}
+		return lockedTNC(func() TNC { return wmTNC }), nil
+	})
+	tncs.Register(MethodPactor, func(url *transport.URL) (TNC, error) {
+		if err := initPactorModem(url.Host, url.Params.Get("hbaud")); err != nil {
+			return nil, err
+		}
+		return lockedTNC(func() TNC { return pModem }), nil
+	})
+	tncs.Register(MethodVaraHF, func(*transport.URL) (TNC, error) {
+		if err := initVaraHFTNC(); err != nil {
+			return nil, err
+		}
+		return lockedTNC(func() TNC { return varaHFTNC }), nil
+	})
+	tncs.Register(MethodVaraFM, func(*transport.URL) (TNC, error) {
+		if err := initVaraFMTNC(); err != nil {
+			return nil, err
+		}
+		return lockedTNC(func() TNC { return varaFMTNC }), nil
+	})
+	tncs.Register(MethodAX25, func(*transport.URL) (TNC, error) {
+		return initAX25BusyChecker()
+	})
+	tncs.Register(MethodSerialTNC, func(*transport.URL) (TNC, error) {
+		logDCDUnsupported(MethodSerialTNC, "no carrier detect from the serial TNC")
+		return nil, nil
+	})
+	tncs.Register(MethodAGWPE, func(*transport.URL) (TNC, error) {
+		transport.RegisterDialer(MethodAGWPE, agwpeClient())
+		return nil, nil
+	})
+}
+
+// lockedTNC returns the TNC returned by fn while holding tncMu.
+func lockedTNC(fn func() TNC) TNC {
+	tncMu.Lock()
+	defer tncMu.Unlock()
+	return fn()
+}
+
+// exclusiveTNCKey returns a key identifying the TNC used by the given URL, if the TNC can only serve one session
+// at a time (see sessionKeys). The key is empty for other transports.
+func exclusiveTNCKey(url *transport.URL) string {
+	switch url.Scheme {
+	case MethodWinmor, MethodVaraHF, MethodVaraFM, MethodPactor:
+		return url.Scheme
+	case MethodArdop:
+		return MethodArdop + ":" + ardopKey(ardopConfigOrDefault(url))
+	case MethodKISSTCP:
+		addr := url.Host
+		if addr == "" {
+			addr = config.KISSTCP.Host
+		}
+		if addr == "" {
+			addr = kiss.DefaultAddr
+		}
+		return MethodKISSTCP + ":" + addr
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/la5nta/wl2k-go/transport"
+
+	"github.com/la5nta/pat/cfg"
+)
+
+type fakeTNC struct{ closed bool }
+
+func (t *fakeTNC) Close() error { t.closed = true; return nil }
+
+func TestTNCManager(t *testing.T) {
+	m := NewTNCManager()
+
+	// Opened once and reused, like the init funcs of the real TNCs
+	var opened int
+	var tnc *fakeTNC
+	m.Register("fake", func(*transport.URL) (TNC, error) {
+		if tnc == nil {
+			opened++
+			tnc = &fakeTNC{}
+		}
+		return tnc, nil
+	})
+	errUnavailable := tncUnavailableError{errors.New("TNC not running")}
+	m.Register("broken", func(*transport.URL) (TNC, error) { return nil, errUnavailable })
+
+	for i := 0; i < 2; i++ {
+		got, err := m.Ensure(&transport.URL{Scheme: "fake"})
+		if err != nil {
+			t.Fatalf("Ensure: unexpected error: %s", err)
+		}
+		if got != tnc {
+			t.Errorf("Ensure: got %v, want %v", got, tnc)
+		}
+	}
+	if opened != 1 {
+		t.Errorf("TNC opened %d times, want 1", opened)
+	}
+
+	if got, err := m.Ensure(&transport.URL{Scheme: "broken"}); got != nil || err != errUnavailable {
+		t.Errorf("Ensure(broken) = %v, %v, want nil, %v", got, err, errUnavailable)
+	}
+	if !isTNCUnavailable(errUnavailable) || isTNCUnavailable(errors.New("bad config")) {
+		t.Error("isTNCUnavailable: unexpected result")
+	}
+
+	if got, err := m.Ensure(&transport.URL{Scheme: "telnet"}); got != nil || err != nil {
+		t.Errorf("Ensure(telnet) = %v, %v, want nil, nil", got, err)
+	}
+	if !m.Has("fake") || m.Has("telnet") {
+		t.Error("Has: unexpected result")
+	}
+
+	// Register replaces the previous init func
+	m.Register("fake", func(*transport.URL) (TNC, error) { return nil, nil })
+	if got, _ := m.Ensure(&transport.URL{Scheme: "fake"}); got != nil {
+		t.Errorf("Ensure after Register: got %v, want nil", got)
+	}
+}
+
+func TestExclusiveTNCKey(t *testing.T) {
+	defer func(c cfg.Config) { config = c }(config)
+	config = cfg.Config{}
+	config.Ardop.Addr = "localhost:8515"
+	config.ArdopInstances = map[string]cfg.ArdopConfig{"second": {Addr: "localhost:8520"}}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"ardop:///LA1B", "ardop:localhost:8515"},
+		{"ardop:///LA1B?tnc=second", "ardop:localhost:8520"},
+		{"ardop://10.0.0.2:8515/LA1B", "ardop:10.0.0.2:8515"},
+		{"winmor:///LA1B", "winmor"},
+		{"varahf:///LA1B", "varahf"},
+		{"kiss-tcp:///LA1B", "kiss-tcp:localhost:8001"},
+		{"kiss-tcp://10.0.0.2:8001/LA1B", "kiss-tcp:10.0.0.2:8001"},
+		{"telnet:///LA1B", ""},
+	}
+	for _, tt := range tests {
+		url, err := transport.ParseURL(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exclusiveTNCKey(url); got != tt.want {
+			t.Errorf("exclusiveTNCKey(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
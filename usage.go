@@ -5,43 +5,107 @@
 package main
 
 var (
-	UsageConnect = `'alias' or 'transport://[host][/digi]/targetcall[?params...]'
+	UsageConnect = `[options] 'alias' or 'transport://[host][/digi]/targetcall[?params...]' [...]
+
+  Multiple aliases/URLs may be given. They are tried in order until one succeeds.
+
+  URL parameters may be appended to an alias (e.g. 'LA3F?freq=7064'). They take precedence
+  over parameters with the same name set by the alias.
 
 transport:
   winmor:     WINMOR TNC
   ardop:      ARDOP TNC
+  varahf:     VARA HF modem (alias: vara)
+  varafm:     VARA FM modem
   ax25:       AX.25 (Linux only)
   telnet:     TCP/IP
+  telnets:    TCP/IP over TLS (same as telnet with ?tls=true)
   serial-tnc: Serial AX.25 TNC
+  agwpe:      AX.25 via an AGWPE compatible TCP port (e.g. Direwolf)
+  kiss-tcp:   AX.25 via a KISS TNC over TCP (e.g. Direwolf, also kisstcp)
   pactor:     SCS PTC modems
+  rms:        RMS gateway by callsign (e.g. rms:LA1B-10). Transport and frequency are looked up in the RMS list.
 
 host:
   Used to address the host interface (TNC/modem), _not_ to be confused with the connection PATH.
     Format: [user[:pass]@]host[:port]
 
   telnet:       [user:pass]@host:port
+  telnets:      [user:pass]@host:port
+                A comma-separated host list (e.g. host1,host2:port) is tried in turn (telnet also tries all addresses of each host).
   ax25:         (optional) host=axport
   pactor:       (optional) serial device (e.g. COM1 or /dev/ttyUSB0)
+  agwpe:        (optional) host:port of the AGWPE TCP port
+  kiss-tcp:     (optional) host:port of the KISS TCP port
+  ardop:        (optional) host:port of the ARDOP TNC
 
 path:
   The last element of the path is the target station's callsign. If the path has
    multiple hops (e.g. AX.25), they are separated by '/'.
 
 params:
-  ?freq=        Sets QSY frequency (winmor, ardop and ax25 only)
+  ?freq=        Sets QSY frequency (winmor, ardop, varahf, varafm, ax25, agwpe and kiss-tcp only)
+                The unit is kHz unless given (e.g. freq=7064, freq=7.064MHz or freq=7064kHz).
+                Use @name for a channel defined in the config's channels (e.g. freq=@40m-winlink). The channel's
+                 scheme is used if the connect string has none (e.g. 'LA1B?freq=@40m-winlink').
+  ?rig=         Selects the rig (from hamlib_rigs) used for QSY and PTT, overriding the transport's config.
+  ?mode=        Sets the rig mode on QSY (e.g. USB or PKTUSB, rigctld rigs only). The previous mode is restored after the connect.
+  ?center=      Set to true if freq is a center frequency, to apply the rig's dial offset (freq_offset_hz).
+  ?settle=      Overrides the rig's QSY settle delay (e.g. 5s).
   ?host=        Overrides the host part of the path. Useful for serial-tnc to specify e.g. /dev/ttyS0.
+  ?busy_timeout= Maximum time to wait for a clear channel (e.g. 90s, winmor, ardop, vara and ax25 only).
+  ?busy_clear_window= Time the channel must stay clear before connecting (e.g. 5s).
+  ?ignore_busy= Set to true to skip the busy channel check (overrides --ignore-busy).
+  ?dial_timeout= Maximum duration of the connect (dial) phase, overriding the transport's connect_timeout (e.g. 2m).
+  ?retries=     Number of times to retry the connect if it times out.
+  ?retry_backoff= Delay between retries (e.g. 30s).
+  ?databits=, ?parity=, ?stopbits=, ?flow=
+                Serial line settings (serial-tnc only, e.g. parity=even&flow=rtscts).
+  ?via=         Comma-separated digipeater path, in order (packet only, e.g. via=LD5HU,WIDE2-1).
+  ?radio_port=  AGWPE radio port number (agwpe only).
+  ?kiss_port=   KISS port number (kiss-tcp only).
+  ?hbaud=       Baudrate of the host interface (serial-tnc and pactor only).
+  ?bw=          ARQ bandwidth for this connect (e.g. 500MAX or 2000FORCED, ardop only).
+  ?robust=      Set to true to use robust modes only (ardop only, see --robust).
+  ?cwid=        Set to true/false to override the configured CWID setting (ardop only).
+  ?p2p=         Set to true to only send messages addressed to the remote station (P2P), or false to hold back P2P only messages (CMS).
+  ?tls=         Set to true to encrypt the telnet connection using TLS (same as telnets://).
+  ?insecure=    Set to true to skip TLS certificate verification (telnets only, e.g. self-signed P2P endpoints).
+  ?tnc=         Name of the ARDOP TNC to use, as defined in ardop_instances (ardop only).
+
+exit codes:
+  0  The exchange completed.
+  1  Other errors (e.g. config, unknown alias, TNC initialization or QSY).
+  2  The connection could not be established.
+  3  Gave up waiting for a clear channel (busy_timeout).
+  4  The connection was established, but the exchange failed.
+  If multiple aliases/URLs are given, the code reflects the last attempt.
+  With --from-file, the code is 1 if any of the connects failed.
 `
 	ExampleConnect = `
   connect telnet                     (alias) Connect to one of the Winlink Common Message Servers via tcp.
+  connect telnets                    (alias) Same as above, but encrypted using TLS.
+  connect 'LA3F?freq=7064'           (alias) Connect using alias LA3F, overriding the alias' frequency.
   connect ax25:///LA1B-10            Connect to the RMS Gateway LA1B-10 using Linux AX.25 on the default axport.
   connect ax25://tmd710/LA1B-10      Connect to the RMS Gateway LA1B-10 using Linux AX.25 on axport 'tmd710'.
   connect ax25:///LA1B/LA5NTA        Peer-to-peer connection with LA5NTA via LA1B digipeater.
+  connect ax25:///LD5GR?via=LD5HU    Connect to LD5GR via the LD5HU digipeater.
   connect winmor:///LA3F             Connect to the RMS HF Gateway LA3F using WINMOR TNC on default tcp address and port.
   connect winmor:///LA3F?freq=5350   Same as above, but set dial frequency of the radio using rigcontrol.
   connect ardop:///LA3F              Connect to the RMS HF Gateway LA3F using ARDOP on the default tcp address and port.
   connect ardop:///LA3F?freq=5350    Same as above, but set dial frequency of the radio using rigcontrol.  
+  connect varahf:///LA3F?freq=5350   Connect to the RMS HF Gateway LA3F using the VARA HF modem, setting the dial frequency.
+  connect varafm:///LA1B-10          Connect to the RMS Gateway LA1B-10 using the VARA FM modem.
   connect serial-tnc:///LA1B-10      Connect to the RMS Gateway LA1B-10 over a AX.25 serial TNC on the default serial port.
+  connect agwpe:///LA1B-10           Connect to the RMS Gateway LA1B-10 using the AGWPE TCP port on the default radio port.
+  connect kiss-tcp:///LA1B-10        Connect to the RMS Gateway LA1B-10 using the KISS TCP port on the default address.
   connect pactor:///LA3F             Connect to RMS HF Gateway LA3F using PACTOR.
+  connect rms:LA3F                   Connect to RMS HF Gateway LA3F, trying each of its channels listed in the RMS list.
+  connect -p ardop:///LA3F telnet    Dial LA3F (ARDOP) and CMS (telnet) concurrently, use the first to connect.
+  connect home                       Try each link of the fallback chain "home" (see connect_fallbacks) in turn.
+  connect auto:ardop                 Try the ardop connect strings that succeeded before, most recent first (see auto_connect).
+  connect --dry-run LA3F             Show what connecting to alias LA3F would do (rig, QSY, TNC) without transmitting.
+  connect --from-file targets.txt    Connect to each target listed in targets.txt (one per line, # for comments) in turn.
 `
 )
 
@@ -53,3 +117,10 @@ var (
   position --latlon -10.123,-60.123  Send position 10.123S 060.123W.
 `
 )
+
+var (
+	ExampleLint = `
+  lint                               Check all connect aliases, printing a pass/fail report.
+  lint LA3F winmor:///LA3F?freq=5350 Check the alias LA3F and the given URL.
+`
+)
@@ -4,8 +4,22 @@
 
 package main
 
-import "unicode"
+import (
+	"log"
+	"os"
+	"unicode"
+)
 
 func SplitFunc(c rune) bool {
 	return unicode.IsSpace(c) || c == ',' || c == ';'
 }
+
+// debugEnabled enables debug logging (see debugf). Set by the environment variable PAT_DEBUG.
+var debugEnabled = os.Getenv("PAT_DEBUG") != ""
+
+// debugf logs the formatted message if debug logging is enabled.
+func debugf(format string, v ...interface{}) {
+	if debugEnabled {
+		log.Printf("[DEBUG] "+format, v...)
+	}
+}
@@ -40,9 +40,14 @@ func NewWSHub() *WSHub {
 	return w
 }
 
-func (w *WSHub) UpdateStatus()                    { w.WriteJSON(struct{ Status Status }{getStatus()}) }
-func (w *WSHub) WriteProgress(p Progress)         { w.WriteJSON(struct{ Progress Progress }{p}) }
-func (w *WSHub) WriteNotification(n Notification) { w.WriteJSON(struct{ Notification Notification }{n}) }
+func (w *WSHub) UpdateStatus()                            { w.WriteJSON(struct{ Status Status }{getStatus()}) }
+func (w *WSHub) WriteProgress(p Progress)                 { w.WriteJSON(struct{ Progress Progress }{p}) }
+func (w *WSHub) WriteNotification(n Notification)         { w.WriteJSON(struct{ Notification Notification }{n}) }
+func (w *WSHub) WriteSessionSummary(s SessionSummary)     { w.WriteJSON(struct{ SessionSummary SessionSummary }{s}) }
+func (w *WSHub) WriteTransferProgress(p TransferProgress) { w.WriteJSON(struct{ TransferProgress TransferProgress }{p}) }
+func (w *WSHub) WriteInboundEvent(e InboundEvent)         { w.WriteJSON(struct{ InboundEvent InboundEvent }{e}) }
+func (w *WSHub) WriteTNCEvent(e TNCEvent)                 { w.WriteJSON(struct{ TNCEvent TNCEvent }{e}) }
+func (w *WSHub) WriteLinkQuality(q LinkQuality)           { w.WriteJSON(struct{ LinkQuality LinkQuality }{q}) }
 
 func (w *WSHub) Prompt(p Prompt) {
 	w.WriteJSON(struct{ Prompt Prompt }{p})